@@ -8,12 +8,21 @@ package client
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/juju/loggo"
 
 	"github.com/makyo/stimmtausch/config"
 	"github.com/makyo/stimmtausch/connection"
+	"github.com/makyo/stimmtausch/plugin"
+	"github.com/makyo/stimmtausch/scripting"
 	"github.com/makyo/stimmtausch/signal"
+	"github.com/makyo/stimmtausch/styled"
 )
 
 var log = loggo.GetLogger("stimmtausch.client")
@@ -32,7 +41,39 @@ type Client struct {
 	listener chan signal.Signal
 
 	// All active connections.
-	connections map[string]*connection.Connection
+	connections   map[string]*connection.Connection
+	connectionsMu sync.RWMutex
+
+	// Connections which were made after a previous connection by the same
+	// name was closed, until they've connected. This is guarded by
+	// connectionsMu, too.
+	reconnecting map[string]bool
+
+	// The scripting runtime.
+	scripts *scripting.Runtime
+
+	// The plugin manager.
+	plugins *plugin.Manager
+
+	// Whether or not notifications are turned off, and when each world last
+	// notified the user.
+	doNotDisturb bool
+	notified     map[string]time.Time
+	notifyMu     sync.Mutex
+
+	// The characters being watched for in each world, and those each world
+	// has announced are online since it was connected.
+	watching map[string]map[string]*watched
+	online   map[string]map[string]bool
+}
+
+// hookEvents maps the signals for events in a connection's life to the
+// events for which worlds may have hooks.
+var hookEvents = map[string]string{
+	"_client:connected":    config.HookConnected,
+	"_client:reconnected":  config.HookReconnected,
+	"_client:disconnected": config.HookDisconnected,
+	"_client:loggedIn":     config.HookLoggedIn,
 }
 
 // connectToWorld takes a given world and a connection name and creates a new
@@ -44,7 +85,12 @@ func (c *Client) connectToWorld(connectStr string, w config.World) (*connection.
 		log.Errorf("error connecting to world %s. %v", w.Name, err)
 		return nil, err
 	}
+	c.connectionsMu.Lock()
+	if old, ok := c.connections[connectStr]; ok && !old.IsConnected() {
+		c.reconnecting[connectStr] = true
+	}
 	c.connections[connectStr] = conn
+	c.connectionsMu.Unlock()
 
 	return conn, nil
 }
@@ -101,15 +147,90 @@ func (c *Client) Connect(connectStr string) (*connection.Connection, error) {
 	return conn, nil
 }
 
-func (c *Client) Conn(name string) (*connection.Connection, bool) {
+// Replay creates a new connection which plays back the recording at the given
+// path rather than connecting to a server.
+func (c *Client) Replay(path string, speed float64) (*connection.Connection, error) {
+	name := "replay-" + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	log.Tracef("replaying %s as %s", path, name)
+	conn, err := connection.NewReplayConnection(name, path, speed, c.Config, c.Env)
+	if err != nil {
+		log.Errorf("unable to replay %s: %v", path, err)
+		return nil, err
+	}
+	c.connectionsMu.Lock()
+	c.connections[name] = conn
+	c.connectionsMu.Unlock()
+	return conn, nil
+}
+
+// conn returns the connection with the given name.
+func (c *Client) conn(name string) (*connection.Connection, bool) {
+	c.connectionsMu.RLock()
+	defer c.connectionsMu.RUnlock()
 	conn, ok := c.connections[name]
 	return conn, ok
 }
 
+// reconnected returns whether the connection with the given name replaced
+// one which had been closed, forgetting that it did so.
+func (c *Client) reconnected(name string) bool {
+	c.connectionsMu.Lock()
+	defer c.connectionsMu.Unlock()
+	if !c.reconnecting[name] {
+		return false
+	}
+	delete(c.reconnecting, name)
+	return true
+}
+
+func (c *Client) Conn(name string) (*connection.Connection, bool) {
+	return c.conn(name)
+}
+
+// ConnNames returns the names of all connections, sorted.
+func (c *Client) ConnNames() []string {
+	c.connectionsMu.RLock()
+	defer c.connectionsMu.RUnlock()
+	var names []string
+	for name := range c.connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Send sends each line to the connection with the given name.
+func (c *Client) Send(name string, lines ...string) {
+	conn, ok := c.conn(name)
+	if !ok {
+		log.Warningf("asked to send to %s, but could not find it", name)
+		return
+	}
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			log.Errorf("unable to send to %s. %v", name, err)
+			return
+		}
+	}
+}
+
+// Echo shows each line to the user in the connection with the given name
+// without sending it.
+func (c *Client) Echo(name string, lines ...string) {
+	conn, ok := c.conn(name)
+	if !ok {
+		log.Warningf("asked to echo to %s, but could not find it", name)
+		return
+	}
+	for _, line := range lines {
+		conn.Echo(line)
+	}
+}
+
 // Close will close a connection with the given name (usually the connectStr).
 func (c *Client) Close(name string) {
 	log.Tracef("closing connection %s", name)
-	conn, ok := c.connections[name]
+	conn, ok := c.conn(name)
 	if ok {
 		conn.Close()
 	} else {
@@ -120,11 +241,126 @@ func (c *Client) Close(name string) {
 // CloseAll will attempt to close all open connections.
 func (c *Client) CloseAll() {
 	log.Tracef("closing all connections")
+	c.connectionsMu.RLock()
+	var conns []*connection.Connection
 	for _, conn := range c.connections {
+		conns = append(conns, conn)
+	}
+	c.connectionsMu.RUnlock()
+	for _, conn := range conns {
 		conn.Close()
 	}
 }
 
+// aliasList describes each alias, one per line.
+func (c *Client) aliasList() string {
+	var lines []string
+	for _, a := range c.Config.AliasList() {
+		line := fmt.Sprintf("%s: %s => %s", a.Name, a.Match, a.Replace)
+		if a.World != "" {
+			line += fmt.Sprintf(" (%s only)", a.World)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No aliases."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// triggerList describes each trigger in the order they're run, one per line.
+func (c *Client) triggerList() string {
+	var lines []string
+	for _, t := range c.Config.TriggerList() {
+		line := fmt.Sprintf("%s (%s): %s", t.Label(), t.Type, strings.Join(t.Matches, ", "))
+		if t.World != "" {
+			line += fmt.Sprintf(" (%s only)", t.World)
+		}
+		if t.Disabled {
+			line += " (disabled)"
+		}
+		if expiresAt := t.ExpiresAt(); !expiresAt.IsZero() {
+			line += fmt.Sprintf(" (expires in %s)", time.Until(expiresAt).Round(time.Second))
+		}
+		if t.MaxFires > 0 {
+			line += fmt.Sprintf(" - %d of %d matches", t.Fires(), t.MaxFires)
+		} else {
+			line += fmt.Sprintf(" - %d matches", t.Fires())
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No triggers."
+	}
+	return strings.Join(lines, "\n")
+}
+
+// triggerTest runs the triggers against a line without taking any further
+// action, describing which match and what the line would look like.
+func (c *Client) triggerTest(world, input string) string {
+	line := styled.Parse(input)
+	var matched []string
+	gagged := false
+	for _, t := range c.Config.TriggerList() {
+		if !t.RunStyled(world, line, c.Config) {
+			continue
+		}
+		matched = append(matched, fmt.Sprintf("%s (%s)", t.Label(), t.Type))
+		if t.Type == "gag" {
+			gagged = true
+		}
+		if !t.FallsThrough() {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return "No triggers match."
+	}
+	result := line.ANSI()
+	if gagged {
+		result = "(gagged)"
+	}
+	return fmt.Sprintf("Matches:\n    %s\n\nResult:\n    %s", strings.Join(matched, "\n    "), result)
+}
+
+// addTrigger adds a trigger from the arguments to /trigger add, using the
+// value as whatever the trigger's type needs.
+func (c *Client) addTrigger(world, name, triggerType, match, value, expiresAfter, maxFires string) error {
+	t := config.Trigger{
+		Name:         name,
+		Type:         triggerType,
+		World:        world,
+		Match:        match,
+		ExpiresAfter: expiresAfter,
+	}
+	if maxFires != "" {
+		n, err := strconv.Atoi(maxFires)
+		if err != nil {
+			return err
+		}
+		t.MaxFires = n
+	}
+	switch triggerType {
+	case "hilite":
+		t.Attributes = value
+	case "replace":
+		t.Replace = value
+	case "route":
+		t.Route = value
+	case "script":
+		t.Script = value
+	case "macro":
+		t.Macro = value
+	}
+	return c.Config.AddSessionTrigger(t)
+}
+
+// StopPlugins stops all running plugins.
+func (c *Client) StopPlugins() {
+	log.Tracef("stopping all plugins")
+	c.plugins.Stop()
+}
+
 // listen listens for events from the signal environment, then does nothing (but
 // does it splendidly)
 func (c *Client) listen() {
@@ -160,12 +396,158 @@ func (c *Client) listen() {
 			if remove {
 				go c.Env.Dispatch("_client:removeWorld", world)
 			}
+		case "stats":
+			if len(res.Payload) == 0 {
+				continue
+			}
+			conn, ok := c.conn(res.Payload[0])
+			if !ok {
+				log.Warningf("asked for stats for %s, but could not find it", res.Payload[0])
+				continue
+			}
+			go c.Env.Dispatch("_client:showModal", fmt.Sprintf("Statistics for %s::\n%s", conn.GetDisplayName(), conn.Stats()))
+		case "_client:connected", "_client:reconnected", "_client:disconnected", "_client:loggedIn":
+			if len(res.Payload) == 0 {
+				continue
+			}
+			if res.Name == "_client:disconnected" {
+				// Who's online is anyone's guess until the world is back.
+				delete(c.online, res.Payload[0])
+			}
+			conn, ok := c.conn(res.Payload[0])
+			if !ok {
+				continue
+			}
+			// Hooks may run macros, which wait for the client to hear what they do.
+			go conn.RunHooks(hookEvents[res.Name])
+			if res.Name == "_client:connected" && c.reconnected(res.Payload[0]) {
+				go c.Env.Dispatch("_client:reconnected", res.Payload[0])
+			}
+		case "_client:notify":
+			if len(res.Payload) != 2 {
+				continue
+			}
+			c.notify(res.Payload[0], res.Payload[1])
+		case "dnd":
+			setting := ""
+			if len(res.Payload) != 0 {
+				setting = res.Payload[0]
+			}
+			c.setDoNotDisturb(setting)
+		case "watch", "unwatch":
+			if res.Err != nil {
+				log.Errorf("unable to run /%s. %v", res.Name, res.Err)
+				continue
+			}
+			// Watching without a world uses the current one, which only the
+			// UI knows about.
+			if len(res.Payload) != 2 || res.Payload[0] == "" {
+				continue
+			}
+			world, name := res.Payload[0], res.Payload[1]
+			switch {
+			case res.Name == "unwatch" && name == "":
+				log.Warningf("usage: /unwatch [--world <world>] <name>")
+			case res.Name == "unwatch":
+				c.unwatch(world, name)
+			case name == "":
+				displayName := world
+				if conn, ok := c.conn(world); ok && conn.GetDisplayName() != "" {
+					displayName = conn.GetDisplayName()
+				}
+				go c.Env.Dispatch("_client:showModal", fmt.Sprintf("Watching in %s::\n%s", displayName, c.watchList(world)))
+			default:
+				c.watch(world, name)
+			}
+		case "_client:watch":
+			if len(res.Payload) != 4 {
+				continue
+			}
+			c.seen(res.Payload[0], res.Payload[1], res.Payload[2], res.Payload[3])
+		case "_client:send":
+			// Sends without a world go to the current one, which only the UI
+			// knows about.
+			if len(res.Payload) < 2 || res.Payload[0] == "" {
+				continue
+			}
+			c.Send(res.Payload[0], res.Payload[1:]...)
+		case "_client:echo":
+			if len(res.Payload) < 2 || res.Payload[0] == "" {
+				continue
+			}
+			c.Echo(res.Payload[0], res.Payload[1:]...)
+		case "alias":
+			if res.Err != nil {
+				log.Errorf("unable to add alias. %v", res.Err)
+				continue
+			}
+			if len(res.Payload) == 0 {
+				go c.Env.Dispatch("_client:showModal", fmt.Sprintf("Aliases::\n%s", c.aliasList()))
+				continue
+			}
+			err := c.Config.AddAlias(config.Alias{
+				Name:    res.Payload[0],
+				Match:   res.Payload[1],
+				Replace: res.Payload[2],
+			})
+			if err != nil {
+				log.Errorf("unable to add alias. %v", err)
+			}
+		case "unalias":
+			if len(res.Payload) == 0 {
+				continue
+			}
+			if !c.Config.RemoveAlias(res.Payload[0]) {
+				log.Warningf("no alias named %s", res.Payload[0])
+			}
+		case "trigger":
+			if res.Err != nil {
+				log.Errorf("unable to run /trigger. %v", res.Err)
+				continue
+			}
+			switch res.Payload[0] {
+			case "list":
+				go c.Env.Dispatch("_client:showModal", fmt.Sprintf("Triggers::\n%s", c.triggerList()))
+			case "add":
+				if err := c.addTrigger(res.Payload[1], res.Payload[2], res.Payload[3], res.Payload[4], res.Payload[5], res.Payload[6], res.Payload[7]); err != nil {
+					log.Errorf("unable to add trigger. %v", err)
+				}
+			case "enable", "disable":
+				if !c.Config.SetTriggerEnabled(res.Payload[1], res.Payload[0] == "enable") {
+					log.Warningf("no trigger named %s", res.Payload[1])
+				}
+			case "remove":
+				if !c.Config.RemoveTrigger(res.Payload[1]) {
+					log.Warningf("no trigger named %s", res.Payload[1])
+				}
+			case "save":
+				path, err := c.Config.SaveSessionTriggers()
+				if err != nil {
+					log.Errorf("unable to save triggers. %v", err)
+					continue
+				}
+				log.Infof("triggers saved to %s", path)
+			case "test":
+				// Testing without a world uses the current one, which only
+				// the UI knows about, unless no worlds are connected.
+				if res.Payload[1] == "" && len(c.ConnNames()) != 0 {
+					continue
+				}
+				go c.Env.Dispatch("_client:showModal", fmt.Sprintf("Trigger test::\n%s", c.triggerTest(res.Payload[1], res.Payload[2])))
+			}
 		case "reload":
 			if err := c.Config.Reload(); err != nil {
 				log.Errorf("unable to reload config: %v; continuing as is...", err)
+				continue
 			}
+			c.Env.SetMacros(c.Config.Macros)
+			// Scripts may be sending signals while they're stopped, which
+			// this needs to be listening for.
+			go c.scripts.Load()
+			go c.plugins.Restart()
 		case "quit":
 			c.CloseAll()
+			c.StopPlugins()
 			go c.Env.Dispatch("_client:quitReady", "")
 		default:
 			continue
@@ -178,13 +560,27 @@ func New(cfg *config.Config, env *signal.Dispatcher) (*Client, error) {
 	log.Tracef("creating client")
 	listener := make(chan signal.Signal)
 	c := &Client{
-		Config:      cfg,
-		Env:         env,
-		listener:    listener,
-		connections: map[string]*connection.Connection{},
+		Config:       cfg,
+		Env:          env,
+		listener:     listener,
+		connections:  map[string]*connection.Connection{},
+		reconnecting: map[string]bool{},
+		notified:     map[string]time.Time{},
+		online:       map[string]map[string]bool{},
+	}
+	if err := c.loadWatchList(); err != nil {
+		log.Errorf("unable to load the watch list, starting with an empty one. %v", err)
+		c.watching = map[string]map[string]*watched{}
 	}
+	c.scripts = scripting.New(cfg, env, c.ConnNames)
+	c.plugins = plugin.New(cfg, env, c.Conn)
+	env.SetMacros(cfg.Macros)
 	log.Tracef("listening for signals")
 	go c.listen()
 	env.AddListener("client", c.listener)
+	log.Tracef("loading scripts")
+	c.scripts.Load()
+	log.Tracef("starting plugins")
+	c.plugins.Start()
 	return c, nil
 }
@@ -0,0 +1,492 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package client_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/client"
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/fakemu"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// testConfig builds a config with a single world pointing at the given fake
+// server.
+func testConfig(t *testing.T, srv *fakemu.Server) *config.Config {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Version: 1,
+		ServerTypes: map[string]config.ServerType{
+			"tardis": config.ServerType{
+				ConnectString:    "connect $username $password",
+				DisconnectString: "QUIT",
+			},
+		},
+		Servers: map[string]config.Server{
+			"gallifrey": config.Server{
+				Host:       srv.Host(),
+				Port:       srv.Port(),
+				ServerType: "tardis",
+			},
+		},
+		Worlds: map[string]config.World{
+			"rose": config.World{
+				DisplayName: "Rose Tyler",
+				Server:      "gallifrey",
+				Username:    "rose",
+				Password:    "badwolf",
+			},
+		},
+	}
+	errs := cfg.FinalizeAndValidate()
+	So(errs, ShouldBeEmpty)
+	cfg.WorkingDir = filepath.Join(dir, "share")
+	cfg.LogDir = filepath.Join(dir, "log")
+	return cfg
+}
+
+// waitForSignal waits for a signal with the given name on the listener,
+// skipping any others.
+func waitForSignal(listener chan signal.Signal, name string) (signal.Signal, bool) {
+	deadline := time.After(fakemu.DefaultTimeout)
+	for {
+		select {
+		case s := <-listener:
+			if s.Name == name {
+				return s, true
+			}
+		case <-deadline:
+			return signal.Signal{}, false
+		}
+	}
+}
+
+// waitForLogin waits for the server to receive the connect string.
+func waitForLogin(srv *fakemu.Server) bool {
+	deadline := time.Now().Add(fakemu.DefaultTimeout)
+	for time.Now().Before(deadline) {
+		for _, line := range srv.Received() {
+			if line == "connect rose badwolf" {
+				return true
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestClient(t *testing.T) {
+	Convey("When using a client", t, func() {
+		srv, err := fakemu.New(
+			fakemu.Expect("^connect rose badwolf$"),
+			fakemu.Send("Welcome to the TARDIS!"),
+			fakemu.WaitForDisconnect(),
+		)
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		env := signal.NewDispatcher()
+		c, err := client.New(testConfig(t, srv), env)
+		So(err, ShouldBeNil)
+		listener := make(chan signal.Signal)
+		env.AddListener("test", listener)
+
+		Convey("It can connect to and disconnect from a world", func() {
+			env.Dispatch("connect", "rose")
+			res, ok := waitForSignal(listener, "_client:connect")
+			So(ok, ShouldBeTrue)
+			So(res.Err, ShouldBeNil)
+			So(res.Payload, ShouldResemble, []string{"rose"})
+
+			conn, ok := c.Conn("rose")
+			So(ok, ShouldBeTrue)
+			So(conn.GetDisplayName(), ShouldEqual, "Rose Tyler")
+			So(conn.Open(), ShouldBeNil)
+			So(conn.IsConnected(), ShouldBeTrue)
+			So(waitForLogin(srv), ShouldBeTrue)
+
+			env.Dispatch("disconnect", "rose")
+			_, ok = waitForSignal(listener, "_client:disconnect")
+			So(ok, ShouldBeTrue)
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+			So(conn.IsConnected(), ShouldBeFalse)
+		})
+
+		Convey("It closes all connections when quitting", func() {
+			conn, err := c.Connect("rose")
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+			So(waitForLogin(srv), ShouldBeTrue)
+
+			env.Dispatch("quit", "")
+			_, ok := waitForSignal(listener, "_client:quitReady")
+			So(ok, ShouldBeTrue)
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+			So(conn.IsConnected(), ShouldBeFalse)
+		})
+
+		Convey("It fails to connect to something it doesn't know", func() {
+			env.Dispatch("connect", "bad-wolf-bay:1234")
+			res, ok := waitForSignal(listener, "_client:connect")
+			So(ok, ShouldBeTrue)
+			So(res.Err, ShouldNotBeNil)
+			_, ok = c.Conn("bad-wolf-bay:1234")
+			So(ok, ShouldBeFalse)
+			So(srv.Sessions(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestMacros(t *testing.T) {
+	Convey("When a macro trigger matches", t, func() {
+		srv, err := fakemu.New(
+			fakemu.Expect("^connect rose badwolf$"),
+			fakemu.Send("Welcome to the TARDIS!"),
+			fakemu.Expect("^:waves to the TARDIS\\.$"),
+			fakemu.Expect("^hug TARDIS$"),
+			fakemu.WaitForDisconnect(),
+		)
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		cfg := testConfig(t, srv)
+		cfg.Triggers = []config.Trigger{
+			config.Trigger{
+				Type:  "macro",
+				Match: "^Welcome to the (\\w+)!",
+				Macro: "greet",
+			},
+		}
+		cfg.Macros = map[string][]string{
+			"greet": []string{":waves to the $1.", "/hug $1"},
+			"hug":   []string{"hug $1"},
+		}
+		workingDir, logDir := cfg.WorkingDir, cfg.LogDir
+		So(cfg.FinalizeAndValidate(), ShouldBeEmpty)
+		cfg.WorkingDir, cfg.LogDir = workingDir, logDir
+		env := signal.NewDispatcher()
+		c, err := client.New(cfg, env)
+		So(err, ShouldBeNil)
+
+		Convey("It sends what the macro sends to the world", func() {
+			conn, err := c.Connect("rose")
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+			So(waitForLogin(srv), ShouldBeTrue)
+
+			deadline := time.Now().Add(fakemu.DefaultTimeout)
+			for len(srv.Received()) < 4 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(srv.Received()[1:], ShouldResemble, []string{"connect rose badwolf", ":waves to the TARDIS.", "hug TARDIS"})
+			c.CloseAll()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+	})
+}
+
+// countReceived waits until the server has received the line the given number
+// of times, returning how many times it did.
+func countReceived(srv *fakemu.Server, line string, times int) int {
+	deadline := time.Now().Add(fakemu.DefaultTimeout)
+	count := 0
+	for time.Now().Before(deadline) {
+		count = 0
+		for _, received := range srv.Received() {
+			if received == line {
+				count++
+			}
+		}
+		if count >= times {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return count
+}
+
+func TestHooks(t *testing.T) {
+	Convey("When a world has hooks", t, func() {
+		srv, err := fakemu.New(
+			fakemu.Expect("^connect rose badwolf$"),
+			fakemu.Expect("^\\+channel on$"),
+			fakemu.Expect("^@set me=!idle$"),
+			fakemu.WaitForDisconnect(),
+		)
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		cfg := testConfig(t, srv)
+		world := cfg.Worlds["rose"]
+		world.Hooks = map[string][]config.Hook{
+			config.HookLoggedIn: []config.Hook{
+				config.Hook{Command: "+channel on"},
+				config.Hook{Macro: "unidle"},
+			},
+		}
+		cfg.Worlds["rose"] = world
+		cfg.Macros = map[string][]string{
+			"unidle": []string{"@set me=!idle"},
+		}
+		workingDir, logDir := cfg.WorkingDir, cfg.LogDir
+		So(cfg.FinalizeAndValidate(), ShouldBeEmpty)
+		cfg.WorkingDir, cfg.LogDir = workingDir, logDir
+		env := signal.NewDispatcher()
+		c, err := client.New(cfg, env)
+		So(err, ShouldBeNil)
+		listener := make(chan signal.Signal)
+		env.AddListener("test", listener)
+
+		Convey("It runs them once logged in, and knows when it reconnects", func() {
+			conn, err := c.Connect("rose")
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+			So(countReceived(srv, "@set me=!idle", 1), ShouldEqual, 1)
+			So(srv.Received()[1:], ShouldResemble, []string{"connect rose badwolf", "+channel on", "@set me=!idle"})
+			So(conn.Close(), ShouldBeNil)
+
+			conn, err = c.Connect("rose")
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+			res, ok := waitForSignal(listener, "_client:reconnected")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"rose"})
+			So(countReceived(srv, "@set me=!idle", 2), ShouldEqual, 2)
+			c.CloseAll()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+	})
+}
+
+func TestNotify(t *testing.T) {
+	Convey("When a notify trigger matches", t, func() {
+		srv, err := fakemu.New()
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		cfg := testConfig(t, srv)
+		dir := t.TempDir()
+		notifier := filepath.Join(dir, "notifier.sh")
+		So(os.WriteFile(notifier, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+filepath.Join(dir, "notified")+"\n"), 0755), ShouldBeNil)
+		cfg.Client.Notify = config.Notify{Command: notifier + " --urgent", RateLimit: 60}
+		env := signal.NewDispatcher()
+		c, err := client.New(cfg, env)
+		So(err, ShouldBeNil)
+		// The test listens too, so it needs room for what's delivered to it
+		// while notifying.
+		listener := make(chan signal.Signal, 16)
+		env.AddListener("test", listener)
+		notify := func(world string) {
+			env.Deliver(signal.Signal{Name: "_client:notify", Payload: []string{world, "The Doctor pages, \"Run!\" to you."}})
+		}
+
+		Convey("It tells the UI and runs the notifier, but not too often", func() {
+			notify("rose")
+			res, ok := waitForSignal(listener, "_tui:notify")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"rose", "The Doctor pages, \"Run!\" to you."})
+			deadline := time.Now().Add(fakemu.DefaultTimeout)
+			var notified []byte
+			for len(notified) == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				notified, _ = os.ReadFile(filepath.Join(dir, "notified"))
+			}
+			So(string(notified), ShouldEqual, "--urgent\nrose\nThe Doctor pages, \"Run!\" to you.\n")
+
+			notify("rose")
+			notify("donna")
+			res, ok = waitForSignal(listener, "_tui:notify")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[0], ShouldEqual, "donna")
+		})
+
+		Convey("It doesn't disturb the user when asked not to", func() {
+			env.Dispatch("dnd", "")
+			res, ok := waitForSignal(listener, "_client:doNotDisturb")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"on"})
+			So(c.DoNotDisturb(), ShouldBeTrue)
+			notify("rose")
+
+			env.Dispatch("dnd", "off")
+			res, ok = waitForSignal(listener, "_client:doNotDisturb")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"off"})
+			notify("donna")
+			res, ok = waitForSignal(listener, "_tui:notify")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[0], ShouldEqual, "donna")
+		})
+	})
+}
+
+func TestTriggerCommands(t *testing.T) {
+	Convey("When managing triggers with /trigger", t, func() {
+		srv, err := fakemu.New()
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		cfg := testConfig(t, srv)
+		cfg.ConfigDir = t.TempDir()
+		env := signal.NewDispatcher()
+		_, err = client.New(cfg, env)
+		So(err, ShouldBeNil)
+		listener := make(chan signal.Signal)
+		env.AddListener("test", listener)
+
+		// waitForTrigger waits until the trigger with the given name is in the
+		// given state, or is gone if it shouldn't exist.
+		waitForTrigger := func(name string, exists, disabled bool) bool {
+			deadline := time.Now().Add(fakemu.DefaultTimeout)
+			for time.Now().Before(deadline) {
+				found := false
+				for _, trigger := range cfg.TriggerList() {
+					if trigger.Name == name {
+						found = true
+						if exists && trigger.Disabled == disabled {
+							return true
+						}
+					}
+				}
+				if !exists && !found {
+					return true
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return false
+		}
+
+		env.Dispatch("trigger", "add rose hilite [Rr]ose => bold")
+		So(waitForTrigger("rose", true, false), ShouldBeTrue)
+
+		Convey("They can be listed", func() {
+			env.Dispatch("trigger", "list")
+			res, ok := waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[0], ShouldEqual, "Triggers")
+			So(res.Payload[1], ShouldEqual, "rose (hilite): [Rr]ose - 0 matches")
+		})
+
+		Convey("They can be tested", func() {
+			env.Dispatch("trigger", "test Hello, Rose.")
+			res, ok := waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[0], ShouldEqual, "Trigger test")
+			So(res.Payload[1], ShouldEqual, "Matches:\n    rose (hilite)\n\nResult:\n    Hello, \x1b[1mRose\x1b[22m.")
+		})
+
+		Convey("They can be tested against a world", func() {
+			env.Dispatch("trigger", "add --world rose donna gag Donna")
+			So(waitForTrigger("donna", true, false), ShouldBeTrue)
+
+			env.Dispatch("trigger", "test Donna waves to Rose.")
+			res, ok := waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[1], ShouldEqual, "Matches:\n    rose (hilite)\n\nResult:\n    Donna waves to \x1b[1mRose\x1b[22m.")
+
+			env.Dispatch("trigger", "test --world rose Donna waves to Rose.")
+			res, ok = waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[1], ShouldEqual, "Matches:\n    rose (hilite)\n    donna (gag)\n\nResult:\n    (gagged)")
+		})
+
+		Convey("They can be disabled, enabled, and removed", func() {
+			env.Dispatch("trigger", "disable rose")
+			So(waitForTrigger("rose", true, true), ShouldBeTrue)
+			env.Dispatch("trigger", "enable rose")
+			So(waitForTrigger("rose", true, false), ShouldBeTrue)
+			env.Dispatch("trigger", "remove rose")
+			So(waitForTrigger("rose", false, false), ShouldBeTrue)
+			So(cfg.SessionTriggers, ShouldBeEmpty)
+		})
+
+		Convey("They can be saved", func() {
+			path := filepath.Join(cfg.ConfigDir, config.SessionTriggersFile)
+			env.Dispatch("trigger", "save")
+			deadline := time.Now().Add(fakemu.DefaultTimeout)
+			var contents []byte
+			for len(contents) == 0 && time.Now().Before(deadline) {
+				contents, _ = os.ReadFile(path)
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(string(contents), ShouldContainSubstring, "session_triggers:")
+			So(string(contents), ShouldContainSubstring, "name: rose")
+		})
+	})
+}
+
+func TestWatch(t *testing.T) {
+	Convey("When watching for characters", t, func() {
+		srv, err := fakemu.New()
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		cfg := testConfig(t, srv)
+		env := signal.NewDispatcher()
+		_, err = client.New(cfg, env)
+		So(err, ShouldBeNil)
+		// The test listens too, so it needs room for what's delivered to it
+		// while the client works.
+		listener := make(chan signal.Signal, 16)
+		env.AddListener("test", listener)
+		announce := func(event, name string) {
+			env.Deliver(signal.Signal{Name: "_client:watch", Payload: []string{"rose", event, name, name + " has " + event + "."}})
+		}
+		listFrom := func(env *signal.Dispatcher, listener chan signal.Signal) string {
+			env.Deliver(signal.Signal{Name: "watch", Payload: []string{"rose", ""}})
+			res, ok := waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload[0], ShouldEqual, "Watching in rose")
+			return res.Payload[1]
+		}
+		list := func() string {
+			return listFrom(env, listener)
+		}
+		watchFile := filepath.Join(cfg.WorkingDir, client.WatchFile)
+
+		Convey("It lets the user know when they arrive and keeps track of when they were seen", func() {
+			env.Deliver(signal.Signal{Name: "watch", Payload: []string{"rose", "Martha"}})
+			So(list(), ShouldEqual, "Martha - not seen yet")
+
+			announce(config.WatchEventConnected, "Donna")
+			announce(config.WatchEventConnected, "martha")
+			res, ok := waitForSignal(listener, "_tui:notify")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"rose", "martha has connected."})
+			So(list(), ShouldEqual, "Martha - online")
+
+			announce(config.WatchEventDisconnected, "Martha")
+			So(list(), ShouldStartWith, "Martha - last seen "+time.Now().Format("2006-01-02"))
+			saved, err := os.ReadFile(watchFile)
+			So(err, ShouldBeNil)
+			So(string(saved), ShouldContainSubstring, "name: Martha")
+			So(string(saved), ShouldContainSubstring, "last_seen:")
+
+			// The list is loaded again when starting.
+			otherEnv := signal.NewDispatcher()
+			_, err = client.New(cfg, otherEnv)
+			So(err, ShouldBeNil)
+			otherListener := make(chan signal.Signal, 16)
+			otherEnv.AddListener("test", otherListener)
+			So(listFrom(otherEnv, otherListener), ShouldStartWith, "Martha - last seen")
+
+			env.Deliver(signal.Signal{Name: "unwatch", Payload: []string{"rose", "martha"}})
+			So(list(), ShouldStartWith, "Not watching for anyone.")
+			saved, err = os.ReadFile(watchFile)
+			So(err, ShouldBeNil)
+			So(string(saved), ShouldEqual, "{}\n")
+		})
+
+		Convey("It forgets who is online when the world disconnects", func() {
+			env.Deliver(signal.Signal{Name: "watch", Payload: []string{"rose", "Martha"}})
+			announce(config.WatchEventConnected, "Martha")
+			So(list(), ShouldEqual, "Martha - online")
+			env.Deliver(signal.Signal{Name: "_client:disconnected", Payload: []string{"rose"}})
+			So(list(), ShouldStartWith, "Martha - last seen")
+		})
+	})
+}
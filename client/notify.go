@@ -0,0 +1,93 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package client
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// notify tells the user about a line which matched a notify trigger, unless
+// they don't want to be disturbed or the world has notified them too recently.
+func (c *Client) notify(world, line string) {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	if c.doNotDisturb {
+		log.Debugf("not notifying for %s, as do not disturb is on", world)
+		return
+	}
+	limit := time.Duration(c.Config.Client.Notify.RateLimit) * time.Second
+	now := time.Now()
+	if last, ok := c.notified[world]; ok && now.Sub(last) < limit {
+		log.Debugf("not notifying for %s, as it notified %v ago", world, now.Sub(last))
+		return
+	}
+	c.notified[world] = now
+
+	log.Tracef("notifying for %s", world)
+	go c.Env.DirectDispatch(signal.Signal{
+		Name:    "_tui:notify",
+		Payload: []string{world, line},
+	})
+	if command := c.Config.Client.Notify.Command; command != "" {
+		name := world
+		if conn, ok := c.conn(world); ok && conn.GetDisplayName() != "" {
+			name = conn.GetDisplayName()
+		}
+		go c.runNotifier(command, name, line)
+	}
+}
+
+// runNotifier runs the notifier command, passing it the world and line.
+func (c *Client) runNotifier(command, world, line string) {
+	args := append(strings.Fields(command), world, line)
+	timeout := time.Duration(c.Config.Client.Scripts.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+		log.Errorf("notifier %s failed. %v %s", command, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// setDoNotDisturb turns do not disturb on or off, or toggles it if the setting
+// is blank, and lets everyone know.
+func (c *Client) setDoNotDisturb(setting string) {
+	c.notifyMu.Lock()
+	switch setting {
+	case "on":
+		c.doNotDisturb = true
+	case "off":
+		c.doNotDisturb = false
+	case "":
+		c.doNotDisturb = !c.doNotDisturb
+	default:
+		c.notifyMu.Unlock()
+		log.Warningf("usage: /dnd [on|off]")
+		return
+	}
+	state := "off"
+	if c.doNotDisturb {
+		state = "on"
+	}
+	c.notifyMu.Unlock()
+	log.Infof("do not disturb is %s", state)
+	go c.Env.DirectDispatch(signal.Signal{
+		Name:    "_client:doNotDisturb",
+		Payload: []string{state},
+	})
+}
+
+// DoNotDisturb returns whether or not notifications are turned off.
+func (c *Client) DoNotDisturb() bool {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	return c.doNotDisturb
+}
@@ -0,0 +1,148 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+// WatchFile is the name of the file within the working directory in which the
+// watch list is kept.
+const WatchFile = "watch.yaml"
+
+// watched is a character on the watch list.
+type watched struct {
+	// The name of the character as it was added.
+	Name string
+
+	// When the character was last seen connecting or disconnecting.
+	LastSeen time.Time `yaml:"last_seen,omitempty"`
+}
+
+// loadWatchList loads the watch list from the working directory, if there is
+// one.
+func (c *Client) loadWatchList() error {
+	c.watching = map[string]map[string]*watched{}
+	out, err := os.ReadFile(filepath.Join(c.Config.WorkingDir, WatchFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(out, &c.watching)
+}
+
+// saveWatchList writes the watch list to the working directory.
+func (c *Client) saveWatchList() {
+	out, err := yaml.Marshal(c.watching)
+	if err != nil {
+		log.Errorf("unable to save the watch list. %v", err)
+		return
+	}
+	if err := os.MkdirAll(c.Config.WorkingDir, 0755); err != nil {
+		log.Errorf("unable to save the watch list. %v", err)
+		return
+	}
+	path := filepath.Join(c.Config.WorkingDir, WatchFile)
+	log.Tracef("saving the watch list to %s", path)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		log.Errorf("unable to save the watch list. %v", err)
+	}
+}
+
+// watch adds a character to the watch list for the world.
+func (c *Client) watch(world, name string) {
+	key := strings.ToLower(name)
+	if _, ok := c.watching[world][key]; ok {
+		log.Warningf("already watching for %s in %s", name, world)
+		return
+	}
+	if c.watching[world] == nil {
+		c.watching[world] = map[string]*watched{}
+	}
+	c.watching[world][key] = &watched{Name: name}
+	log.Infof("watching for %s in %s", name, world)
+	c.saveWatchList()
+}
+
+// unwatch removes a character from the watch list for the world.
+func (c *Client) unwatch(world, name string) {
+	key := strings.ToLower(name)
+	if _, ok := c.watching[world][key]; !ok {
+		log.Warningf("not watching for %s in %s", name, world)
+		return
+	}
+	delete(c.watching[world], key)
+	if len(c.watching[world]) == 0 {
+		delete(c.watching, world)
+	}
+	log.Infof("no longer watching for %s in %s", name, world)
+	c.saveWatchList()
+}
+
+// seen keeps track of a character the world announced connecting or
+// disconnecting, letting the user know if it's someone they're watching for
+// who has just arrived.
+func (c *Client) seen(world, event, name, line string) {
+	key := strings.ToLower(name)
+	switch event {
+	case config.WatchEventConnected:
+		if c.online[world] == nil {
+			c.online[world] = map[string]bool{}
+		}
+		c.online[world][key] = true
+	case config.WatchEventDisconnected:
+		delete(c.online[world], key)
+	default:
+		return
+	}
+	w, ok := c.watching[world][key]
+	if !ok {
+		return
+	}
+	w.LastSeen = time.Now()
+	c.saveWatchList()
+	if event == config.WatchEventConnected {
+		c.notify(world, line)
+	}
+}
+
+// watchList returns the characters being watched for in the world, one per
+// line, with whether they're online or when they were last seen.
+func (c *Client) watchList(world string) string {
+	if len(c.watching[world]) == 0 {
+		return "Not watching for anyone. Add someone with `/watch <name>`."
+	}
+	keys := make([]string, 0, len(c.watching[world]))
+	for key := range c.watching[world] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, key := range keys {
+		w := c.watching[world][key]
+		switch {
+		case c.online[world][key]:
+			lines = append(lines, fmt.Sprintf("%s - online", w.Name))
+		case w.LastSeen.IsZero():
+			lines = append(lines, fmt.Sprintf("%s - not seen yet", w.Name))
+		default:
+			lines = append(lines, fmt.Sprintf("%s - last seen %s", w.Name, w.LastSeen.Format("2006-01-02 15:04")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -33,7 +33,7 @@ it to output so that you can see the eventual result.`,
 			initLogging(logLevel)
 		}
 		if defaultConfigOnly {
-			fmt.Println(config.DefaultConfig)
+			fmt.Print(config.DefaultConfig)
 			return
 		}
 		cfg, err := config.New()
@@ -0,0 +1,169 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/juju/loggo"
+	"github.com/juju/loggo/loggocolor"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+// passphraseEnv is the environment variable which may hold the passphrase for
+// the credentials file, for when there's nobody around to type it.
+const passphraseEnv = "STIMMTAUSCH_PASSPHRASE"
+
+func init() {
+	credentialsCmd.AddCommand(credentialsSetCmd)
+	credentialsCmd.AddCommand(credentialsRemoveCmd)
+	credentialsCmd.AddCommand(credentialsListCmd)
+	rootCmd.AddCommand(credentialsCmd)
+}
+
+// readSecret prompts for and reads a line from the terminal without echoing
+// it.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(secret), err
+}
+
+// readPassphrase gets the passphrase for the credentials file from the
+// environment or by prompting for it. If the file is being created, the
+// passphrase is asked for twice to guard against typos.
+func readPassphrase(create bool) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := readSecret("Passphrase for credentials: ")
+	if err != nil {
+		return "", err
+	}
+	if create {
+		confirm, err := readSecret("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if confirm != passphrase {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// unlockCredentials unlocks the credentials file, if there is one.
+func unlockCredentials(cfg *config.Config) error {
+	if !cfg.HasCredentials() {
+		return nil
+	}
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return err
+	}
+	return cfg.UnlockCredentials(passphrase)
+}
+
+// loadCredentials loads config and unlocks (or prepares to create) the
+// credentials file for the credentials subcommands, returning the passphrase
+// so that they may be saved again.
+func loadCredentials() (*config.Config, string) {
+	loggo.ReplaceDefaultWriter(loggocolor.NewWriter(os.Stderr))
+	if logLevel == "" {
+		initLogging("INFO")
+	} else {
+		initLogging(logLevel)
+	}
+	cfg, err := config.New()
+	if err != nil {
+		log.Criticalf("unable to read config: %v", err)
+		os.Exit(1)
+	}
+	passphrase, err := readPassphrase(!cfg.HasCredentials())
+	if err != nil {
+		log.Criticalf("unable to read passphrase: %v", err)
+		os.Exit(1)
+	}
+	if err := cfg.UnlockCredentials(passphrase); err != nil {
+		log.Criticalf("unable to unlock credentials: %v", err)
+		os.Exit(1)
+	}
+	return cfg, passphrase
+}
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Manage stored passwords",
+	Long: `Manage stored passwords
+
+Rather than keeping passwords in your config files, which you might share or
+check into a dotfiles repo, you can store them in an encrypted credentials file
+in your config directory. You will be asked for the passphrase to unlock it
+when Stimmtausch starts, or you can set it in the STIMMTAUSCH_PASSPHRASE
+environment variable.
+
+Passwords in the credentials file are only used for worlds which don't have a
+password or password_command in their config.`,
+}
+
+var credentialsSetCmd = &cobra.Command{
+	Use:   "set world",
+	Short: "Store the password for a world",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, passphrase := loadCredentials()
+		if _, ok := cfg.Worlds[args[0]]; !ok {
+			log.Warningf("there is no world named %s in your config (yet)", args[0])
+		}
+		password, err := readSecret(fmt.Sprintf("Password for %s: ", args[0]))
+		if err != nil {
+			log.Criticalf("unable to read password: %v", err)
+			os.Exit(1)
+		}
+		cfg.SetCredential(args[0], password)
+		if err := cfg.SaveCredentials(passphrase); err != nil {
+			log.Criticalf("unable to save credentials: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("stored password for %s", args[0])
+	},
+}
+
+var credentialsRemoveCmd = &cobra.Command{
+	Use:   "remove world",
+	Short: "Remove the stored password for a world",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, passphrase := loadCredentials()
+		if !cfg.RemoveCredential(args[0]) {
+			log.Warningf("no password stored for %s", args[0])
+			return
+		}
+		if err := cfg.SaveCredentials(passphrase); err != nil {
+			log.Criticalf("unable to save credentials: %v", err)
+			os.Exit(1)
+		}
+		log.Infof("removed password for %s", args[0])
+	},
+}
+
+var credentialsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the worlds with stored passwords",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, _ := loadCredentials()
+		for _, world := range cfg.CredentialWorlds() {
+			fmt.Println(world)
+		}
+	},
+}
@@ -49,6 +49,10 @@ UI such as <https://github.com/makyo/stimmtausch.vim>.`,
 			log.Criticalf("unable to read config: %v", err)
 			os.Exit(1)
 		}
+		if err := unlockCredentials(cfg); err != nil {
+			log.Criticalf("unable to unlock credentials: %v", err)
+			os.Exit(1)
+		}
 
 		if cfg.Client.Profile.CPU {
 			defer profile.Start().Stop()
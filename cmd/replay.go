@@ -0,0 +1,92 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/makyo/stimmtausch/client"
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/signal"
+	"github.com/makyo/stimmtausch/ui"
+)
+
+var replaySpeed float64
+
+func init() {
+	initFlags(replayCmd)
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "s", 1, "how much faster than real time to play back the recording (0 for as fast as possible)")
+	rootCmd.AddCommand(replayCmd)
+}
+
+// replayCmd plays back a recorded session through triggers and the UI.
+var replayCmd = &cobra.Command{
+	Use:   "replay [flags] recording",
+	Short: "Play back a recorded session.",
+	Long: `Play back a recorded session.
+
+If you have "record" turned on in your logging configuration, Stimmtausch will
+record everything it receives from the server, along with when it was received,
+to a .ttyrec file in the world's log directory. This command plays that back
+through the same pipeline as a live connection - triggers, hilites, gags, and
+the UI - so that you can reproduce trigger and rendering bugs offline and share
+them in bug reports.
+
+By default, the recording is played back at the speed at which it was received.
+You can speed that up with --speed, or pass --speed 0 to play it back as fast
+as possible.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if logLevel == "" {
+			initLogging("INFO")
+		} else {
+			initLogging(logLevel)
+		}
+
+		cfg, err := config.New()
+		if err != nil {
+			log.Criticalf("unable to read config: %v", err)
+			os.Exit(1)
+		}
+		log.Tracef("Config loaded")
+
+		if logLevel == "" {
+			initLogging(cfg.Client.Syslog.LogLevel)
+		}
+
+		env := signal.NewDispatcher()
+
+		log.Tracef("creating client")
+		stClient, err := client.New(cfg, env)
+		if err != nil {
+			log.Criticalf("could not create client: %v", err)
+			os.Exit(2)
+		}
+
+		conn, err := stClient.Replay(args[0], replaySpeed)
+		if err != nil {
+			log.Criticalf("could not replay %s: %v", args[0], err)
+			os.Exit(1)
+		}
+
+		done := make(chan bool)
+		ready := make(chan bool)
+		tui := ui.New(stClient)
+		go tui.Run(done, ready)
+
+		<-ready
+
+		go env.DirectDispatch(signal.Signal{
+			Name:    "_client:connect",
+			Payload: []string{conn.GetConnectionName()},
+		})
+
+		<-done
+	},
+}
@@ -72,6 +72,10 @@ For more help, see https://stimmtausch.com`,
 			os.Exit(1)
 		}
 		log.Tracef("Config loaded")
+		if err := unlockCredentials(cfg); err != nil {
+			log.Criticalf("unable to unlock credentials: %v", err)
+			os.Exit(1)
+		}
 
 		if cfg.Client.Profile.CPU {
 			defer profile.Start().Stop()
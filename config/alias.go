@@ -0,0 +1,127 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// aliasesMu guards CompiledAliases, which may be changed with /alias while
+// connections are using them.
+var aliasesMu sync.RWMutex
+
+// Alias rewrites a line typed by the user before it is sent to the world.
+type Alias struct {
+	// The name of the alias.
+	Name string
+
+	// The world to which this alias applies (if blank, applies to all).
+	World string
+
+	// A regexp to match against what's typed.
+	Match string
+
+	// What to replace the match with. Capture groups may be used as $1, $2,
+	// or ${name}. If the result starts with a /, it's run as a command.
+	Replace string
+
+	// The compiled regexp specified in Match.
+	re *regexp.Regexp
+}
+
+// compileAlias compiles the regexp specified in the alias's Match attribute.
+func compileAlias(a Alias) (*Alias, error) {
+	if a.Match == "" {
+		return nil, fmt.Errorf("no match for alias %s", a.Name)
+	}
+	if a.Name == "" {
+		a.Name = a.Match
+	}
+	re, err := regexp.Compile(a.Match)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s has an invalid match: %v", a.Name, err)
+	}
+	a.re = re
+	return &a, nil
+}
+
+// Apply rewrites the first match of the alias within the input if the alias
+// applies to the world, returning the result and whether or not it matched.
+func (a *Alias) Apply(world, input string) (string, bool) {
+	if a.World != "" && a.World != world {
+		return input, false
+	}
+	match := a.re.FindStringSubmatchIndex(input)
+	if match == nil {
+		return input, false
+	}
+	replacement := a.re.ExpandString(nil, a.Replace, input, match)
+	return input[:match[0]] + string(replacement) + input[match[1]:], true
+}
+
+// ApplyAliases rewrites the input with the first alias which matches it, if
+// any.
+func (c *Config) ApplyAliases(world, input string) string {
+	for _, a := range c.AliasList() {
+		if output, ok := a.Apply(world, input); ok {
+			log.Tracef("alias %s applies", a.Name)
+			return output
+		}
+	}
+	return input
+}
+
+// AliasList returns the compiled aliases, including any added with /alias.
+func (c *Config) AliasList() []*Alias {
+	aliasesMu.RLock()
+	defer aliasesMu.RUnlock()
+	return c.CompiledAliases
+}
+
+// AddAlias compiles the alias and adds it, replacing any alias with the same
+// name. Unlike those in Aliases, it won't survive a reload.
+func (c *Config) AddAlias(a Alias) error {
+	aliasRef, err := compileAlias(a)
+	if err != nil {
+		return err
+	}
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	aliases := []*Alias{}
+	replaced := false
+	for _, existing := range c.CompiledAliases {
+		if existing.Name == aliasRef.Name {
+			aliases = append(aliases, aliasRef)
+			replaced = true
+		} else {
+			aliases = append(aliases, existing)
+		}
+	}
+	if !replaced {
+		aliases = append(aliases, aliasRef)
+	}
+	c.CompiledAliases = aliases
+	return nil
+}
+
+// RemoveAlias removes the alias with the given name, returning whether or not
+// there was one.
+func (c *Config) RemoveAlias(name string) bool {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	var aliases []*Alias
+	for _, a := range c.CompiledAliases {
+		if a.Name != name {
+			aliases = append(aliases, a)
+		}
+	}
+	removed := len(aliases) != len(c.CompiledAliases)
+	c.CompiledAliases = aliases
+	return removed
+}
@@ -0,0 +1,74 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config_test
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+func TestAliases(t *testing.T) {
+	Convey("When using aliases", t, func() {
+		c := stubConfig()
+		c.Aliases = []config.Alias{
+			config.Alias{
+				Name:    "pose",
+				Match:   "^;",
+				Replace: ":",
+			},
+			config.Alias{
+				Name:    "page",
+				Match:   "^p (\\w+)=(.*)$",
+				Replace: "page $1=$2",
+			},
+			config.Alias{
+				World:   "stubworld",
+				Match:   "^home$",
+				Replace: "/fg home",
+			},
+		}
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+
+		Convey("They rewrite what matches", func() {
+			So(c.ApplyAliases("stubworld", ";waves."), ShouldEqual, ":waves.")
+			So(c.ApplyAliases("stubworld", "p Rose=Hello!"), ShouldEqual, "page Rose=Hello!")
+			So(c.ApplyAliases("stubworld", "home"), ShouldEqual, "/fg home")
+		})
+
+		Convey("They leave everything else alone", func() {
+			So(c.ApplyAliases("stubworld", "say ;)"), ShouldEqual, "say ;)")
+			So(c.ApplyAliases("otherworld", "home"), ShouldEqual, "home")
+		})
+
+		Convey("Only the first which matches applies", func() {
+			So(c.ApplyAliases("stubworld", ";p Rose=Hello!"), ShouldEqual, ":p Rose=Hello!")
+		})
+
+		Convey("They can be added and removed", func() {
+			So(c.AddAlias(config.Alias{Name: "pose", Match: "^;", Replace: ": "}), ShouldBeNil)
+			So(c.AddAlias(config.Alias{Name: "wave", Match: "^w$", Replace: "wave"}), ShouldBeNil)
+			So(len(c.AliasList()), ShouldEqual, 4)
+			So(c.ApplyAliases("stubworld", ";waves."), ShouldEqual, ": waves.")
+			So(c.ApplyAliases("stubworld", "w"), ShouldEqual, "wave")
+			So(c.RemoveAlias("wave"), ShouldBeTrue)
+			So(c.RemoveAlias("wave"), ShouldBeFalse)
+			So(c.ApplyAliases("stubworld", "w"), ShouldEqual, "w")
+		})
+
+		Convey("They must be valid", func() {
+			c.Aliases = append(c.Aliases, config.Alias{Name: "empty"}, config.Alias{Name: "bad", Match: "(*"})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "no match for alias empty")
+			So(errs[1].Error(), ShouldStartWith, "alias bad has an invalid match")
+			So(len(c.AliasList()), ShouldEqual, 3)
+		})
+	})
+}
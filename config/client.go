@@ -2,10 +2,13 @@ package config
 
 // Client holds information regarding how Stimmtausch runs.
 type Client struct {
-	Syslog  Syslog
-	Profile Profile
-	Logging Logging
-	UI      UI
+	Syslog     Syslog
+	Profile    Profile
+	Logging    Logging
+	Scripts    Scripts
+	Notify     Notify
+	NameColors NameColors `yaml:"name_colors" toml:"name_colors"`
+	UI         UI
 }
 
 // Syslog holds nformation regarding the logging generated by the program (as
@@ -38,10 +41,41 @@ type Logging struct {
 	TimeString string `yaml:"time_string" toml:"time_string"`
 
 	// Whether or not to log timestamps.
-	LogTimestamps bool `yaml:"log_timestamps" toml"log_timestamps"`
+	LogTimestamps bool `yaml:"log_timestamps" toml:"log_timestamps"`
 
 	// Whether or not to keep logs of the connection after disconnect.
 	LogWorld bool `yaml:"log_world" toml:"log_world"`
+
+	// Whether or not to record the raw data received from the server, with
+	// timing information, for later replay.
+	Record bool `yaml:"record" toml:"record"`
+}
+
+// Scripts holds information regarding running scripts from triggers.
+type Scripts struct {
+
+	// How long, in seconds, a script may run before it is killed, or a call
+	// into a Starlark script before it is stopped.
+	Timeout int
+
+	// How many scripts may be running at once for each world. Triggers which
+	// match while that many are running are skipped.
+	MaxConcurrent int `yaml:"max_concurrent" toml:"max_concurrent"`
+}
+
+// Notify holds information regarding notifications from notify triggers.
+type Notify struct {
+
+	// Whether or not to ring the terminal bell.
+	Bell bool
+
+	// A command to run for each notification, such as "notify-send", which is
+	// passed the world's display name and the line as its last two arguments.
+	Command string
+
+	// The fewest seconds between notifications from each world. Those which
+	// come sooner are dropped.
+	RateLimit int `yaml:"rate_limit" toml:"rate_limit"`
 }
 
 // UI holds information regarding the user interface.
@@ -84,6 +118,7 @@ type UI struct {
 			Disconnected       string
 			DisconnectedMore   string `yaml:"disconnected_more" toml:"disconnected_more"`     // TODO
 			DisconnectedActive string `yaml:"disconnected_active" toml:"disconnected_active"` // TODO
+			Urgent             string
 		} `yaml:"send_title" toml:"send_title"`
 		ModalTitle string `yaml:"modal_title" toml:"modal_title"`
 	}
@@ -9,13 +9,43 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"sync"
 
 	"github.com/juju/loggo"
 	"github.com/makyo/snuffler"
 	"gopkg.in/yaml.v2"
+
+	"github.com/makyo/stimmtausch/signal"
+)
+
+var (
+	log = loggo.GetLogger("stimmtausch.config")
+
+	// Routes are switched to as <world>/<route>, so their names are limited
+	// in the same way.
+	routeNameRE = regexp.MustCompile(`^[[:word:]-]+$`)
+
+	// triggersMu guards CompiledTriggers, which may be changed by scripts
+	// while connections are running them.
+	triggersMu sync.RWMutex
 )
 
-var log = loggo.GetLogger("stimmtausch.config")
+const (
+	// The default number of seconds between pings for measuring lag.
+	defaultPingInterval = 60
+
+	// The default number of seconds a login step waits for its line.
+	defaultLoginTimeout = 30
+
+	// The default number of seconds a script may run, and number of scripts
+	// which may run at once for each world.
+	defaultScriptTimeout       = 10
+	defaultScriptMaxConcurrent = 4
+
+	// The default number of lines in a block with an end but no max_lines.
+	defaultBlockMaxLines = 50
+)
 
 // wrapper just wraps a Config object, since, for readability's sake, all
 // Stimmtausch yaml files have everything under the `stimmtausch` key.
@@ -43,11 +73,39 @@ type Config struct {
 	// A list of triggers to match on input.
 	Triggers []Trigger
 
+	// A list of triggers added with /trigger and saved, which are kept apart
+	// from Triggers so that saving them doesn't overwrite those.
+	SessionTriggers []Trigger `yaml:"session_triggers" toml:"session_triggers"`
+
 	// References to compiled triggers.
 	CompiledTriggers []*Trigger `yaml:"-" toml:"-"`
 
+	// The pipeline for matching the compiled triggers, built as needed.
+	pipeline *Pipeline
+
+	// A list of aliases to rewrite what's typed before it's sent.
+	Aliases []Alias
+
+	// References to compiled aliases.
+	CompiledAliases []*Alias `yaml:"-" toml:"-"`
+
+	// A list of macros, each a list of commands and lines to send.
+	Macros map[string][]string
+
+	// A list of plugins to run.
+	Plugins map[string]Plugin
+
 	Client Client
 
+	// Passwords from the credentials file, once unlocked.
+	credentials map[string]string
+
+	// The state of named temporary triggers, where it's kept if the config
+	// was loaded from files, and whether it has changed since it was saved.
+	triggerStates       map[string]triggerState
+	triggerStatePath    string
+	triggerStateChanged bool
+
 	HomeDir    string `yaml:"-" toml:"-"`
 	ConfigDir  string `yaml:"-" toml:"-"`
 	WorkingDir string `yaml:"-" toml:"-"`
@@ -68,6 +126,10 @@ func (c *Config) FinalizeAndValidate() []error {
 		if _, ok := c.Servers[world.Server]; !ok {
 			errs = append(errs, fmt.Errorf("world %s refers to unknown server %s", name, world.Server))
 		}
+		if world.Password != "" && world.PasswordCommand != "" {
+			errs = append(errs, fmt.Errorf("world %s has both a password and a password command", name))
+		}
+		errs = append(errs, world.validateHooks(c.Macros)...)
 		c.Worlds[name] = world
 	}
 
@@ -80,14 +142,99 @@ func (c *Config) FinalizeAndValidate() []error {
 		c.Servers[name] = server
 	}
 
+	log.Tracef("finalizing and validating server types")
+	for name, st := range c.ServerTypes {
+		if st.PingResponse != "" {
+			re, err := regexp.Compile(st.PingResponse)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("server type %s has an invalid ping response: %v", name, err))
+			}
+			st.pingRe = re
+		}
+		if st.PingInterval == 0 {
+			st.PingInterval = defaultPingInterval
+		}
+		errs = append(errs, st.compileWatchPatterns(name)...)
+		for i, step := range st.Login {
+			if step.Expect == "" && step.Send == "" {
+				errs = append(errs, fmt.Errorf("server type %s has an empty login step %d", name, i+1))
+			}
+			if step.Expect != "" {
+				re, err := regexp.Compile(step.Expect)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("server type %s has an invalid expect in login step %d: %v", name, i+1, err))
+				}
+				step.expectRe = re
+			}
+			if step.Timeout == 0 {
+				step.Timeout = defaultLoginTimeout
+			}
+			st.Login[i] = step
+		}
+		c.ServerTypes[name] = st
+	}
+
+	log.Tracef("finalizing and validating client")
+	if c.Client.Scripts.Timeout == 0 {
+		c.Client.Scripts.Timeout = defaultScriptTimeout
+	}
+	if c.Client.Scripts.MaxConcurrent == 0 {
+		c.Client.Scripts.MaxConcurrent = defaultScriptMaxConcurrent
+	}
+	if c.Client.Scripts.Timeout < 1 {
+		errs = append(errs, fmt.Errorf("scripts timeout must be at least 1 second, not %d", c.Client.Scripts.Timeout))
+		c.Client.Scripts.Timeout = defaultScriptTimeout
+	}
+	if c.Client.Scripts.MaxConcurrent < 1 {
+		errs = append(errs, fmt.Errorf("scripts max_concurrent must be at least 1, not %d", c.Client.Scripts.MaxConcurrent))
+		c.Client.Scripts.MaxConcurrent = defaultScriptMaxConcurrent
+	}
+	errs = append(errs, c.Client.NameColors.compile()...)
+
+	log.Tracef("finalizing and validating aliases")
+	c.CompiledAliases = nil
+	for _, alias := range c.Aliases {
+		aliasRef, err := compileAlias(alias)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.CompiledAliases = append(c.CompiledAliases, aliasRef)
+	}
+
+	log.Tracef("finalizing and validating macros")
+	for name, commands := range c.Macros {
+		if !signal.MacroNameRE.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid macro name %s", name))
+		}
+		if len(commands) == 0 {
+			errs = append(errs, fmt.Errorf("macro %s has no commands", name))
+		}
+	}
+
+	log.Tracef("finalizing and validating plugins")
+	for name, plugin := range c.Plugins {
+		plugin.Name = name
+		if plugin.Command == "" {
+			errs = append(errs, fmt.Errorf("plugin %s has no command", name))
+		}
+		c.Plugins[name] = plugin
+	}
+
 	log.Tracef("finalizing and validating triggers")
-	for _, trigger := range c.Triggers {
-		triggerRef, err := compileTrigger(trigger)
+	c.CompiledTriggers = nil
+	triggers := append(append([]Trigger{}, c.Triggers...), c.SessionTriggers...)
+	for i, trigger := range triggers {
+		// Triggers without names are referred to by where they are.
+		triggerRef, err := compileTrigger(trigger, fmt.Sprintf("#%d", i))
 		if err != nil {
 			errs = append(errs, err)
+		} else if _, ok := c.Macros[triggerRef.Macro]; triggerRef.Type == "macro" && !ok {
+			errs = append(errs, fmt.Errorf("trigger %s refers to unknown macro %s", triggerRef.label, triggerRef.Macro))
 		}
 		c.CompiledTriggers = append(c.CompiledTriggers, triggerRef)
 	}
+	sortTriggers(c.CompiledTriggers)
 
 	c.HomeDir = HomeDir
 	c.ConfigDir = ConfigDir
@@ -123,6 +270,7 @@ func load() (*Config, error) {
 	if len(errs) != 0 {
 		return nil, fmt.Errorf("could not validate config file: %+q", errs)
 	}
+	cfg.triggerStatePath = filepath.Join(cfg.WorkingDir, TriggerStateFile)
 
 	return &cfg, nil
 }
@@ -132,6 +280,9 @@ func load() (*Config, error) {
 func (c *Config) Reload() error {
 	log.Debugf("reloading configuration")
 
+	// Anything which has expired since it was last checked shouldn't come
+	// back when it's loaded again.
+	c.PruneTriggers()
 	newCfg, err := load()
 	if err != nil {
 		return err
@@ -142,13 +293,95 @@ func (c *Config) Reload() error {
 	c.Servers = newCfg.Servers
 	c.Worlds = newCfg.Worlds
 	c.Triggers = newCfg.Triggers
-	c.CompiledTriggers = newCfg.CompiledTriggers
+	configured := map[string]bool{}
+	for _, t := range append(newCfg.Triggers, newCfg.SessionTriggers...) {
+		configured[t.Name] = true
+	}
+	triggersMu.Lock()
+	newCfg.restoreTriggerState(c.triggerStates)
+	c.SessionTriggers = newCfg.SessionTriggers
+	c.CompiledTriggers = keepTemporaryTriggers(c.CompiledTriggers, newCfg.CompiledTriggers, configured)
+	triggersMu.Unlock()
+	c.Aliases = newCfg.Aliases
+	aliasesMu.Lock()
+	c.CompiledAliases = newCfg.CompiledAliases
+	aliasesMu.Unlock()
+	c.Macros = newCfg.Macros
+	c.Plugins = newCfg.Plugins
 	c.Client = newCfg.Client
+	c.SaveTriggerState()
 	return nil
 }
 
+// TriggerList returns the compiled triggers, including any added by scripts.
+func (c *Config) TriggerList() []*Trigger {
+	triggersMu.RLock()
+	defer triggersMu.RUnlock()
+	return c.CompiledTriggers
+}
+
+// AddTrigger compiles the trigger and adds it after the rest of those with the
+// same priority. Unlike those in Triggers, it won't survive a reload.
+func (c *Config) AddTrigger(t Trigger) error {
+	triggerRef, err := compileTrigger(t, unnamedTrigger)
+	if err != nil {
+		return err
+	}
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	c.forgetTriggerState(t.Name)
+	triggers := make([]*Trigger, len(c.CompiledTriggers), len(c.CompiledTriggers)+1)
+	copy(triggers, c.CompiledTriggers)
+	triggers = append(triggers, triggerRef)
+	sortTriggers(triggers)
+	c.CompiledTriggers = triggers
+	return nil
+}
+
+// RemoveTrigger removes any compiled triggers with the given name, along with
+// any session triggers, returning whether or not there were any. Whether the
+// trigger had expired is forgotten, too, so that one by the same name in a
+// config file comes back on the next reload.
+func (c *Config) RemoveTrigger(name string) bool {
+	if !c.removeTrigger(name) {
+		return false
+	}
+	c.SaveTriggerState()
+	return true
+}
+
+func (c *Config) removeTrigger(name string) bool {
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	var triggers []*Trigger
+	for _, t := range c.CompiledTriggers {
+		if t.Name != name {
+			triggers = append(triggers, t)
+		}
+	}
+	removed := len(triggers) != len(c.CompiledTriggers)
+	c.CompiledTriggers = triggers
+	var sessionTriggers []Trigger
+	for _, t := range c.SessionTriggers {
+		if t.Name != name {
+			sessionTriggers = append(sessionTriggers, t)
+		}
+	}
+	c.SessionTriggers = sessionTriggers
+	if c.forgetTriggerState(name) {
+		removed = true
+	}
+	return removed
+}
+
+// Dump returns the config as YAML, with any passwords redacted.
 func (c *Config) Dump() string {
-	out, _ := yaml.Marshal(&wrapper{Stimmtausch: *c})
+	dump := *c
+	dump.Worlds = map[string]World{}
+	for name, w := range c.Worlds {
+		dump.Worlds[name] = w.redacted()
+	}
+	out, _ := yaml.Marshal(&wrapper{Stimmtausch: dump})
 	return string(out)
 }
 
@@ -157,5 +390,11 @@ func New() (*Config, error) {
 	log.Debugf("loading configuration")
 	InitDirs()
 
-	return load()
+	cfg, err := load()
+	if err != nil {
+		return nil, err
+	}
+	cfg.restoreTriggerState(cfg.loadTriggerState())
+	cfg.SaveTriggerState()
+	return cfg, nil
 }
@@ -1,9 +1,11 @@
 package config_test
 
 import (
+	"fmt"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v2"
 
 	"github.com/makyo/stimmtausch/config"
 )
@@ -47,13 +49,18 @@ func stubConfig() *config.Config {
 			},
 			config.Trigger{
 				Type:  "macro",
-				Match: "Mickey Smith",
+				Match: "(Mickey) Smith",
+				Macro: "greet",
 			},
 			config.Trigger{
-				Type:  "script",
-				Match: "Donna Noble",
+				Type:   "script",
+				Match:  "Donna (Noble)",
+				Script: "~/.config/stimmtausch/donna.sh",
 			},
 		},
+		Macros: map[string][]string{
+			"greet": []string{":waves to $1.", "/log greeted"},
+		},
 		Client: config.Client{
 			Syslog: config.Syslog{
 				ShowSyslog: false,
@@ -84,6 +91,37 @@ func stubConfig() *config.Config {
 func TestConfig(t *testing.T) {
 	Convey("When creating config", t, func() {
 
+		Convey("The default config is valid", func() {
+			var wrap struct {
+				Stimmtausch config.Config
+			}
+			So(yaml.Unmarshal([]byte(config.DefaultConfig), &wrap), ShouldBeNil)
+			c := wrap.Stimmtausch
+			So(c.FinalizeAndValidate(), ShouldBeEmpty)
+			So(c.ServerTypes, ShouldContainKey, "mush")
+			So(c.ServerTypes, ShouldContainKey, "moo")
+			So(len(c.ServerTypes["diku"].Login), ShouldEqual, 4)
+			So(c.ServerTypes["diku"].Login[0].Matches("By what name do you wish to be known?"), ShouldBeTrue)
+			So(c.ServerTypes["diku"].Login[2].Optional, ShouldBeTrue)
+			So(len(c.ServerTypes["lpmud"].Login), ShouldEqual, 3)
+			muck := c.ServerTypes["muck"]
+			for line, name := range map[string]string{
+				"Martha has connected.":                        "Martha",
+				"Martha has reconnected.":                      "Martha",
+				"Somewhere on the muck, Martha has connected.": "Martha",
+				"[Martha has connected.]":                      "Martha",
+			} {
+				event, found := muck.Announcement(line)
+				So(event, ShouldEqual, config.WatchEventConnected)
+				So(found, ShouldEqual, name)
+			}
+			event, name := muck.Announcement("Martha has disconnected.")
+			So(event, ShouldEqual, config.WatchEventDisconnected)
+			So(name, ShouldEqual, "Martha")
+			event, _ = muck.Announcement("Martha says, \"Rose has connected.\"")
+			So(event, ShouldEqual, "")
+		})
+
 		Convey("It can be validated and finalized", func() {
 
 			Convey("If valid, it sets names on worlds and servers and compiles triggers", func() {
@@ -95,6 +133,28 @@ func TestConfig(t *testing.T) {
 				So(len(c.CompiledTriggers), ShouldEqual, 4)
 			})
 
+			Convey("It requires macros to have names and commands", func() {
+				c := stubConfig()
+				c.Macros["bad wolf"] = []string{"/log bad wolf"}
+				c.Macros["empty"] = []string{}
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 2)
+				So(errs, ShouldContain, fmt.Errorf("invalid macro name bad wolf"))
+				So(errs, ShouldContain, fmt.Errorf("macro empty has no commands"))
+			})
+
+			Convey("It requires plugins to have a command", func() {
+				c := stubConfig()
+				c.Plugins = map[string]config.Plugin{
+					"k9": config.Plugin{Signals: []string{"*"}},
+				}
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 1)
+				So(errs[0].Error(), ShouldEqual, "plugin k9 has no command")
+				So(c.Plugins["k9"].Name, ShouldEqual, "k9")
+				So(c.Plugins["k9"].WantsSignal("_client:connect"), ShouldBeTrue)
+			})
+
 			Convey("It requires a version greater than 0", func() {
 				c := stubConfig()
 				c.Version = 0
@@ -113,6 +173,34 @@ func TestConfig(t *testing.T) {
 				So(errs[0].Error(), ShouldEqual, "world stubworld refers to unknown server bad-wolf")
 			})
 
+			Convey("World hooks must be for known events and do one thing", func() {
+				c := stubConfig()
+				w := c.Worlds["stubworld"]
+				w.Hooks = map[string][]config.Hook{
+					config.HookLoggedIn: []config.Hook{
+						config.Hook{Command: "+channel on"},
+						config.Hook{Macro: "greet"},
+						config.Hook{},
+						config.Hook{Command: "@set me=!idle", Script: "unidle.sh"},
+						config.Hook{Macro: "bad-wolf"},
+					},
+				}
+				c.Worlds["stubworld"] = w
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 3)
+				So(errs[0].Error(), ShouldEqual, "world stubworld logged_in hook 3 must have exactly one of a command, macro, or script")
+				So(errs[1].Error(), ShouldEqual, "world stubworld logged_in hook 4 must have exactly one of a command, macro, or script")
+				So(errs[2].Error(), ShouldEqual, "world stubworld logged_in hook 5 refers to unknown macro bad-wolf")
+
+				w.Hooks = map[string][]config.Hook{
+					"regenerated": []config.Hook{config.Hook{Command: "look"}},
+				}
+				c.Worlds["stubworld"] = w
+				errs = c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 1)
+				So(errs[0].Error(), ShouldEqual, "world stubworld has hooks for unknown event regenerated")
+			})
+
 			Convey("Servers must refer to existing server types", func() {
 				c := stubConfig()
 				s := c.Servers["stubserver"]
@@ -122,6 +210,95 @@ func TestConfig(t *testing.T) {
 				So(len(errs), ShouldEqual, 1)
 				So(errs[0].Error(), ShouldEqual, "server stubserver refers to unknown server type bad-wolf")
 			})
+
+			Convey("Server types may have a ping command", func() {
+				c := stubConfig()
+				st := c.ServerTypes["stubtype"]
+				st.PingCommand = "@ping"
+				st.PingResponse = "^Pong!$"
+				c.ServerTypes["stubtype"] = st
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 0)
+				st = c.ServerTypes["stubtype"]
+				So(st.Pings(), ShouldBeTrue)
+				So(st.PingInterval, ShouldEqual, 60)
+				So(st.IsPingResponse("Pong!"), ShouldBeTrue)
+				So(st.IsPingResponse("Rose says, \"Pong!\""), ShouldBeFalse)
+
+				Convey("But the response must be a valid regexp", func() {
+					st.PingResponse = "*asdf("
+					c.ServerTypes["stubtype"] = st
+					errs := c.FinalizeAndValidate()
+					So(len(errs), ShouldEqual, 1)
+					So(errs[0].Error(), ShouldStartWith, "server type stubtype has an invalid ping response")
+				})
+			})
+
+			Convey("Server types may have patterns for the watch list", func() {
+				c := stubConfig()
+				st := c.ServerTypes["stubtype"]
+				st.WatchConnected = []string{"^(?P<name>\\w+) arrives\\.$"}
+				st.WatchDisconnected = []string{"^\\w+ leaves\\.$", "^(\\w+"}
+				c.ServerTypes["stubtype"] = st
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 2)
+				So(errs[0].Error(), ShouldEqual, "server type stubtype watch_disconnected pattern 1 has no group for the name")
+				So(errs[1].Error(), ShouldStartWith, "server type stubtype watch_disconnected pattern 2 is invalid")
+				event, name := c.ServerTypes["stubtype"].Announcement("Martha arrives.")
+				So(event, ShouldEqual, config.WatchEventConnected)
+				So(name, ShouldEqual, "Martha")
+			})
+
+			Convey("Server types without a ping command don't ping", func() {
+				c := stubConfig()
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 0)
+				So(c.ServerTypes["stubtype"].Pings(), ShouldBeFalse)
+			})
+
+			Convey("Server types may have a login script", func() {
+				c := stubConfig()
+				st := c.ServerTypes["stubtype"]
+				st.Login = []config.LoginStep{
+					config.LoginStep{Expect: "^By what name", Send: "$username"},
+					config.LoginStep{Expect: "^Password:", Send: "$password", Timeout: 5},
+					config.LoginStep{Send: "1"},
+				}
+				c.ServerTypes["stubtype"] = st
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 0)
+				login := c.ServerTypes["stubtype"].Login
+				So(login[0].Waits(), ShouldBeTrue)
+				So(login[0].Timeout, ShouldEqual, 30)
+				So(login[0].Matches("By what name do you wish to be known?"), ShouldBeTrue)
+				So(login[0].Matches("Password:"), ShouldBeFalse)
+				So(login[1].Timeout, ShouldEqual, 5)
+				So(login[2].Waits(), ShouldBeFalse)
+
+				Convey("But the expects must be valid regexps", func() {
+					login[1].Expect = "*asdf("
+					errs := c.FinalizeAndValidate()
+					So(len(errs), ShouldEqual, 1)
+					So(errs[0].Error(), ShouldStartWith, "server type stubtype has an invalid expect in login step 2")
+				})
+
+				Convey("And steps must do something", func() {
+					login[2].Send = ""
+					errs := c.FinalizeAndValidate()
+					So(len(errs), ShouldEqual, 1)
+					So(errs[0].Error(), ShouldEqual, "server type stubtype has an empty login step 3")
+				})
+			})
+
+			Convey("Scripts must have a sensible timeout and limit", func() {
+				c := stubConfig()
+				c.Client.Scripts = config.Scripts{Timeout: -1, MaxConcurrent: -4}
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 2)
+				So(errs[0].Error(), ShouldEqual, "scripts timeout must be at least 1 second, not -1")
+				So(errs[1].Error(), ShouldEqual, "scripts max_concurrent must be at least 1, not -4")
+				So(c.Client.Scripts, ShouldResemble, config.Scripts{Timeout: 10, MaxConcurrent: 4})
+			})
 		})
 	})
 }
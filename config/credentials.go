@@ -0,0 +1,233 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// credentialsFile is the name of the encrypted credentials file within the
+// config directory.
+const credentialsFile = "credentials.enc"
+
+// PasswordCommandTimeout is how long a world's password command may take
+// before it's given up on, so that a stuck command doesn't hold up connecting.
+var PasswordCommandTimeout = 30 * time.Second
+
+// Parameters for deriving the key used to encrypt credentials from the
+// passphrase.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// redacted replaces secrets when dumping config.
+const redacted = "********"
+
+// ErrBadPassphrase is returned when the credentials file can't be decrypted.
+var ErrBadPassphrase = errors.New("unable to decrypt credentials; is the passphrase correct?")
+
+// encryptedCredentials is the format of the credentials file on disk.
+type encryptedCredentials struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// credentialsCipher derives the key from the passphrase and returns the
+// cipher to use with it.
+func credentialsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCredentials encrypts a map of world names to passwords with the
+// given passphrase.
+func encryptCredentials(creds map[string]string, passphrase string) ([]byte, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	enc := encryptedCredentials{
+		Version: 1,
+		Salt:    make([]byte, saltLen),
+	}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := credentialsCipher(passphrase, enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return nil, err
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, plaintext, nil)
+	return json.Marshal(enc)
+}
+
+// decryptCredentials decrypts a map of world names to passwords with the
+// given passphrase.
+func decryptCredentials(data []byte, passphrase string) (map[string]string, error) {
+	var enc encryptedCredentials
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, fmt.Errorf("unable to read credentials: %v", err)
+	}
+	if enc.Version != 1 {
+		return nil, fmt.Errorf("unknown credentials version %d", enc.Version)
+	}
+	aead, err := credentialsCipher(passphrase, enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("unable to read credentials: bad nonce")
+	}
+	plaintext, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	creds := map[string]string{}
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("unable to read credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// CredentialsFile returns the path to the encrypted credentials file.
+func (c *Config) CredentialsFile() string {
+	return filepath.Join(c.ConfigDir, credentialsFile)
+}
+
+// HasCredentials returns whether or not there is a credentials file to
+// unlock.
+func (c *Config) HasCredentials() bool {
+	_, err := os.Stat(c.CredentialsFile())
+	return err == nil
+}
+
+// UnlockCredentials decrypts the credentials file with the given passphrase
+// so that passwords stored in it may be used. If there is no credentials
+// file, this starts with an empty set of credentials.
+func (c *Config) UnlockCredentials(passphrase string) error {
+	log.Tracef("unlocking credentials")
+	data, err := os.ReadFile(c.CredentialsFile())
+	if os.IsNotExist(err) {
+		c.credentials = map[string]string{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	creds, err := decryptCredentials(data, passphrase)
+	if err != nil {
+		return err
+	}
+	c.credentials = creds
+	log.Debugf("unlocked credentials for %d worlds", len(creds))
+	return nil
+}
+
+// SaveCredentials encrypts the credentials with the given passphrase and
+// writes them to the credentials file.
+func (c *Config) SaveCredentials(passphrase string) error {
+	data, err := encryptCredentials(c.credentials, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.ConfigDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.CredentialsFile(), data, 0600)
+}
+
+// SetCredential stores the password for the given world in the unlocked
+// credentials.
+func (c *Config) SetCredential(world, password string) {
+	if c.credentials == nil {
+		c.credentials = map[string]string{}
+	}
+	c.credentials[world] = password
+}
+
+// RemoveCredential removes the password for the given world from the
+// unlocked credentials, returning whether or not there was one.
+func (c *Config) RemoveCredential(world string) bool {
+	_, ok := c.credentials[world]
+	delete(c.credentials, world)
+	return ok
+}
+
+// CredentialWorlds returns the names of the worlds which have passwords in the
+// unlocked credentials.
+func (c *Config) CredentialWorlds() []string {
+	worlds := []string{}
+	for world := range c.credentials {
+		worlds = append(worlds, world)
+	}
+	sort.Strings(worlds)
+	return worlds
+}
+
+// Password returns the password for the given world. It comes from the first
+// of the following which is set: the password in the config, the output of
+// the world's password command, or the unlocked credentials file.
+func (c *Config) Password(w World) (string, error) {
+	if w.Password != "" {
+		return w.Password, nil
+	}
+	if w.PasswordCommand != "" {
+		log.Tracef("running password command for %s", w.Name)
+		cmd := exec.Command("sh", "-c", w.PasswordCommand)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Start(); err != nil {
+			return "", fmt.Errorf("password command for %s failed: %v", w.Name, err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				return "", fmt.Errorf("password command for %s failed: %v %s", w.Name, err, strings.TrimSpace(stderr.String()))
+			}
+		case <-time.After(PasswordCommandTimeout):
+			cmd.Process.Kill()
+			return "", fmt.Errorf("password command for %s took longer than %v", w.Name, PasswordCommandTimeout)
+		}
+		out := stdout.Bytes()
+		// Only the first line is used, as with pass and friends.
+		return strings.TrimRight(strings.SplitN(string(out), "\n", 2)[0], "\r"), nil
+	}
+	return c.credentials[w.Name], nil
+}
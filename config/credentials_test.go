@@ -0,0 +1,113 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+func TestCredentials(t *testing.T) {
+	Convey("When dealing with passwords", t, func() {
+		c := stubConfig()
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+		c.ConfigDir = t.TempDir()
+		w := c.Worlds["stubworld"]
+
+		Convey("The password in the config is used first", func() {
+			password, err := c.Password(w)
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "pass")
+		})
+
+		Convey("The password command is used next", func() {
+			w.Password = ""
+			w.PasswordCommand = "echo badwolf; echo url: example.com"
+			password, err := c.Password(w)
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "badwolf")
+
+			Convey("And errors if it fails", func() {
+				w.PasswordCommand = "echo nope >&2; exit 1"
+				_, err := c.Password(w)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "password command for stubworld failed")
+				So(err.Error(), ShouldEndWith, "nope")
+			})
+
+			Convey("And gives up if it takes too long", func() {
+				timeout := config.PasswordCommandTimeout
+				config.PasswordCommandTimeout = 100 * time.Millisecond
+				defer func() { config.PasswordCommandTimeout = timeout }()
+				w.PasswordCommand = "sleep 5; echo badwolf"
+				start := time.Now()
+				_, err := c.Password(w)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "password command for stubworld took longer than 100ms")
+				So(time.Since(start), ShouldBeLessThan, time.Second)
+			})
+
+			Convey("But not both", func() {
+				w.Password = "pass"
+				c.Worlds["stubworld"] = w
+				errs := c.FinalizeAndValidate()
+				So(len(errs), ShouldEqual, 1)
+				So(errs[0].Error(), ShouldEqual, "world stubworld has both a password and a password command")
+			})
+		})
+
+		Convey("The credentials file is used last", func() {
+			w.Password = ""
+			So(c.HasCredentials(), ShouldBeFalse)
+			So(c.UnlockCredentials("allons-y"), ShouldBeNil)
+			c.SetCredential("stubworld", "badwolf")
+			c.SetCredential("anotherworld", "geronimo")
+			So(c.SaveCredentials("allons-y"), ShouldBeNil)
+			So(c.HasCredentials(), ShouldBeTrue)
+			contents, err := os.ReadFile(c.CredentialsFile())
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldNotContainSubstring, "badwolf")
+
+			other := stubConfig()
+			So(other.FinalizeAndValidate(), ShouldBeEmpty)
+			other.ConfigDir = c.ConfigDir
+			So(other.UnlockCredentials("allons-y"), ShouldBeNil)
+			So(other.CredentialWorlds(), ShouldResemble, []string{"anotherworld", "stubworld"})
+			password, err := other.Password(w)
+			So(err, ShouldBeNil)
+			So(password, ShouldEqual, "badwolf")
+
+			Convey("Credentials may be removed", func() {
+				So(other.RemoveCredential("stubworld"), ShouldBeTrue)
+				So(other.RemoveCredential("stubworld"), ShouldBeFalse)
+				password, err := other.Password(w)
+				So(err, ShouldBeNil)
+				So(password, ShouldEqual, "")
+			})
+
+			Convey("The file can't be unlocked with the wrong passphrase", func() {
+				So(other.UnlockCredentials("geronimo"), ShouldEqual, config.ErrBadPassphrase)
+			})
+		})
+
+		Convey("Passwords are redacted", func() {
+			So(c.Dump(), ShouldNotContainSubstring, "pass\n")
+			So(c.Dump(), ShouldContainSubstring, "password: '********'")
+			So(c.Worlds["stubworld"].Password, ShouldEqual, "pass")
+			So(fmt.Sprintf("%+v", w), ShouldNotContainSubstring, "pass ")
+			So(fmt.Sprintf("%v", w), ShouldContainSubstring, "Password:********")
+			So(fmt.Sprintf("%#v", w), ShouldContainSubstring, "Password:********")
+			So(fmt.Sprintf("%+v", struct{ Worlds []config.World }{[]config.World{w}}), ShouldContainSubstring, "Password:********")
+		})
+	})
+}
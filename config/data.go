@@ -0,0 +1,173 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of out-of-band data which triggers may match.
+const (
+	SourceGMCP = "gmcp"
+	SourceMSDP = "msdp"
+	SourceMCP  = "mcp"
+)
+
+// checkSource checks that a trigger on out-of-band data has what it needs, and
+// that a trigger on lines doesn't have anything meant for out-of-band data.
+func (t *Trigger) checkSource() error {
+	switch t.Source {
+	case "":
+		if t.Path != "" || t.Below != nil || t.Above != nil {
+			return fmt.Errorf("trigger %s has a path, below, or above but no source", t.label)
+		}
+		return nil
+	case SourceGMCP, SourceMSDP, SourceMCP:
+	default:
+		return fmt.Errorf("trigger %s has an unknown source %s; it should be one of gmcp, msdp, or mcp", t.label, t.Source)
+	}
+	if t.Path == "" {
+		return fmt.Errorf("no path for trigger %s on %s data", t.label, t.Source)
+	}
+	switch t.Type {
+	case "script", "macro", "notify", "callback":
+	default:
+		return fmt.Errorf("trigger %s on %s data can't be a %s trigger; it should be a script, macro, notify, or callback", t.label, t.Source, t.Type)
+	}
+	if t.End != "" || t.MaxLines != 0 {
+		return fmt.Errorf("trigger %s on %s data can't act on a block", t.label, t.Source)
+	}
+	return nil
+}
+
+// IsThreshold returns whether or not the trigger fires when a value crosses a
+// number, rather than every time it matches.
+func (t *Trigger) IsThreshold() bool {
+	return t.Below != nil || t.Above != nil
+}
+
+// DataPackage returns the package or message the trigger on out-of-band data
+// watches, which is the first part of its path.
+func (t *Trigger) DataPackage() string {
+	return strings.SplitN(t.Path, ".", 2)[0]
+}
+
+// watchesData returns whether or not the trigger is an enabled trigger on
+// out-of-band data from the source in the world.
+func (t *Trigger) watchesData(world, source string) bool {
+	if t.Disabled || t.Source != source || (t.World != "" && t.World != world) {
+		return false
+	}
+	return !(t.IsTemporary() && t.Expired(time.Now()))
+}
+
+// MatchData looks up the trigger's path within a message for a package from
+// the source in the world. It returns the text of the value found, whether or
+// not the message had it at all, and whether or not the value matches.
+func (t *Trigger) MatchData(world, source, pkg string, data interface{}) (string, bool, bool) {
+	if !t.watchesData(world, source) {
+		return "", false, false
+	}
+	var fields []string
+	switch {
+	case strings.EqualFold(t.Path, pkg):
+	case len(t.Path) > len(pkg) && strings.EqualFold(t.Path[:len(pkg)], pkg) && t.Path[len(pkg)] == '.':
+		fields = strings.Split(t.Path[len(pkg)+1:], ".")
+	default:
+		return "", false, false
+	}
+	value, ok := lookupField(data, fields)
+	if !ok {
+		return "", false, false
+	}
+	text := dataText(value)
+	if t.Below != nil || t.Above != nil {
+		n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return text, true, false
+		}
+		if (t.Below != nil && n >= *t.Below) || (t.Above != nil && n <= *t.Above) {
+			return text, true, false
+		}
+	}
+	if len(t.reList) == 0 {
+		return text, true, true
+	}
+	for _, re := range t.reList {
+		if re.MatchString(text) {
+			return text, true, true
+		}
+	}
+	return text, true, false
+}
+
+// lookupField follows the fields down through objects and arrays within the
+// data, returning the value at the end and whether or not it was there.
+func lookupField(data interface{}, fields []string) (interface{}, bool) {
+	for _, field := range fields {
+		switch d := data.(type) {
+		case map[string]interface{}:
+			value, ok := d[field]
+			if !ok {
+				for key, v := range d {
+					if strings.EqualFold(key, field) {
+						value, ok = v, true
+						break
+					}
+				}
+			}
+			if !ok {
+				return nil, false
+			}
+			data = value
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			data = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// dataText returns the text of a value: strings as they are, and anything else
+// as JSON.
+func dataText(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(out)
+}
+
+// DataPackages returns the packages which triggers on out-of-band data from
+// the source in the world watch, so that the world can be asked to send them.
+func (c *Config) DataPackages(world, source string) []string {
+	var packages []string
+	seen := map[string]bool{}
+	for _, t := range c.TriggerList() {
+		if t == nil || !t.watchesData(world, source) {
+			continue
+		}
+		pkg := t.DataPackage()
+		if !seen[strings.ToLower(pkg)] {
+			seen[strings.ToLower(pkg)] = true
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
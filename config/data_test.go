@@ -0,0 +1,159 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+func TestDataTriggers(t *testing.T) {
+	Convey("When creating triggers on out-of-band data", t, func() {
+		twenty := 20.0
+
+		Convey("They must have a known source, a path, and a sensible type", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:   "vitals",
+				Type:   "macro",
+				Macro:  "greet",
+				Source: "gmcp",
+				Path:   "Char.Vitals.hp",
+				Below:  &twenty,
+			}, config.Trigger{
+				Name:   "telepathy",
+				Type:   "notify",
+				Source: "psychic-paper",
+				Path:   "Char.Vitals.hp",
+			}, config.Trigger{
+				Name:   "status",
+				Type:   "notify",
+				Source: "mcp",
+			}, config.Trigger{
+				Name:       "health",
+				Type:       "hilite",
+				Attributes: "red",
+				Source:     "msdp",
+				Path:       "HEALTH",
+			}, config.Trigger{
+				Name:   "room",
+				Type:   "notify",
+				Source: "gmcp",
+				Path:   "Room.Info",
+				End:    "^$",
+			}, config.Trigger{
+				Name:  "hp",
+				Type:  "gag",
+				Match: "HP",
+				Path:  "Char.Vitals.hp",
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 5)
+			So(errs[0].Error(), ShouldEqual, "trigger telepathy has an unknown source psychic-paper; it should be one of gmcp, msdp, or mcp")
+			So(errs[1].Error(), ShouldEqual, "no path for trigger status on mcp data")
+			So(errs[2].Error(), ShouldEqual, "trigger health on msdp data can't be a hilite trigger; it should be a script, macro, notify, or callback")
+			So(errs[3].Error(), ShouldEqual, "trigger room on gmcp data can't act on a block")
+			So(errs[4].Error(), ShouldEqual, "trigger hp has a path, below, or above but no source")
+		})
+	})
+
+	Convey("When matching out-of-band data", t, func() {
+		twenty := 20.0
+		c := stubConfig()
+		c.Triggers = append(c.Triggers, config.Trigger{
+			Name:   "vitals",
+			Type:   "notify",
+			Source: "gmcp",
+			Path:   "Char.Vitals.hp",
+			Below:  &twenty,
+		}, config.Trigger{
+			Name:   "status",
+			Type:   "notify",
+			Source: "mcp",
+			Path:   "dns-com-awns-status.text",
+			Match:  "(?i)away",
+		}, config.Trigger{
+			Name:   "exits",
+			Type:   "notify",
+			World:  "tardis",
+			Source: "msdp",
+			Path:   "ROOM.EXITS.1",
+		})
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+		var vitals, status, exits *config.Trigger
+		for _, t := range c.TriggerList() {
+			switch t.Name {
+			case "vitals":
+				vitals = t
+			case "status":
+				status = t
+			case "exits":
+				exits = t
+			}
+		}
+		gmcp := func(payload string) interface{} {
+			var data interface{}
+			So(json.Unmarshal([]byte(payload), &data), ShouldBeNil)
+			return data
+		}
+
+		Convey("Values are found by their path within the package", func() {
+			text, found, applies := vitals.MatchData("world", "gmcp", "Char.Vitals", gmcp(`{"hp": 15, "mp": 40}`))
+			So(text, ShouldEqual, "15")
+			So(found, ShouldBeTrue)
+			So(applies, ShouldBeTrue)
+			_, found, applies = vitals.MatchData("world", "gmcp", "char.vitals", gmcp(`{"HP": "25"}`))
+			So(found, ShouldBeTrue)
+			So(applies, ShouldBeFalse)
+		})
+
+		Convey("Other packages, sources, and missing fields aren't found", func() {
+			_, found, _ := vitals.MatchData("world", "gmcp", "Char.Status", gmcp(`{"hp": 15}`))
+			So(found, ShouldBeFalse)
+			_, found, _ = vitals.MatchData("world", "gmcp", "Char.Vitals", gmcp(`{"mp": 15}`))
+			So(found, ShouldBeFalse)
+			_, found, _ = vitals.MatchData("world", "msdp", "Char.Vitals", gmcp(`{"hp": 15}`))
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("Values may be matched against", func() {
+			text, found, applies := status.MatchData("world", "mcp", "dns-com-awns-status", map[string]interface{}{"text": "Away for tea"})
+			So(text, ShouldEqual, "Away for tea")
+			So(found, ShouldBeTrue)
+			So(applies, ShouldBeTrue)
+			_, _, applies = status.MatchData("world", "mcp", "dns-com-awns-status", map[string]interface{}{"text": "Back"})
+			So(applies, ShouldBeFalse)
+			So(status.Submatches("Away for tea"), ShouldResemble, [][]string{{"Away"}})
+			So(vitals.Submatches("15"), ShouldResemble, [][]string{{"15"}})
+		})
+
+		Convey("Paths may reach into tables and arrays, in the trigger's world", func() {
+			data := map[string]interface{}{"EXITS": []interface{}{"n", "e"}}
+			text, found, applies := exits.MatchData("tardis", "msdp", "ROOM", data)
+			So(text, ShouldEqual, "e")
+			So(found, ShouldBeTrue)
+			So(applies, ShouldBeTrue)
+			_, found, _ = exits.MatchData("world", "msdp", "ROOM", data)
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("The packages watched in each world are known", func() {
+			So(c.DataPackages("world", "gmcp"), ShouldResemble, []string{"Char"})
+			So(c.DataPackages("world", "msdp"), ShouldBeEmpty)
+			So(c.DataPackages("tardis", "msdp"), ShouldResemble, []string{"ROOM"})
+		})
+
+		Convey("They never match lines", func() {
+			applies, _, _ := status.Run("world", "Away", c)
+			So(applies, ShouldBeFalse)
+		})
+	})
+}
@@ -9,6 +9,56 @@ stimmtausch:
       name: "TinyMUCK, FuzzballMUCK, etc."
       connect_string: "connect $username $password"
       disconnect_string: "QUIT"
+      # To measure lag, set a command to send periodically along with a regexp
+      # matching the server's response to it, e.g:
+      # ping_command: "@@ping"
+      # ping_response: "^Huh\\?"
+      # ping_interval: 60
+      # Regexps matching announcements of characters connecting and
+      # disconnecting, for /watch. The name is the group named "name", or else
+      # the first group.
+      watch_connected:
+        - '\b(\w+) has (?:re)?connected\.\]?$'
+      watch_disconnected:
+        - '\b(\w+) has disconnected\.\]?$'
+    mush:
+      name: "PennMUSH, TinyMUSH, RhostMUSH, etc."
+      connect_string: "connect $username $password"
+      disconnect_string: "QUIT"
+    moo:
+      name: "LambdaMOO, etc."
+      connect_string: "connect $username $password"
+      disconnect_string: "@quit"
+    # Servers which ask for the username and password separately need a login
+    # script. Each step waits for a line matching "expect", then sends "send"
+    # (an empty line if it's not set). Optional steps are skipped if nothing
+    # matches within the timeout (in seconds, default 30).
+    diku:
+      name: "DikuMUD, CircleMUD, ROM, etc."
+      disconnect_string: "quit"
+      login:
+        - expect: "(?i)(by what name|what is your name|name\\?)"
+          send: "$username"
+        - expect: "(?i)password"
+          send: "$password"
+        - expect: "(?i)press (return|enter)"
+          optional: true
+          timeout: 5
+        - expect: "(?i)(make your choice|enter the game)"
+          send: "1"
+          optional: true
+          timeout: 5
+    lpmud:
+      name: "LPMud, Discworld, etc."
+      disconnect_string: "quit"
+      login:
+        - expect: "(?i)(what is your name|enter your name|login:)"
+          send: "$username"
+        - expect: "(?i)password"
+          send: "$password"
+        - expect: "(?i)press (return|enter)"
+          optional: true
+          timeout: 5
   servers:
     spr:
       host: muck.sprmuck.org
@@ -81,6 +131,51 @@ stimmtausch:
       # Whether or not to keep the log for the connection to the world after
       # disconnecting.
       log_world: true
+
+      # Whether or not to record everything received from the server, along
+      # with when it was received, so that it can be played back later with
+      # "stimmtausch replay". Recordings are kept in the world's log directory.
+      record: false
+
+    # Settings pertaining to scripts run by triggers.
+    scripts:
+      # How long, in seconds, a script may run before it is killed. This is
+      # also how long each call into a Starlark script may run.
+      timeout: 10
+
+      # How many scripts may be running at once for each world. If a trigger
+      # matches while that many are running, its script isn't run.
+      max_concurrent: 4
+
+    # Settings pertaining to notifications from notify triggers.
+    notify:
+      # Whether or not to ring the terminal bell.
+      bell: true
+
+      # A command to run for each notification, such as "notify-send", which is
+      # passed the world's name and the line as its last two arguments. Leave
+      # blank to not run anything.
+      command: ""
+
+      # The fewest seconds between notifications from each world. Any which
+      # come sooner are dropped.
+      rate_limit: 10
+
+    # Settings pertaining to coloring characters' names in worlds which have
+    # color_names turned on. Each name always gets the same color from the
+    # palette.
+    name_colors:
+      # The colors to choose from.
+      palette: ["red", "green", "yellow", "blue", "magenta", "cyan", "208", "118", "171", "45", "214", "141"]
+
+      # Names to color whenever they're seen.
+      names: []
+
+      # Regular expressions matching lines which mention a name, using the
+      # group named "name" or else the first group. Names found this way are
+      # colored from then on.
+      patterns:
+        - '^(\w+) (says|asks|exclaims|whispers|pages),'
     
     # Settings pertaining to the user interface
     ui:
@@ -124,5 +219,7 @@ stimmtausch:
           disconnected_more: "mediumvioletred+underline"
           # Disconnected world (non-focused) with unread lines
           disconnected_active: "deeppink3"
+          # Non-focused world with a notification
+          urgent: "bold+red"
         modal_title: "bold+cyan"
 `
@@ -0,0 +1,171 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	ansi "github.com/makyo/ansigo"
+
+	"github.com/makyo/stimmtausch/styled"
+)
+
+// NameColors holds information regarding coloring characters' names in worlds
+// which have it turned on.
+type NameColors struct {
+
+	// The colors to choose from in ansigo specifications. Each name always
+	// gets the same one, chosen by hashing it.
+	Palette []string
+
+	// Names to color whenever they're seen.
+	Names []string
+
+	// Regular expressions matching lines which mention a name, such as
+	// `^(\w+) says,`, using the group named "name" or else the first group.
+	// Names found this way are colored from then on in the world where they
+	// were found.
+	Patterns []string
+
+	// The compiled palette, names, and patterns.
+	palette  []styled.Style
+	namesRe  *regexp.Regexp
+	patterns []namePattern
+}
+
+// namePattern is a compiled pattern along with the group holding the name.
+type namePattern struct {
+	re    *regexp.Regexp
+	group int
+}
+
+// compile compiles the palette, names, and patterns, returning any errors
+// found in them.
+func (n *NameColors) compile() []error {
+	var errs []error
+	n.palette = nil
+	for _, attributes := range n.Palette {
+		if _, err := ansi.Apply(attributes, ""); err != nil {
+			errs = append(errs, fmt.Errorf("name colors palette has an invalid color %s: %v", attributes, err))
+			continue
+		}
+		n.palette = append(n.palette, attributeStyle("name colors", attributes))
+	}
+	n.namesRe = NamesRegexp(n.Names)
+	n.patterns = nil
+	for i, pattern := range n.Patterns {
+		p, err := compileNamePattern(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("name colors pattern %d %v", i+1, err))
+			continue
+		}
+		n.patterns = append(n.patterns, p)
+	}
+	return errs
+}
+
+// compileNamePattern compiles a pattern which picks a name out of a line.
+func compileNamePattern(pattern string) (namePattern, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return namePattern{}, fmt.Errorf("is invalid: %v", err)
+	}
+	group := re.SubexpIndex("name")
+	if group < 0 {
+		group = 1
+	}
+	if re.NumSubexp() < group {
+		return namePattern{}, fmt.Errorf("has no group for the name")
+	}
+	return namePattern{re: re, group: group}, nil
+}
+
+// find returns the name in the line, if the pattern matches it.
+func (p namePattern) find(line string) string {
+	match := p.re.FindStringSubmatch(line)
+	if match == nil {
+		return ""
+	}
+	return match[p.group]
+}
+
+// NamesRegexp returns a regular expression matching any of the names as whole
+// words, or nil if there aren't any.
+func NamesRegexp(names []string) *regexp.Regexp {
+	if len(names) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	// Try longer names first, so that one name doesn't hide another which
+	// starts with it.
+	sort.SliceStable(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+	return regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// Find returns the names which the patterns find in the line.
+func (n *NameColors) Find(line string) []string {
+	var names []string
+	for _, p := range n.patterns {
+		for _, match := range p.re.FindAllStringSubmatch(line, -1) {
+			if name := match[p.group]; name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Style returns the style for a name, which is the same for the name no matter
+// its case.
+func (n *NameColors) Style(name string) styled.Style {
+	if len(n.palette) == 0 {
+		return styled.Style{}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(name)))
+	return n.palette[h.Sum32()%uint32(len(n.palette))]
+}
+
+// Color colors the configured names in the line, along with any matched by
+// found, which may be nil. Names which triggers have already styled are left
+// as they are.
+func (n *NameColors) Color(line *styled.Line, found *regexp.Regexp) {
+	if len(n.palette) == 0 {
+		return
+	}
+	for _, re := range []*regexp.Regexp{n.namesRe, found} {
+		if re == nil {
+			continue
+		}
+		for _, match := range re.FindAllStringIndex(line.Text, -1) {
+			if styledWithin(line, match[0], match[1]) {
+				continue
+			}
+			line.Apply(match[0], match[1], n.Style(line.Text[match[0]:match[1]]))
+		}
+	}
+}
+
+// styledWithin returns whether or not any of the line's styles lie entirely
+// within the text between the two offsets.
+func styledWithin(line *styled.Line, start, end int) bool {
+	for _, span := range line.Spans {
+		if span.Start >= start && span.End <= end {
+			return true
+		}
+	}
+	return false
+}
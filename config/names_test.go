@@ -0,0 +1,76 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config_test
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/styled"
+)
+
+func TestNameColors(t *testing.T) {
+	Convey("When coloring names", t, func() {
+		c := stubConfig()
+		c.Client.NameColors = config.NameColors{
+			Palette:  []string{"red", "green", "yellow", "blue", "magenta", "cyan"},
+			Names:    []string{"Rose", "Donna"},
+			Patterns: []string{"^(\\w+) says,", "^\\[\\w+\\] (?P<name>\\w+) pages"},
+		}
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+		nc := &c.Client.NameColors
+
+		Convey("Each name always gets the same color, whatever its case", func() {
+			So(nc.Style("Rose"), ShouldResemble, nc.Style("Rose"))
+			So(nc.Style("Rose"), ShouldResemble, nc.Style("ROSE"))
+			So(nc.Style("Rose").IsZero(), ShouldBeFalse)
+		})
+
+		Convey("Names are found with the patterns", func() {
+			So(nc.Find("Martha says, \"Doctor!\""), ShouldResemble, []string{"Martha"})
+			So(nc.Find("[Public] Jack pages"), ShouldResemble, []string{"Jack"})
+			So(nc.Find("Martha waves."), ShouldBeEmpty)
+		})
+
+		Convey("Configured and found names are colored as whole words", func() {
+			line := styled.Parse("Rose and Martha meet Roseanne.")
+			nc.Color(line, config.NamesRegexp([]string{"Martha"}))
+			So(line.Spans, ShouldResemble, []styled.Span{
+				styled.Span{Start: 0, End: 4, Style: nc.Style("Rose")},
+				styled.Span{Start: 9, End: 15, Style: nc.Style("Martha")},
+			})
+		})
+
+		Convey("Names already styled by triggers are left alone", func() {
+			line := styled.Parse("Rose and \x1b[1mDonna\x1b[22m.")
+			nc.Color(line, nil)
+			So(line.Spans, ShouldHaveLength, 2)
+			So(line.Spans[0].Start, ShouldEqual, 9)
+			So(line.Spans[1], ShouldResemble, styled.Span{Start: 0, End: 4, Style: nc.Style("Rose")})
+		})
+
+		Convey("An empty palette colors nothing", func() {
+			c.Client.NameColors.Palette = nil
+			So(c.FinalizeAndValidate(), ShouldBeEmpty)
+			line := styled.Parse("Rose")
+			nc.Color(line, nil)
+			So(line.Spans, ShouldBeEmpty)
+		})
+
+		Convey("Bad colors and patterns are errors", func() {
+			c.Client.NameColors.Palette = []string{"red", "not-a-color"}
+			c.Client.NameColors.Patterns = []string{"^\\w+ says,", "^(\\w+"}
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 3)
+			So(errs[0].Error(), ShouldStartWith, "name colors palette has an invalid color not-a-color")
+			So(errs[1].Error(), ShouldEqual, "name colors pattern 1 has no group for the name")
+			So(errs[2].Error(), ShouldStartWith, "name colors pattern 2 is invalid")
+		})
+	})
+}
@@ -0,0 +1,345 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"container/list"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The number of lines for which to remember which triggers might match.
+const pipelineCacheSize = 1024
+
+// pipelineMu guards building the pipeline for the current triggers.
+var pipelineMu sync.Mutex
+
+// Pipeline decides which triggers might match a line before their regexps are
+// run, so that lines don't have to be matched against every trigger. Each
+// regexp's required literal text, if it has any, is found with a single pass
+// over the line, and triggers for other worlds are skipped entirely.
+type Pipeline struct {
+	// The triggers the pipeline was built from.
+	triggers []*Trigger
+
+	// The literal text each trigger requires, by index into triggers. A
+	// trigger with any regexp which doesn't require literal text is always a
+	// candidate, and has a nil entry.
+	literals [][]int
+
+	// The automaton for finding literals.
+	matcher *acMatcher
+
+	// When the next of the triggers expires, if any expire after some time.
+	expiry time.Time
+
+	mu sync.Mutex
+
+	// The triggers, by index, which apply to each world.
+	worlds map[string][]int
+
+	// Recently seen lines and which of the world's triggers might match them.
+	cache      map[string]*list.Element
+	cacheOrder *list.List
+}
+
+// cacheEntry is a line seen by the pipeline and its candidate triggers.
+type cacheEntry struct {
+	key        string
+	candidates []bool
+}
+
+// Pipeline returns the pipeline for the current triggers, building a new one
+// if they have changed.
+func (c *Config) Pipeline() *Pipeline {
+	pipelineMu.Lock()
+	p := c.pipeline
+	pipelineMu.Unlock()
+	if p != nil && !p.expiry.IsZero() && !time.Now().Before(p.expiry) {
+		c.PruneTriggers()
+	}
+	triggers := c.TriggerList()
+	pipelineMu.Lock()
+	defer pipelineMu.Unlock()
+	if c.pipeline == nil || !sameTriggers(c.pipeline.triggers, triggers) {
+		c.pipeline = newPipeline(triggers)
+	}
+	return c.pipeline
+}
+
+// sameTriggers returns whether or not two lists of triggers are the same
+// list. Since triggers are always replaced rather than changed, this need
+// only check that they share the same storage.
+func sameTriggers(a, b []*Trigger) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
+
+// newPipeline builds a pipeline for the given triggers.
+func newPipeline(triggers []*Trigger) *Pipeline {
+	p := &Pipeline{
+		triggers:   triggers,
+		literals:   make([][]int, len(triggers)),
+		worlds:     map[string][]int{},
+		cache:      map[string]*list.Element{},
+		cacheOrder: list.New(),
+	}
+	var patterns []string
+	for i, t := range triggers {
+		if t == nil {
+			continue
+		}
+		if !t.expiresAt.IsZero() && (p.expiry.IsZero() || t.expiresAt.Before(p.expiry)) {
+			p.expiry = t.expiresAt
+		}
+		var ids []int
+		for _, re := range t.reList {
+			literal := requiredLiteral(re.String())
+			if literal == "" {
+				ids = nil
+				break
+			}
+			ids = append(ids, len(patterns))
+			patterns = append(patterns, literal)
+		}
+		p.literals[i] = ids
+	}
+	p.matcher = newACMatcher(patterns)
+	return p
+}
+
+// PipelineIter walks through the triggers which apply to a world for a line,
+// noting which might match.
+type PipelineIter struct {
+	pipeline   *Pipeline
+	world      string
+	indices    []int
+	candidates []bool
+	pos        int
+}
+
+// Iter returns an iterator over the triggers which apply to the given world, in
+// the order they should be run, for the given text of a line.
+func (p *Pipeline) Iter(world, text string) *PipelineIter {
+	it := &PipelineIter{
+		pipeline: p,
+		world:    world,
+		indices:  p.worldTriggers(world),
+	}
+	it.candidates = p.candidates(world, it.indices, text)
+	return it
+}
+
+// Next returns the next trigger and whether or not it might match the line,
+// or nil once there are no more.
+func (it *PipelineIter) Next() (*Trigger, bool) {
+	if it.pos >= len(it.indices) {
+		return nil, false
+	}
+	t := it.pipeline.triggers[it.indices[it.pos]]
+	candidate := it.candidates[it.pos]
+	it.pos++
+	return t, candidate
+}
+
+// Retext finds which of the remaining triggers might match after the text of
+// the line has been changed, such as by a replacement.
+func (it *PipelineIter) Retext(text string) {
+	it.candidates = it.pipeline.candidates(it.world, it.indices, text)
+}
+
+// worldTriggers returns the indices of the enabled triggers which apply to the
+// world.
+func (p *Pipeline) worldTriggers(world string) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if indices, ok := p.worlds[world]; ok {
+		return indices
+	}
+	indices := []int{}
+	for i, t := range p.triggers {
+		if t != nil && !t.Disabled && t.Source == "" && (t.World == "" || t.World == world) {
+			indices = append(indices, i)
+		}
+	}
+	p.worlds[world] = indices
+	return indices
+}
+
+// candidates returns whether each of the triggers might match the text.
+func (p *Pipeline) candidates(world string, indices []int, text string) []bool {
+	key := world + "\x00" + text
+	p.mu.Lock()
+	if elem, ok := p.cache[key]; ok {
+		p.cacheOrder.MoveToFront(elem)
+		p.mu.Unlock()
+		return elem.Value.(*cacheEntry).candidates
+	}
+	p.mu.Unlock()
+
+	found := p.matcher.find(foldText(text))
+	candidates := make([]bool, len(indices))
+	for i, index := range indices {
+		ids := p.literals[index]
+		candidates[i] = ids == nil
+		for _, id := range ids {
+			if found[id] {
+				candidates[i] = true
+				break
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.cache[key]; !ok {
+		p.cache[key] = p.cacheOrder.PushFront(&cacheEntry{key: key, candidates: candidates})
+		if p.cacheOrder.Len() > pipelineCacheSize {
+			oldest := p.cacheOrder.Back()
+			p.cacheOrder.Remove(oldest)
+			delete(p.cache, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	return candidates
+}
+
+// foldText lowercases text for finding literals. The long s is the only rune
+// that case-insensitive regexps match with an ASCII letter but that isn't
+// lowercased to one.
+func foldText(text string) string {
+	text = strings.ToLower(text)
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return strings.ReplaceAll(text, "ſ", "s")
+		}
+	}
+	return text
+}
+
+// requiredLiteral returns the longest literal text, lowercased, which must
+// appear in anything the regexp matches, or an empty string if there is none.
+func requiredLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return literalOf(re.Simplify())
+}
+
+// literalOf finds the longest required literal within a parsed regexp.
+func literalOf(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return foldText(string(re.Rune))
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalOf(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return literalOf(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		longest := ""
+		for _, sub := range re.Sub {
+			if literal := literalOf(sub); len(literal) > len(longest) {
+				longest = literal
+			}
+		}
+		return longest
+	}
+	return ""
+}
+
+// acMatcher is an Aho-Corasick automaton, which finds any of a set of
+// patterns within text in a single pass.
+type acMatcher struct {
+	nodes    []acNode
+	patterns int
+}
+
+// acNode is a state in the automaton.
+type acNode struct {
+	next map[byte]int
+	fail int
+	// The patterns which end at this state, including through failure links.
+	out []int
+}
+
+// newACMatcher builds an automaton for the given patterns.
+func newACMatcher(patterns []string) *acMatcher {
+	m := &acMatcher{
+		nodes:    []acNode{{next: map[byte]int{}}},
+		patterns: len(patterns),
+	}
+	for id, pattern := range patterns {
+		state := 0
+		for i := 0; i < len(pattern); i++ {
+			next, ok := m.nodes[state].next[pattern[i]]
+			if !ok {
+				next = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: map[byte]int{}})
+				m.nodes[state].next[pattern[i]] = next
+			}
+			state = next
+		}
+		m.nodes[state].out = append(m.nodes[state].out, id)
+	}
+
+	// Link each state to the longest proper suffix also in the automaton,
+	// breadth first so that shorter suffixes are linked first.
+	queue := []int{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for b, child := range m.nodes[state].next {
+			queue = append(queue, child)
+			fail := m.nodes[state].fail
+			for {
+				if next, ok := m.nodes[fail].next[b]; ok && next != child {
+					m.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					m.nodes[child].fail = 0
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[m.nodes[child].fail].out...)
+		}
+	}
+	return m
+}
+
+// find returns which of the patterns appear in the text.
+func (m *acMatcher) find(text string) []bool {
+	found := make([]bool, m.patterns)
+	state := 0
+	for i := 0; i < len(text); i++ {
+		for {
+			if next, ok := m.nodes[state].next[text[i]]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = m.nodes[state].fail
+		}
+		for _, id := range m.nodes[state].out {
+			found[id] = true
+		}
+	}
+	return found
+}
@@ -0,0 +1,148 @@
+package config_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/styled"
+)
+
+// candidates returns the names of the triggers the pipeline would run for the
+// world and text, marking those which it thinks can't match with a "-".
+func candidates(c *config.Config, world, text string) []string {
+	var names []string
+	it := c.Pipeline().Iter(world, text)
+	for t, candidate := it.Next(); t != nil; t, candidate = it.Next() {
+		if candidate {
+			names = append(names, t.Name)
+		} else {
+			names = append(names, "-"+t.Name)
+		}
+	}
+	return names
+}
+
+func TestPipeline(t *testing.T) {
+	Convey("When running triggers through a pipeline", t, func() {
+		c := stubConfig()
+		c.Triggers = []config.Trigger{
+			config.Trigger{Name: "doctor", Type: "hilite", Match: "(the )?Doctor", Attributes: "bold"},
+			config.Trigger{Name: "rose", Type: "hilite", Match: "(?i)rose tyler", Attributes: "bold"},
+			config.Trigger{Name: "donna", Type: "gag", Match: "Donna", World: "genesis"},
+			config.Trigger{Name: "anything", Type: "gag", Match: "^.*$"},
+			config.Trigger{Name: "enemies", Type: "gag", Match: "Dalek|Cyberman"},
+			config.Trigger{Name: "jack", Type: "replace", Match: "Jack", Replace: "Captain Jack"},
+			config.Trigger{Name: "captain", Type: "hilite", Match: "Captain", Attributes: "bold"},
+		}
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+
+		Convey("Triggers without the literal text they need are skipped", func() {
+			So(candidates(c, "world", "Hello, Doctor."), ShouldResemble, []string{
+				"doctor", "-rose", "anything", "enemies", "-jack", "-captain",
+			})
+		})
+
+		Convey("Case-insensitive matches are found in any case", func() {
+			So(candidates(c, "world", "ROSE TYLER waves.")[1], ShouldEqual, "rose")
+		})
+
+		Convey("Triggers for other worlds aren't run at all", func() {
+			So(candidates(c, "genesis", "Donna waves.")[2], ShouldEqual, "donna")
+			So(candidates(c, "world", "Donna waves."), ShouldNotContain, "-donna")
+		})
+
+		Convey("Triggers are checked again when the text changes", func() {
+			it := c.Pipeline().Iter("world", "Jack waves.")
+			var names []string
+			for t, candidate := it.Next(); t != nil; t, candidate = it.Next() {
+				if candidate {
+					names = append(names, t.Name)
+				}
+				if t.Name == "jack" {
+					it.Retext("Captain Jack waves.")
+				}
+			}
+			So(names, ShouldResemble, []string{"anything", "enemies", "jack", "captain"})
+		})
+
+		Convey("The pipeline is rebuilt when triggers change", func() {
+			p := c.Pipeline()
+			So(c.Pipeline(), ShouldEqual, p)
+			So(c.AddTrigger(config.Trigger{Name: "martha", Type: "gag", Match: "Martha"}), ShouldBeNil)
+			So(c.Pipeline(), ShouldNotEqual, p)
+			So(candidates(c, "world", "Martha"), ShouldContain, "martha")
+			So(c.SetTriggerEnabled("martha", false), ShouldBeTrue)
+			So(candidates(c, "world", "Martha"), ShouldNotContain, "martha")
+		})
+	})
+}
+
+// benchmarkConfig returns a config with the given number of name hilites.
+func benchmarkConfig(b *testing.B, n int) *config.Config {
+	c := stubConfig()
+	c.Triggers = nil
+	for i := 0; i < n; i++ {
+		c.Triggers = append(c.Triggers, config.Trigger{
+			Type:       "hilite",
+			Match:      fmt.Sprintf("(?i)character%04d", i),
+			Attributes: "bold+cyan",
+		})
+	}
+	if errs := c.FinalizeAndValidate(); len(errs) != 0 {
+		b.Fatal(errs)
+	}
+	return c
+}
+
+// benchmarkLines are lines like those from a +who listing or channel history,
+// only some of which mention a hilited name.
+var benchmarkLines = []string{
+	"Character0007      2m  Out in the woods, looking for something.",
+	"[Public] Someone says, \"Has anyone seen character0042 around?\"",
+	"Nobody in particular     35m   Idle.",
+	"\x1b[1;32m[Newbie]\x1b[0m Another person asks, \"How do I page people?\"",
+	"You page character0999, \"Hello!\"",
+	"There are 57 players connected.",
+}
+
+// reportLinesPerSecond reports how many lines were processed per second.
+func reportLinesPerSecond(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "lines/s")
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		c := benchmarkConfig(b, n)
+
+		b.Run(fmt.Sprintf("%d triggers", n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				line := styled.Parse(benchmarkLines[i%len(benchmarkLines)])
+				it := c.Pipeline().Iter("world", line.Plain())
+				for t, candidate := it.Next(); t != nil; t, candidate = it.Next() {
+					if candidate {
+						t.RunStyled("world", line, c)
+					}
+				}
+				_ = line.ANSI()
+			}
+			reportLinesPerSecond(b, start)
+		})
+
+		b.Run(fmt.Sprintf("%d triggers without pipeline", n), func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				line := styled.Parse(benchmarkLines[i%len(benchmarkLines)])
+				for _, t := range c.TriggerList() {
+					t.RunStyled("world", line, c)
+				}
+				_ = line.ANSI()
+			}
+			reportLinesPerSecond(b, start)
+		})
+	}
+}
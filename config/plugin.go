@@ -0,0 +1,36 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+// Plugin is a long-running program which Stimmtausch starts and talks to using
+// JSON-RPC over its stdin and stdout.
+type Plugin struct {
+	// The key for the plugin in the configuration file.
+	Name string
+
+	// The path of the program to run and any arguments to pass to it.
+	Command string
+	Args    []string
+
+	// The names of the signals to pass on to the plugin, or "*" for all of
+	// them.
+	Signals []string
+
+	// Whether or not to pass on each line received from the worlds.
+	Lines bool
+}
+
+// WantsSignal returns whether or not the plugin should be sent the signal with
+// the given name.
+func (p Plugin) WantsSignal(name string) bool {
+	for _, s := range p.Signals {
+		if s == "*" || s == name {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,11 @@
 
 package config
 
+import (
+	"fmt"
+	"regexp"
+)
+
 // Server represents information required to connect to a remote server.
 type Server struct {
 	// The key for the server in the configuration file.
@@ -27,7 +32,41 @@ type Server struct {
 	ServerType string `yaml:"type" toml:"type"`
 
 	// The maximum length of a buffer
-	MaxBuffer uint `yaml:"max_buffer"m toml:"max_buffer"`
+	MaxBuffer uint `yaml:"max_buffer" toml:"max_buffer"`
+}
+
+// LoginStep is a single step in a server type's login script. The step waits
+// for a line from the server matching Expect (if set), then sends Send, in
+// which $username and $password are replaced as in the connect string. An
+// empty Send sends an empty line, as when asked to press return.
+type LoginStep struct {
+	// A regexp matching the line to wait for before sending.
+	Expect string `yaml:"expect" toml:"expect"`
+
+	// The line to send.
+	Send string `yaml:"send" toml:"send"`
+
+	// How long, in seconds, to wait for a line matching Expect (default 30).
+	Timeout int `yaml:"timeout" toml:"timeout"`
+
+	// Whether or not to carry on with the rest of the script if nothing
+	// matching Expect arrives before the timeout, as with menus that are only
+	// sometimes shown. Otherwise, the script is abandoned.
+	Optional bool `yaml:"optional" toml:"optional"`
+
+	// The compiled regexp specified in Expect.
+	expectRe *regexp.Regexp
+}
+
+// Waits returns whether or not the step waits for a line before sending.
+func (ls LoginStep) Waits() bool {
+	return ls.expectRe != nil
+}
+
+// Matches returns whether or not the given line is the one the step is
+// waiting for.
+func (ls LoginStep) Matches(line string) bool {
+	return ls.expectRe != nil && ls.expectRe.MatchString(line)
 }
 
 // ServerType represents a type of server (MUCK, MUSH, etc...), which mostly
@@ -36,6 +75,87 @@ type ServerType struct {
 	Name             string
 	ConnectString    string `yaml:"connect_string" toml:"connect_string"`
 	DisconnectString string `yaml:"disconnect_string" toml:"disconnect_string"`
+
+	// A list of steps to run to log in, for servers which need more than a
+	// single connect string. If set, this is used instead of ConnectString.
+	Login []LoginStep `yaml:"login" toml:"login"`
+
+	// A command to send periodically in order to measure lag, and a regexp
+	// matching the line the server sends in response. Lag is only measured if
+	// both are set.
+	PingCommand  string `yaml:"ping_command" toml:"ping_command"`
+	PingResponse string `yaml:"ping_response" toml:"ping_response"`
+
+	// How often, in seconds, to send the ping command (default 60).
+	PingInterval int `yaml:"ping_interval" toml:"ping_interval"`
+
+	// Regexps matching the server's announcements of characters connecting
+	// and disconnecting, for the watch list, using the group named "name" or
+	// else the first group.
+	WatchConnected    []string `yaml:"watch_connected" toml:"watch_connected"`
+	WatchDisconnected []string `yaml:"watch_disconnected" toml:"watch_disconnected"`
+
+	// The compiled regexp specified in PingResponse.
+	pingRe *regexp.Regexp
+
+	// The compiled patterns specified in WatchConnected and WatchDisconnected.
+	connectedPatterns, disconnectedPatterns []namePattern
+}
+
+// The events announced by servers for the watch list.
+const (
+	WatchEventConnected    = "connected"
+	WatchEventDisconnected = "disconnected"
+)
+
+// Pings returns whether or not lag should be measured for servers of this
+// type.
+func (st ServerType) Pings() bool {
+	return st.PingCommand != "" && st.pingRe != nil
+}
+
+// IsPingResponse returns whether or not the given line is the response to the
+// ping command.
+func (st ServerType) IsPingResponse(line string) bool {
+	return st.pingRe != nil && st.pingRe.MatchString(line)
+}
+
+// Announcement returns whether the line announces a character connecting or
+// disconnecting, and if so, which character. The event is blank if it
+// doesn't.
+func (st ServerType) Announcement(line string) (event, name string) {
+	for _, p := range st.connectedPatterns {
+		if name := p.find(line); name != "" {
+			return WatchEventConnected, name
+		}
+	}
+	for _, p := range st.disconnectedPatterns {
+		if name := p.find(line); name != "" {
+			return WatchEventDisconnected, name
+		}
+	}
+	return "", ""
+}
+
+// compileWatchPatterns compiles the patterns for the watch list, returning any
+// errors found in them, which name the server type by the given key.
+func (st *ServerType) compileWatchPatterns(name string) []error {
+	var errs []error
+	compile := func(key string, patterns []string) []namePattern {
+		var compiled []namePattern
+		for i, pattern := range patterns {
+			p, err := compileNamePattern(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("server type %s %s pattern %d %v", name, key, i+1, err))
+				continue
+			}
+			compiled = append(compiled, p)
+		}
+		return compiled
+	}
+	st.connectedPatterns = compile("watch_connected", st.WatchConnected)
+	st.disconnectedPatterns = compile("watch_disconnected", st.WatchDisconnected)
+	return errs
 }
 
 // NewServer returns a new server object for the given values.
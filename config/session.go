@@ -0,0 +1,188 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SessionTriggersFile is the name of the file within the config directory to
+// which triggers added with /trigger are saved.
+const SessionTriggersFile = "session-triggers.st.yaml"
+
+// sessionWrapper holds just the session triggers for saving them.
+type sessionWrapper struct {
+	Stimmtausch struct {
+		SessionTriggers []Trigger `yaml:"session_triggers"`
+	}
+}
+
+// AddSessionTrigger compiles the trigger and adds it as with AddTrigger, also
+// keeping it so that it may be saved with SaveSessionTriggers.
+func (c *Config) AddSessionTrigger(t Trigger) error {
+	if t.Name == "" {
+		return fmt.Errorf("session triggers must have a name")
+	}
+	triggerRef, err := compileTrigger(t, unnamedTrigger)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.Macros[triggerRef.Macro]; triggerRef.Type == "macro" && !ok {
+		return fmt.Errorf("trigger %s refers to unknown macro %s", triggerRef.Name, triggerRef.Macro)
+	}
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	for _, existing := range c.CompiledTriggers {
+		if existing != nil && existing.Name == t.Name {
+			return fmt.Errorf("there is already a trigger named %s", t.Name)
+		}
+	}
+	c.forgetTriggerState(t.Name)
+	triggers := make([]*Trigger, len(c.CompiledTriggers), len(c.CompiledTriggers)+1)
+	copy(triggers, c.CompiledTriggers)
+	triggers = append(triggers, triggerRef)
+	sortTriggers(triggers)
+	c.CompiledTriggers = triggers
+	c.SessionTriggers = append(c.SessionTriggers, t)
+	return nil
+}
+
+// SetTriggerEnabled enables or disables the compiled triggers with the given
+// name, returning whether or not there were any. Session triggers are updated
+// to match so that saving them keeps their state.
+func (c *Config) SetTriggerEnabled(name string, enabled bool) bool {
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	found := false
+	// Replace rather than change triggers, as connections may be running them.
+	triggers := make([]*Trigger, len(c.CompiledTriggers))
+	for i, t := range c.CompiledTriggers {
+		if t != nil && t.Name == name {
+			changed := *t
+			changed.Disabled = !enabled
+			t = &changed
+			found = true
+		}
+		triggers[i] = t
+	}
+	c.CompiledTriggers = triggers
+	for i, t := range c.SessionTriggers {
+		if t.Name == name {
+			c.SessionTriggers[i].Disabled = !enabled
+		}
+	}
+	return found
+}
+
+// PruneTriggers removes any expired triggers from the compiled triggers, along
+// with any session triggers of the same name, returning whether or not there
+// were any. Their names are kept so that they don't come back when the config
+// is reloaded or Stimmtausch is restarted.
+func (c *Config) PruneTriggers() bool {
+	if !c.pruneTriggers() {
+		return false
+	}
+	c.SaveTriggerState()
+	return true
+}
+
+func (c *Config) pruneTriggers() bool {
+	now := time.Now()
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	var triggers []*Trigger
+	expired := map[string]bool{}
+	for _, t := range c.CompiledTriggers {
+		if t != nil && t.IsTemporary() && t.Expired(now) {
+			log.Debugf("pruning expired trigger %s", t.Name)
+			expired[t.Name] = true
+			continue
+		}
+		triggers = append(triggers, t)
+	}
+	if len(expired) == 0 {
+		return false
+	}
+	c.CompiledTriggers = triggers
+	var sessionTriggers []Trigger
+	for _, t := range c.SessionTriggers {
+		if !expired[t.Name] {
+			sessionTriggers = append(sessionTriggers, t)
+		}
+	}
+	c.SessionTriggers = sessionTriggers
+	if c.triggerStates == nil {
+		c.triggerStates = map[string]triggerState{}
+	}
+	for name := range expired {
+		if name != "" {
+			c.triggerStates[name] = triggerState{Expired: true}
+		}
+	}
+	return true
+}
+
+// keepTemporaryTriggers carries the state of the old compiled triggers which
+// are temporary and still live over to the new ones on reload, so that
+// reloading doesn't reset how long they last. Those which were added while
+// running rather than from config are kept as they are.
+func keepTemporaryTriggers(old, triggers []*Trigger, configured map[string]bool) []*Trigger {
+	now := time.Now()
+	live := map[string]*Trigger{}
+	for _, t := range old {
+		if t != nil && t.Name != "" && t.IsTemporary() && !t.Expired(now) {
+			live[t.Name] = t
+		}
+	}
+	if len(live) == 0 {
+		return triggers
+	}
+	kept := make([]*Trigger, 0, len(triggers))
+	for _, t := range triggers {
+		if o, ok := live[t.Name]; ok && t.Name != "" && t.IsTemporary() {
+			carried := *t
+			carried.fires = o.fires
+			if !o.expiresAt.IsZero() {
+				carried.expiresAt = o.expiresAt
+			}
+			t = &carried
+		}
+		kept = append(kept, t)
+	}
+	for _, t := range old {
+		if t != nil && live[t.Name] == t && !configured[t.Name] {
+			kept = append(kept, t)
+		}
+	}
+	sortTriggers(kept)
+	return kept
+}
+
+// SaveSessionTriggers writes the session triggers to SessionTriggersFile in
+// the config directory, where they will be loaded from on the next start or
+// reload. It returns the path to which they were saved.
+func (c *Config) SaveSessionTriggers() (string, error) {
+	triggersMu.RLock()
+	var wrap sessionWrapper
+	wrap.Stimmtausch.SessionTriggers = c.SessionTriggers
+	out, err := yaml.Marshal(&wrap)
+	triggersMu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(c.ConfigDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(c.ConfigDir, SessionTriggersFile)
+	log.Debugf("saving session triggers to %s", path)
+	return path, os.WriteFile(path, out, 0644)
+}
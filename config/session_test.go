@@ -0,0 +1,193 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v2"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+func TestSessionTriggers(t *testing.T) {
+	Convey("When adding triggers during a session", t, func() {
+		c := stubConfig()
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+		c.ConfigDir = t.TempDir()
+
+		So(c.AddSessionTrigger(config.Trigger{
+			Name:       "martha",
+			Type:       "hilite",
+			Match:      "Martha",
+			Attributes: "bold",
+		}), ShouldBeNil)
+
+		Convey("They must have a unique name", func() {
+			err := c.AddSessionTrigger(config.Trigger{Type: "gag", Match: "Dalek"})
+			So(err.Error(), ShouldEqual, "session triggers must have a name")
+			err = c.AddSessionTrigger(config.Trigger{Name: "martha", Type: "gag", Match: "Dalek"})
+			So(err.Error(), ShouldEqual, "there is already a trigger named martha")
+		})
+
+		Convey("They must refer to a known macro", func() {
+			err := c.AddSessionTrigger(config.Trigger{Name: "jack", Type: "macro", Match: "Jack", Macro: "nonesuch"})
+			So(err.Error(), ShouldEqual, "trigger jack refers to unknown macro nonesuch")
+		})
+
+		Convey("They can be disabled and enabled again", func() {
+			So(c.SetTriggerEnabled("martha", false), ShouldBeTrue)
+			triggers := c.TriggerList()
+			So(triggers[len(triggers)-1].Disabled, ShouldBeTrue)
+			So(c.SessionTriggers[0].Disabled, ShouldBeTrue)
+			applies, _, _ := triggers[len(triggers)-1].Run("world", "Martha Jones", c)
+			So(applies, ShouldBeFalse)
+			So(c.SetTriggerEnabled("martha", true), ShouldBeTrue)
+			So(c.TriggerList()[len(triggers)-1].Disabled, ShouldBeFalse)
+			So(c.SetTriggerEnabled("nonesuch", true), ShouldBeFalse)
+		})
+
+		Convey("They can be removed", func() {
+			So(c.RemoveTrigger("martha"), ShouldBeTrue)
+			So(c.SessionTriggers, ShouldBeEmpty)
+		})
+
+		Convey("They can be saved and loaded again", func() {
+			path, err := c.SaveSessionTriggers()
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, filepath.Join(c.ConfigDir, config.SessionTriggersFile))
+			contents, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+
+			loaded := stubConfig()
+			wrap := struct{ Stimmtausch *config.Config }{loaded}
+			So(yaml.Unmarshal(contents, &wrap), ShouldBeNil)
+			So(loaded.FinalizeAndValidate(), ShouldBeEmpty)
+			So(len(loaded.Triggers), ShouldEqual, 4)
+			triggers := loaded.TriggerList()
+			So(len(triggers), ShouldEqual, 5)
+			So(triggers[4].Name, ShouldEqual, "martha")
+		})
+	})
+
+	Convey("When triggers are temporary", t, func() {
+		c := stubConfig()
+		So(c.FinalizeAndValidate(), ShouldBeEmpty)
+		So(c.AddSessionTrigger(config.Trigger{
+			Name:     "quiet",
+			Type:     "gag",
+			Match:    ".",
+			MaxFires: 2,
+		}), ShouldBeNil)
+		count := len(c.TriggerList())
+
+		Convey("They are pruned once they expire", func() {
+			So(c.PruneTriggers(), ShouldBeFalse)
+			triggers := c.TriggerList()
+			triggers[count-1].RecordFire()
+			triggers[count-1].RecordFire()
+			So(c.PruneTriggers(), ShouldBeTrue)
+			So(len(c.TriggerList()), ShouldEqual, count-1)
+			So(c.SessionTriggers, ShouldBeEmpty)
+		})
+
+		Convey("They are pruned by the pipeline once their time is up", func() {
+			So(c.AddTrigger(config.Trigger{Name: "rose", Type: "gag", Match: "Rose", ExpiresAfter: "1ms"}), ShouldBeNil)
+			So(candidates(c, "world", "Rose"), ShouldContain, "rose")
+			time.Sleep(2 * time.Millisecond)
+			So(candidates(c, "world", "Rose"), ShouldNotContain, "rose")
+		})
+	})
+
+	Convey("When reloading the config", t, func() {
+		homeDir, configDir, workingDir, logDir := config.HomeDir, config.ConfigDir, config.WorkingDir, config.LogDir
+		defer func() {
+			config.HomeDir, config.ConfigDir, config.WorkingDir, config.LogDir = homeDir, configDir, workingDir, logDir
+		}()
+		config.HomeDir, config.WorkingDir, config.LogDir = t.TempDir(), t.TempDir(), t.TempDir()
+		config.ConfigDir = t.TempDir()
+		So(os.WriteFile(filepath.Join(config.ConfigDir, config.SessionTriggersFile), []byte(`stimmtausch:
+    session_triggers:
+        - name: quiet
+          type: gag
+          match: "."
+          max_fires: 3
+        - name: once
+          type: gag
+          match: "Jack"
+          one_shot: true
+        - name: brief
+          type: gag
+          match: "Donna"
+          expires_after: 1h
+`), 0644), ShouldBeNil)
+		c, err := config.New()
+		So(err, ShouldBeNil)
+		So(c.AddTrigger(config.Trigger{Name: "rose", Type: "gag", Match: "Rose", OneShot: true}), ShouldBeNil)
+		So(c.AddTrigger(config.Trigger{Name: "martha", Type: "gag", Match: "Martha"}), ShouldBeNil)
+		names := func() map[string]*config.Trigger {
+			byName := map[string]*config.Trigger{}
+			for _, t := range c.TriggerList() {
+				byName[t.Name] = t
+			}
+			return byName
+		}
+		names()["quiet"].RecordFire()
+
+		Convey("Temporary triggers survive with their state", func() {
+			So(c.Reload(), ShouldBeNil)
+			byName := names()
+			So(byName["quiet"].Fires(), ShouldEqual, 1)
+			So(byName, ShouldContainKey, "rose")
+			So(byName, ShouldNotContainKey, "martha")
+		})
+
+		Convey("Expired triggers don't come back", func() {
+			names()["once"].RecordFire()
+			So(c.Reload(), ShouldBeNil)
+			So(names(), ShouldNotContainKey, "once")
+			So(c.Reload(), ShouldBeNil)
+			So(names(), ShouldNotContainKey, "once")
+			So(names(), ShouldContainKey, "brief")
+
+			Convey("Unless they're removed", func() {
+				So(c.RemoveTrigger("once"), ShouldBeTrue)
+				So(c.Reload(), ShouldBeNil)
+				So(names(), ShouldContainKey, "once")
+			})
+		})
+
+		Convey("Temporary triggers keep their state across restarts", func() {
+			expiresAt := names()["brief"].ExpiresAt()
+			names()["once"].RecordFire()
+			So(c.PruneTriggers(), ShouldBeTrue)
+			c.SaveTriggerState()
+			_, err := os.Stat(filepath.Join(config.WorkingDir, config.TriggerStateFile))
+			So(err, ShouldBeNil)
+
+			c, err = config.New()
+			So(err, ShouldBeNil)
+			byName := names()
+			So(byName, ShouldNotContainKey, "once")
+			So(byName["quiet"].Fires(), ShouldEqual, 1)
+			So(byName["brief"].ExpiresAt().Equal(expiresAt), ShouldBeTrue)
+			So(c.SessionTriggers, ShouldHaveLength, 2)
+		})
+
+		Convey("Fires are only saved once flushed after they change", func() {
+			path := filepath.Join(config.WorkingDir, config.TriggerStateFile)
+			So(os.Remove(path), ShouldBeNil)
+			c.FlushTriggerState()
+			_, err := os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			c.TriggerStateChanged()
+			c.FlushTriggerState()
+			c, err = config.New()
+			So(err, ShouldBeNil)
+			So(names()["quiet"].Fires(), ShouldEqual, 1)
+		})
+	})
+}
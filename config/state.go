@@ -0,0 +1,156 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TriggerStateFile is the name of the file within the working directory in
+// which the state of temporary triggers is kept, so that restarting doesn't
+// bring back those which have expired or reset how long the rest last.
+const TriggerStateFile = "trigger-state.yaml"
+
+// triggerStateMu keeps the trigger state file from being written by more than
+// one connection at a time.
+var triggerStateMu sync.Mutex
+
+// triggerState is what is kept of a named temporary trigger.
+type triggerState struct {
+	Expired   bool      `yaml:"expired,omitempty"`
+	Fires     uint64    `yaml:"fires,omitempty"`
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+}
+
+// loadTriggerState reads the trigger state from the working directory.
+func (c *Config) loadTriggerState() map[string]triggerState {
+	states := map[string]triggerState{}
+	out, err := os.ReadFile(c.triggerStatePath)
+	if os.IsNotExist(err) {
+		return states
+	}
+	if err == nil {
+		err = yaml.Unmarshal(out, &states)
+	}
+	if err != nil {
+		log.Warningf("unable to load the state of temporary triggers, starting afresh. %v", err)
+		return map[string]triggerState{}
+	}
+	return states
+}
+
+// restoreTriggerState drops the compiled and session triggers which have
+// expired according to the given states, and carries over how many times the
+// rest have fired and when they expire.
+func (c *Config) restoreTriggerState(states map[string]triggerState) {
+	var triggers []*Trigger
+	for _, t := range c.CompiledTriggers {
+		if t == nil || t.Name == "" || !t.IsTemporary() {
+			triggers = append(triggers, t)
+			continue
+		}
+		state, ok := states[t.Name]
+		if !ok {
+			triggers = append(triggers, t)
+			continue
+		}
+		if state.Expired {
+			log.Debugf("dropping expired trigger %s", t.Name)
+			continue
+		}
+		if t.MaxFires > 0 {
+			*t.fires = state.Fires
+		}
+		if !t.expiresAt.IsZero() && !state.ExpiresAt.IsZero() {
+			t.expiresAt = state.ExpiresAt
+		}
+		triggers = append(triggers, t)
+	}
+	c.CompiledTriggers = triggers
+	var sessionTriggers []Trigger
+	for _, t := range c.SessionTriggers {
+		if !states[t.Name].Expired {
+			sessionTriggers = append(sessionTriggers, t)
+		}
+	}
+	c.SessionTriggers = sessionTriggers
+	c.triggerStates = states
+}
+
+// forgetTriggerState forgets the state of the trigger with the given name, so
+// that a new trigger by that name starts afresh. It must be called with
+// triggersMu held.
+func (c *Config) forgetTriggerState(name string) bool {
+	_, ok := c.triggerStates[name]
+	delete(c.triggerStates, name)
+	return ok
+}
+
+// SaveTriggerState records the state of the named temporary triggers and
+// writes it to TriggerStateFile in the working directory, if the config was
+// loaded from there.
+func (c *Config) SaveTriggerState() {
+	triggerStateMu.Lock()
+	defer triggerStateMu.Unlock()
+	triggersMu.Lock()
+	states := map[string]triggerState{}
+	for name, state := range c.triggerStates {
+		if state.Expired {
+			states[name] = state
+		}
+	}
+	for _, t := range c.CompiledTriggers {
+		if t != nil && t.Name != "" && t.IsTemporary() && !states[t.Name].Expired {
+			states[t.Name] = triggerState{Fires: t.Fires(), ExpiresAt: t.expiresAt}
+		}
+	}
+	c.triggerStates = states
+	c.triggerStateChanged = false
+	path := c.triggerStatePath
+	triggersMu.Unlock()
+	if path == "" {
+		return
+	}
+	out, err := yaml.Marshal(states)
+	if err != nil {
+		log.Errorf("unable to save the state of temporary triggers. %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Errorf("unable to save the state of temporary triggers. %v", err)
+		return
+	}
+	log.Tracef("saving the state of temporary triggers to %s", path)
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		log.Errorf("unable to save the state of temporary triggers. %v", err)
+	}
+}
+
+// TriggerStateChanged notes that the state of the temporary triggers has
+// changed, such as when one with max_fires fires, so that it's saved the next
+// time FlushTriggerState is called rather than on every line.
+func (c *Config) TriggerStateChanged() {
+	triggersMu.Lock()
+	defer triggersMu.Unlock()
+	c.triggerStateChanged = true
+}
+
+// FlushTriggerState saves the state of the temporary triggers if it has
+// changed since it was last saved.
+func (c *Config) FlushTriggerState() {
+	triggersMu.RLock()
+	changed := c.triggerStateChanged
+	triggersMu.RUnlock()
+	if changed {
+		c.SaveTriggerState()
+	}
+}
@@ -9,16 +9,25 @@ package config
 import (
 	"fmt"
 	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	ansi "github.com/makyo/ansigo"
+
+	"github.com/makyo/stimmtausch/styled"
 )
 
 type Trigger struct {
 	// The name of the trigger.
 	Name string
 
-	// The type of trigger: hilite, gag, script, macro.
+	// The type of trigger: hilite, gag, replace, route, script, macro, notify,
+	// callback.
 	Type string
 
 	// The world to which this trigger applies (if blank, applies to all).
@@ -30,131 +39,597 @@ type Trigger struct {
 	// A list of regexps to match against.
 	Matches []string
 
+	// How Match, Matches, and End are written: as a "regex" (the default), as
+	// "literal" text, as a "glob" in which * matches any text and ? any one
+	// character, or as a "word" which must not be part of a longer word.
+	MatchMode string `yaml:"match_mode" toml:"match_mode"`
+
+	// Whether or not to ignore case when matching.
+	IgnoreCase bool `yaml:"ignore_case" toml:"ignore_case"`
+
+	// The priority of the trigger. Triggers with a higher priority are run
+	// first; those with the same priority are run in the order defined.
+	Priority int
+
+	// Whether or not to keep running triggers after this one matches. If unset,
+	// this defaults to true.
+	Fallthrough *bool `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+
+	// Whether or not the trigger is disabled, in which case it never matches.
+	Disabled bool
+
+	// How long the trigger lasts before it expires, such as "5m" or "1h".
+	ExpiresAfter string `yaml:"expires_after" toml:"expires_after"`
+
+	// The number of times the trigger may fire before it expires.
+	MaxFires int `yaml:"max_fires" toml:"max_fires"`
+
+	// Whether or not the trigger expires after firing once.
+	OneShot bool `yaml:"one_shot" toml:"one_shot"`
+
+	// For triggers acting on blocks of several lines starting with the one
+	// which matches, a regexp matching the line which ends the block.
+	End string
+
+	// For blocks, the most lines a block may have, including the first and
+	// last. If End is set and this isn't, it defaults to 50.
+	MaxLines int `yaml:"max_lines" toml:"max_lines"`
+
 	// A list of attributes used in hilite (color, style, etc).
 	Attributes string
 
+	// For hilites, attributes to apply to specific capture groups, keyed by the
+	// group's number or name.
+	Groups map[string]string
+
+	// For hilites, whether to apply Attributes to only the matched text
+	// (partial, the default) or the whole line (full-line).
+	HiliteMode string `yaml:"hilite_mode" toml:"hilite_mode"`
+
 	// For gags, whether or not to log the gagged string anyway.
 	LogAnyway bool `yaml:"log_anyway" toml:"log_anyway"`
 
+	// For replacements, what to replace each match with. Capture groups may be
+	// used as $1, $2, or ${name}.
+	Replace string
+
+	// For replacements, whether or not to change what's logged as well as what's
+	// shown.
+	ApplyToLogs bool `yaml:"apply_to_logs" toml:"apply_to_logs"`
+
+	// For routes, the name of the view to send matching lines to instead of
+	// the world's own.
+	Route string
+
+	// For routes, whether or not to keep showing lines in the world's own view
+	// as well.
+	Copy bool
+
 	// The path of a script to run.
 	Script string
 
 	// Whether or not to send the output of the script or macro to the world.
 	// If false, the user will be shown the output
-	OutputToWorld string `yaml:"output_to_world" toml:"output_to_world"`
+	OutputToWorld bool `yaml:"output_to_world" toml:"output_to_world"`
 
 	// The name of a macro to run.
 	Macro string
 
+	// For triggers on out-of-band data rather than lines, the protocol the data
+	// comes from: "gmcp", "msdp", or "mcp". Match and Matches, if given, are
+	// matched against the text of the value found at Path.
+	Source string
+
+	// For triggers on out-of-band data, the package or message followed by the
+	// path to a field within it, such as "Char.Vitals.hp" or
+	// "dns-com-awns-status.text".
+	Path string
+
+	// For triggers on out-of-band data, fire when the value drops below or
+	// rises above a number. They don't fire again until it has gone back.
+	Below *float64 `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+	Above *float64 `yaml:",omitempty" toml:",omitempty" json:",omitempty"`
+
+	// For callbacks, which are only added by the scripting API, the function
+	// to call with the world, the line, and each match.
+	Callback func(world, line string, matches [][]string) `yaml:"-" toml:"-" json:"-"`
+
 	// The compiled regexp specified in Match.
 	reList []*regexp.Regexp
+
+	// The style specified in Attributes.
+	style styled.Style
+
+	// The compiled regexp specified in End.
+	endRe *regexp.Regexp
+
+	// The styles specified in Groups for each compiled regexp.
+	groupStyles [][]groupStyle
+
+	// The number of times the trigger has fired, shared between copies made
+	// when enabling or disabling it.
+	fires *uint64
+
+	// When the trigger expires, if it expires after some time.
+	expiresAt time.Time
+
+	// How the trigger is referred to: its name, or its place among the
+	// configured triggers if it has none.
+	label string
+}
+
+// groupStyle is the style to apply to a numbered capture group.
+type groupStyle struct {
+	index int
+	style styled.Style
+}
+
+// attributeStyle returns the style for an attribute string, warning about any
+// attributes it doesn't understand.
+func attributeStyle(name, attributes string) styled.Style {
+	if attributes == "" {
+		return styled.Style{}
+	}
+	opening, err := ansi.Apply(attributes, "\x00")
+	if err != nil {
+		log.Warningf("error in attributes for trigger %s: %v (continuing anyway)", name, err)
+	}
+	return styled.ParseStyle(strings.SplitN(opening, "\x00", 2)[0])
 }
 
-// compile compiles the regexp specified in the trigger's Match attribute.
-func compileTrigger(t Trigger) (*Trigger, error) {
+// unnamedTrigger is how a trigger without a name that wasn't configured is
+// referred to.
+const unnamedTrigger = "(unnamed)"
+
+// compile compiles the regexp specified in the trigger's Match attribute. If
+// the trigger has no name, it's referred to by the label instead.
+func compileTrigger(t Trigger, label string) (*Trigger, error) {
+	t.label = t.Name
+	if t.label == "" {
+		t.label = label
+	}
 	switch t.Type {
 	case "hilite":
 	case "gag":
+	case "replace":
+	case "route":
 	case "script":
 	case "macro":
+	case "notify":
+	case "callback":
 		break
 	default:
-		return nil, fmt.Errorf("unknown trigger type %s", t.Type)
-	}
-	if t.Name == "" {
-		t.Name = fmt.Sprintf("%+v", t)
+		return nil, fmt.Errorf("unknown trigger type %s for trigger %s", t.Type, t.label)
 	}
+	t.fires = new(uint64)
 	if t.Match != "" {
 		t.Matches = append(t.Matches, t.Match)
 	}
-	if len(t.Matches) == 0 {
-		return nil, fmt.Errorf("no matches for trigger %s", t.Name)
+	if err := t.checkSource(); err != nil {
+		return nil, err
+	}
+	if len(t.Matches) == 0 && t.Source == "" {
+		return nil, fmt.Errorf("no matches for trigger %s", t.label)
+	}
+	if t.Type == "script" && t.Script == "" {
+		return nil, fmt.Errorf("no script for trigger %s", t.label)
+	}
+	if t.Type == "route" && !routeNameRE.MatchString(t.Route) {
+		return nil, fmt.Errorf("trigger %s has an invalid route %q; routes are named with letters, numbers, - and _", t.label, t.Route)
+	}
+	if t.Type == "macro" && t.Macro == "" {
+		return nil, fmt.Errorf("no macro for trigger %s", t.label)
+	}
+	if t.Type == "callback" && t.Callback == nil {
+		return nil, fmt.Errorf("no callback for trigger %s", t.label)
+	}
+	if t.Type == "hilite" {
+		switch t.HiliteMode {
+		case "", "partial", "full-line":
+		default:
+			return nil, fmt.Errorf("unknown hilite mode %s for trigger %s", t.HiliteMode, t.label)
+		}
+		t.style = attributeStyle(t.label, t.Attributes)
+	}
+	switch t.MatchMode {
+	case "", "regex", "literal", "glob", "word":
+	default:
+		return nil, fmt.Errorf("trigger %s has an unknown match_mode %s; it should be one of regex, literal, glob, or word", t.label, t.MatchMode)
 	}
 	for _, match := range t.Matches {
-		re, err := regexp.Compile(match)
+		re, err := t.compileMatch(match)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("trigger %s has an invalid match %s", t.label, err)
 		}
 		t.reList = append(t.reList, re)
 	}
+	if t.ExpiresAfter != "" {
+		d, err := time.ParseDuration(t.ExpiresAfter)
+		if err != nil {
+			return nil, fmt.Errorf("trigger %s has an invalid expires_after: %v", t.label, err)
+		}
+		t.expiresAt = time.Now().Add(d)
+	}
+	if t.MaxFires < 0 {
+		return nil, fmt.Errorf("trigger %s has a negative max_fires", t.label)
+	}
+	if t.OneShot {
+		t.MaxFires = 1
+	}
+	if t.End != "" || t.MaxLines != 0 {
+		if err := t.compileBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if t.Type == "hilite" && len(t.Groups) != 0 {
+		if err := t.compileGroups(); err != nil {
+			return nil, err
+		}
+	}
 	return &t, nil
 }
 
-// Compile returns a pointer to the compiled trigger.
-func (t Trigger) Compile() (*Trigger, error) {
-	return compileTrigger(t)
+// compileBlock checks that the trigger may act on a block and compiles the
+// regexp specified in End.
+func (t *Trigger) compileBlock() error {
+	if t.Type == "replace" {
+		return fmt.Errorf("trigger %s can't replace text in a block", t.label)
+	}
+	if t.MaxLines < 0 {
+		return fmt.Errorf("trigger %s has a negative max_lines", t.label)
+	}
+	if t.End != "" {
+		re, err := t.compileMatch(t.End)
+		if err != nil {
+			return fmt.Errorf("trigger %s has an invalid end %s", t.label, err)
+		}
+		t.endRe = re
+		if t.MaxLines == 0 {
+			t.MaxLines = defaultBlockMaxLines
+		}
+	}
+	return nil
 }
 
-// Run takes the provided byte-slice from the world and, if it matches, runs
-// the action specified in the trigger based on the type (hilite, gag, script
-// macro) if the world matches the one specified in the trigger (if none is
-// specified, it matches all worlds). It returns the (potentially modified)
-// input, whether or not the trigger matched, and any errors it encountered
-// along the way.
-func (t *Trigger) Run(world, input string, cfg *Config) (bool, string, []error) {
-	log.Tracef("running trigger %s", t.Name)
-	applies := false
-	if t.World != "" && t.World != world {
-		return false, input, []error{}
+// compileMatch compiles a match as specified by the trigger's match mode and
+// whether it ignores case.
+func (t *Trigger) compileMatch(match string) (*regexp.Regexp, error) {
+	var expr string
+	switch t.MatchMode {
+	case "literal":
+		expr = regexp.QuoteMeta(match)
+	case "glob":
+		expr = globExpr(match)
+	case "word":
+		expr = wordExpr(match)
+	default:
+		expr = match
 	}
-	var errs []error
-	for _, re := range t.reList {
-		if matches := re.FindAllStringSubmatchIndex(input, -1); len(matches) != 0 {
-			applies = true
-			var err error
-			switch t.Type {
-			case "hilite":
-				input, err = t.hiliteString(input, matches)
-			case "gag":
-				return true, input, []error{}
-			case "script":
-				input, err = t.runScript(input, matches)
-			case "macro":
-				input, err = t.runMacro(input, matches, cfg)
+	if t.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, regexpError(match, err)
+	}
+	return re, nil
+}
+
+// globExpr returns a regexp matching whole lines which match the glob, in
+// which * matches any text and ? any one character, each as a capture group.
+func globExpr(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString("(.*)")
+		case '?':
+			b.WriteString("(.)")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// wordExpr returns a regexp matching the text where it isn't part of a longer
+// word. Word boundaries are only required where the text starts or ends with
+// a letter, number, or underscore, so that names such as "Dr. Who" still match.
+func wordExpr(text string) string {
+	expr := regexp.QuoteMeta(text)
+	if first, _ := utf8.DecodeRuneInString(text); isWordRune(first) {
+		expr = `\b` + expr
+	}
+	if last, _ := utf8.DecodeLastRuneInString(text); isWordRune(last) {
+		expr += `\b`
+	}
+	return expr
+}
+
+// isWordRune returns whether or not the rune is one which \b considers part
+// of a word.
+func isWordRune(r rune) bool {
+	return r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// regexpProblems describes the problems which regexps may have.
+var regexpProblems = map[syntax.ErrorCode]string{
+	syntax.ErrInvalidCharClass:      "an invalid character class",
+	syntax.ErrInvalidCharRange:      "an invalid character range",
+	syntax.ErrInvalidEscape:         "an invalid escape sequence",
+	syntax.ErrInvalidNamedCapture:   "an invalid group name",
+	syntax.ErrInvalidPerlOp:         "an unknown (? group",
+	syntax.ErrInvalidRepeatOp:       "a repetition of a repetition",
+	syntax.ErrInvalidRepeatSize:     "a repetition count which is too large or out of order",
+	syntax.ErrInvalidUTF8:           "invalid UTF-8",
+	syntax.ErrMissingBracket:        "a [ with no closing ]",
+	syntax.ErrMissingParen:          "a ( with no closing )",
+	syntax.ErrMissingRepeatArgument: "a *, +, or ? with nothing before it to repeat",
+	syntax.ErrTrailingBackslash:     "a \\ with nothing after it",
+	syntax.ErrUnexpectedParen:       "a ) with no opening (",
+}
+
+// regexpError describes what's wrong with a regexp which doesn't compile and
+// where, suggesting literal matches for those who didn't mean to write one.
+func regexpError(match string, err error) error {
+	syntaxErr, ok := err.(*syntax.Error)
+	if !ok {
+		return fmt.Errorf("%q: %v", match, err)
+	}
+	problem, ok := regexpProblems[syntaxErr.Code]
+	if !ok {
+		problem = string(syntaxErr.Code)
+	}
+	// The expression given is usually the troublesome part, but for
+	// unmatched parentheses it's the whole regexp, so find the one at fault.
+	i := strings.Index(match, syntaxErr.Expr)
+	if syntaxErr.Code == syntax.ErrMissingParen || syntaxErr.Code == syntax.ErrUnexpectedParen {
+		unclosed, unopened := unmatchedParens(match)
+		i = unclosed
+		if syntaxErr.Code == syntax.ErrUnexpectedParen {
+			i = unopened
+		}
+	}
+	where := ""
+	if i >= 0 && syntaxErr.Expr != "" {
+		where = fmt.Sprintf(" at character %d", utf8.RuneCountInString(match[:i])+1)
+	}
+	return fmt.Errorf("%q: it has %s%s (to match punctuation as it is, escape it with \\ or set match_mode: literal)", match, problem, where)
+}
+
+// unmatchedParens returns the byte offsets of the last opening parenthesis in
+// the regexp which isn't closed and the first closing one which wasn't opened,
+// or -1 for either if there isn't one.
+func unmatchedParens(match string) (unclosed, unopened int) {
+	var open []int
+	unopened = -1
+	inClass := false
+	for i := 0; i < len(match); i++ {
+		switch {
+		case match[i] == '\\':
+			i++
+		case inClass:
+			inClass = match[i] != ']'
+		case match[i] == '[':
+			inClass = true
+			// A ] first in a class is part of it.
+			if i+1 < len(match) && match[i+1] == ']' {
+				i++
 			}
+		case match[i] == '(':
+			open = append(open, i)
+		case match[i] == ')' && len(open) > 0:
+			open = open[:len(open)-1]
+		case match[i] == ')' && unopened < 0:
+			unopened = i
+		}
+	}
+	if len(open) == 0 {
+		return -1, unopened
+	}
+	return open[len(open)-1], unopened
+}
+
+// compileGroups finds the capture group in each regexp for each of the groups
+// to be hilited, which must be in at least one of them.
+func (t *Trigger) compileGroups() error {
+	t.groupStyles = make([][]groupStyle, len(t.reList))
+	for group, attributes := range t.Groups {
+		style := attributeStyle(t.label, attributes)
+		found := false
+		for i, re := range t.reList {
+			index, err := strconv.Atoi(group)
 			if err != nil {
-				errs = append(errs, err)
+				index = re.SubexpIndex(group)
+			}
+			if index < 1 || index > re.NumSubexp() {
+				continue
 			}
+			found = true
+			t.groupStyles[i] = append(t.groupStyles[i], groupStyle{index: index, style: style})
 		}
+		if !found {
+			return fmt.Errorf("trigger %s has no capture group %s", t.label, group)
+		}
+	}
+	// Apply groups in order so that the result doesn't depend on map order.
+	for _, styles := range t.groupStyles {
+		sort.Slice(styles, func(i, j int) bool {
+			return styles[i].index < styles[j].index
+		})
 	}
-	return applies, input, errs
+	return nil
 }
 
-// hiliteString applies ANSI escape-code highlighting to an matches within the
-// provided string. It ignores submatches. If it encounters an error in the
-// process, it returns as much highlighting as it got done and the error
-// generated in the process.
-func (t *Trigger) hiliteString(input string, matches [][]int) (string, error) {
-	log.Tracef("hiliting string")
-	original := input
-	// Work through hilites backwards, since applying them changes the length of the string
-	for i := len(matches) - 1; i >= 0; i-- {
-		match := matches[i]
-		start := match[0]
-		end := match[1]
-		before, target, after := input[:start], input[start:end], input[end:]
-		target, err := ansi.Apply(t.Attributes, target)
-		if err != nil {
-			log.Warningf("error applying hilites: %v (continuing anyway)", err)
+// Compile returns a pointer to the compiled trigger.
+func (t Trigger) Compile() (*Trigger, error) {
+	return compileTrigger(t, unnamedTrigger)
+}
+
+// Label returns how the trigger is referred to: its name, or its place among
+// the configured triggers, such as #2, if it has none.
+func (t *Trigger) Label() string {
+	return t.label
+}
+
+// IsTemporary returns whether or not the trigger expires.
+func (t *Trigger) IsTemporary() bool {
+	return t.MaxFires > 0 || !t.expiresAt.IsZero()
+}
+
+// Expired returns whether or not the trigger has expired, either because it
+// has fired as many times as it may or its time is up.
+func (t *Trigger) Expired(now time.Time) bool {
+	if t.MaxFires > 0 && t.Fires() >= uint64(t.MaxFires) {
+		return true
+	}
+	return !t.expiresAt.IsZero() && !now.Before(t.expiresAt)
+}
+
+// ExpiresAt returns when the trigger expires, or the zero time if it doesn't
+// expire after some time.
+func (t *Trigger) ExpiresAt() time.Time {
+	return t.expiresAt
+}
+
+// IsBlock returns whether or not the trigger acts on a block of lines.
+func (t *Trigger) IsBlock() bool {
+	return t.endRe != nil || t.MaxLines > 0
+}
+
+// EndsBlock returns whether or not a block the trigger started ends with the
+// given line, the nth in the block.
+func (t *Trigger) EndsBlock(text string, n int) bool {
+	return (t.MaxLines > 0 && n >= t.MaxLines) || (t.endRe != nil && t.endRe.MatchString(text))
+}
+
+// HiliteLine applies the trigger's attributes to the whole line, as for each
+// line in a block.
+func (t *Trigger) HiliteLine(line *styled.Line) {
+	line.Apply(0, len(line.Text), t.style)
+}
+
+// FallsThrough returns whether or not further triggers should be run after this
+// one has matched.
+func (t *Trigger) FallsThrough() bool {
+	return t.Fallthrough == nil || *t.Fallthrough
+}
+
+// RecordFire records that the trigger has fired.
+func (t *Trigger) RecordFire() {
+	if t.fires != nil {
+		atomic.AddUint64(t.fires, 1)
+	}
+}
+
+// Fires returns the number of times the trigger has fired.
+func (t *Trigger) Fires() uint64 {
+	if t.fires == nil {
+		return 0
+	}
+	return atomic.LoadUint64(t.fires)
+}
+
+// sortTriggers sorts the triggers by priority, highest first, keeping the
+// order in which they were defined otherwise.
+func sortTriggers(triggers []*Trigger) {
+	priority := func(t *Trigger) int {
+		if t == nil {
+			return 0
 		}
-		input = before + target + strings.Join(ansi.ANSIAtIndex(original, match[0]), "") + after
+		return t.Priority
 	}
-	return input, nil
+	sort.SliceStable(triggers, func(i, j int) bool {
+		return priority(triggers[i]) > priority(triggers[j])
+	})
+}
+
+// Run takes the provided string from the world and, if it matches, runs the
+// action specified in the trigger as RunStyled does. It returns whether or not
+// the trigger matched, the (potentially modified) input rendered with ANSI
+// escape codes, and any errors it encountered along the way.
+func (t *Trigger) Run(world, input string, cfg *Config) (bool, string, []error) {
+	line := styled.Parse(input)
+	applies := t.RunStyled(world, line, cfg)
+	return applies, line.ANSI(), []error{}
 }
 
-// runScript runs a script (or any executable in $PATH) with the input string
-// and matches as input, each properly quoted. The matches will be sent as as
-// is, in JSON format.
-func (t *Trigger) runScript(input string, matches [][]int) (string, error) {
-	log.Tracef("running script")
-	// We could JSON-encode this, oooor...
-	matchesStr := strings.Replace(fmt.Sprintf("%v", matches), " ", ",", -1)
-	matchesStr = matchesStr
-	return input, fmt.Errorf("not implemented")
+// RunStyled runs the action specified in the trigger based on the type (hilite,
+// gag, replace, script, macro) against the line if the trigger matches its text
+// and the world matches the one specified in the trigger (if none is
+// specified, it matches all worlds). Triggers on out-of-band data never match
+// lines. Hilites and replacements modify the line in place. It returns whether
+// or not the trigger matched. Scripts, macros, and callbacks are left to the
+// caller to run, as they need to know about the connection.
+func (t *Trigger) RunStyled(world string, line *styled.Line, cfg *Config) bool {
+	log.Tracef("running trigger %s", t.label)
+	applies := false
+	if t.Disabled || t.Source != "" || (t.World != "" && t.World != world) {
+		return false
+	}
+	if t.IsTemporary() && t.Expired(time.Now()) {
+		return false
+	}
+	if t.IsBlock() {
+		// Blocks are left to the caller, which knows about the lines after this.
+		for _, re := range t.reList {
+			if re.MatchString(line.Text) {
+				return true
+			}
+		}
+		return false
+	}
+	for i, re := range t.reList {
+		if t.Type == "replace" {
+			if line.ReplaceAll(re, t.Replace) {
+				log.Tracef("replacing string")
+				applies = true
+			}
+			continue
+		}
+		matches := re.FindAllStringSubmatchIndex(line.Text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		applies = true
+		switch t.Type {
+		case "hilite":
+			log.Tracef("hiliting string")
+			if t.HiliteMode == "full-line" {
+				line.Apply(0, len(line.Text), t.style)
+			}
+			for _, match := range matches {
+				if t.HiliteMode != "full-line" {
+					line.Apply(match[0], match[1], t.style)
+				}
+				if t.groupStyles == nil {
+					continue
+				}
+				for _, group := range t.groupStyles[i] {
+					if start := match[2*group.index]; start >= 0 {
+						line.Apply(start, match[2*group.index+1], group.style)
+					}
+				}
+			}
+		case "gag":
+			return true
+		}
+	}
+	return applies
 }
 
-// runMacro runs a macro within the client.
-func (t *Trigger) runMacro(input string, matches [][]int, cfg *Config) (string, error) {
-	log.Tracef("running macro")
-	return input, fmt.Errorf("not implemented")
+// Submatches returns every match of the trigger's regexps within the input,
+// each as a list of the full match followed by its capture groups. Triggers on
+// out-of-band data without any regexps match the whole input once.
+func (t *Trigger) Submatches(input string) [][]string {
+	if len(t.reList) == 0 {
+		return [][]string{{input}}
+	}
+	var submatches [][]string
+	for _, re := range t.reList {
+		submatches = append(submatches, re.FindAllStringSubmatch(input, -1)...)
+	}
+	return submatches
 }
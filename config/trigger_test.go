@@ -3,6 +3,7 @@ package config_test
 import (
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -12,12 +13,58 @@ import (
 func TestTriggers(t *testing.T) {
 	Convey("When creating triggers", t, func() {
 
-		Convey("They get a default name", func() {
+		Convey("Those without names are referred to by where they are", func() {
 			c := stubConfig()
 			So(len(c.Triggers[0].Name), ShouldEqual, 0)
 			errs := c.FinalizeAndValidate()
 			So(len(errs), ShouldEqual, 0)
-			So(len(c.CompiledTriggers[0].Name), ShouldNotEqual, 0)
+			So(c.CompiledTriggers[0].Name, ShouldEqual, "")
+			So(c.CompiledTriggers[0].Label(), ShouldEqual, "#0")
+
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Type:     "gag",
+				Match:    "Dalek",
+				MaxFires: -1,
+			})
+			errs = c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Error(), ShouldEqual, "trigger #4 has a negative max_fires")
+		})
+
+		Convey("They are sorted by priority, then the order in which they're defined", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:     "first",
+				Type:     "gag",
+				Match:    "Dalek",
+				Priority: 10,
+			}, config.Trigger{
+				Name:     "last",
+				Type:     "gag",
+				Match:    "Cyberman",
+				Priority: -1,
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 0)
+			So(c.CompiledTriggers[0].Name, ShouldEqual, "first")
+			So(c.CompiledTriggers[2].Type, ShouldEqual, "gag")
+			So(c.CompiledTriggers[5].Name, ShouldEqual, "last")
+
+			So(c.AddTrigger(config.Trigger{
+				Name:     "second",
+				Type:     "gag",
+				Match:    "Sontaran",
+				Priority: 10,
+			}), ShouldBeNil)
+			triggers := c.TriggerList()
+			So(triggers[1].Name, ShouldEqual, "second")
+			So(triggers[6].Name, ShouldEqual, "last")
+		})
+
+		Convey("They fall through to later triggers by default", func() {
+			stop := false
+			So((&config.Trigger{}).FallsThrough(), ShouldBeTrue)
+			So((&config.Trigger{Fallthrough: &stop}).FallsThrough(), ShouldBeFalse)
 		})
 
 		Convey("A trigger may only have certain types", func() {
@@ -28,7 +75,7 @@ func TestTriggers(t *testing.T) {
 			})
 			errs := c.FinalizeAndValidate()
 			So(len(errs), ShouldEqual, 1)
-			So(errs[0].Error(), ShouldEqual, "unknown trigger type bad-wolf")
+			So(errs[0].Error(), ShouldEqual, "unknown trigger type bad-wolf for trigger #4")
 		})
 
 		Convey("A trigger with no matches is an error", func() {
@@ -42,6 +89,163 @@ func TestTriggers(t *testing.T) {
 			So(errs[0].Error(), ShouldStartWith, "no matches for trigger")
 		})
 
+		Convey("A script trigger with no script is an error", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:  "donna",
+				Type:  "script",
+				Match: "Donna",
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Error(), ShouldEqual, "no script for trigger donna")
+		})
+
+		Convey("A route trigger must have a sensible route", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:  "pages",
+				Type:  "route",
+				Match: "pages",
+				Route: "pages",
+			}, config.Trigger{
+				Name:  "public",
+				Type:  "route",
+				Match: "^\\[Public\\]",
+			}, config.Trigger{
+				Name:  "ooc",
+				Type:  "route",
+				Match: "OOC",
+				Route: "ooc chat",
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "trigger public has an invalid route \"\"; routes are named with letters, numbers, - and _")
+			So(errs[1].Error(), ShouldEqual, "trigger ooc has an invalid route \"ooc chat\"; routes are named with letters, numbers, - and _")
+		})
+
+		Convey("A callback trigger must have a callback", func() {
+			c := stubConfig()
+			So(c.FinalizeAndValidate(), ShouldBeEmpty)
+			err := c.AddTrigger(config.Trigger{
+				Name:  "martha",
+				Type:  "callback",
+				Match: "Martha",
+			})
+			So(err.Error(), ShouldEqual, "no callback for trigger martha")
+			So(len(c.TriggerList()), ShouldEqual, 4)
+		})
+
+		Convey("A macro trigger must name a known macro", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:  "jackie",
+				Type:  "macro",
+				Match: "Jackie",
+			}, config.Trigger{
+				Name:  "pete",
+				Type:  "macro",
+				Match: "Pete",
+				Macro: "nonesuch",
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "no macro for trigger jackie")
+			So(errs[1].Error(), ShouldEqual, "trigger pete refers to unknown macro nonesuch")
+		})
+
+		Convey("A hilite must use a known mode and capture groups", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:       "martha",
+				Type:       "hilite",
+				Match:      "Martha",
+				HiliteMode: "all-the-things",
+			}, config.Trigger{
+				Name:   "jones",
+				Type:   "hilite",
+				Match:  "(?P<first>\\w+) Jones",
+				Groups: map[string]string{"last": "cyan"},
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "unknown hilite mode all-the-things for trigger martha")
+			So(errs[1].Error(), ShouldEqual, "trigger jones has no capture group last")
+		})
+
+		Convey("A block trigger can't replace and must have a sensible end", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:    "finger",
+				Type:    "replace",
+				Match:   "^\\+finger",
+				End:     "^-+$",
+				Replace: "",
+			}, config.Trigger{
+				Name:     "look",
+				Type:     "gag",
+				Match:    "^Look",
+				MaxLines: -1,
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldEqual, "trigger finger can't replace text in a block")
+			So(errs[1].Error(), ShouldEqual, "trigger look has a negative max_lines")
+		})
+
+		Convey("A block trigger ends at its end or after its max lines", func() {
+			t, err := (config.Trigger{Type: "gag", Match: "^Page from", End: "^--- end"}).Compile()
+			So(err, ShouldBeNil)
+			So(t.IsBlock(), ShouldBeTrue)
+			So(t.MaxLines, ShouldEqual, 50)
+			So(t.EndsBlock("Hello!", 2), ShouldBeFalse)
+			So(t.EndsBlock("--- end of page", 3), ShouldBeTrue)
+			So(t.EndsBlock("Hello!", 50), ShouldBeTrue)
+			t, err = (config.Trigger{Type: "gag", Match: "^Look"}).Compile()
+			So(err, ShouldBeNil)
+			So(t.IsBlock(), ShouldBeFalse)
+		})
+
+		Convey("A temporary trigger must have a sensible lifetime", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:         "rose",
+				Type:         "gag",
+				Match:        "Rose",
+				ExpiresAfter: "a while",
+			}, config.Trigger{
+				Name:     "donna",
+				Type:     "gag",
+				Match:    "Donna",
+				MaxFires: -1,
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Error(), ShouldStartWith, "trigger rose has an invalid expires_after")
+			So(errs[1].Error(), ShouldEqual, "trigger donna has a negative max_fires")
+		})
+
+		Convey("A temporary trigger expires after some time or number of fires", func() {
+			t, err := (config.Trigger{Type: "gag", Match: "Rose", ExpiresAfter: "1h"}).Compile()
+			So(err, ShouldBeNil)
+			So(t.IsTemporary(), ShouldBeTrue)
+			So(t.Expired(time.Now()), ShouldBeFalse)
+			So(t.Expired(time.Now().Add(time.Hour)), ShouldBeTrue)
+
+			t, err = (config.Trigger{Type: "gag", Match: "Rose", OneShot: true}).Compile()
+			So(err, ShouldBeNil)
+			So(t.MaxFires, ShouldEqual, 1)
+			So(t.Expired(time.Now()), ShouldBeFalse)
+			t.RecordFire()
+			So(t.Expired(time.Now()), ShouldBeTrue)
+			applies, _, _ := t.Run("world", "Rose", nil)
+			So(applies, ShouldBeFalse)
+
+			t, err = (config.Trigger{Type: "gag", Match: "Rose"}).Compile()
+			So(err, ShouldBeNil)
+			So(t.IsTemporary(), ShouldBeFalse)
+		})
+
 		Convey("A trigger with an invalid regexp is an error", func() {
 			c := stubConfig()
 			c.Triggers = append(c.Triggers, config.Trigger{
@@ -50,7 +254,68 @@ func TestTriggers(t *testing.T) {
 			})
 			errs := c.FinalizeAndValidate()
 			So(len(errs), ShouldEqual, 1)
-			So(errs[0].Error(), ShouldStartWith, "error parsing regexp")
+			So(errs[0].Error(), ShouldStartWith, "trigger ")
+			So(errs[0].Error(), ShouldEndWith, " has an invalid match \"*asdf(\": it has a *, +, or ? with nothing before it to repeat at character 1 (to match punctuation as it is, escape it with \\ or set match_mode: literal)")
+		})
+
+		Convey("Errors in regexps say what's wrong and where", func() {
+			c := stubConfig()
+			c.Triggers = append(c.Triggers, config.Trigger{
+				Name:  "page",
+				Type:  "gag",
+				Match: "^(\\w+) pages, \"(.*\" to you$",
+			}, config.Trigger{
+				Name:  "finger",
+				Type:  "hilite",
+				Match: "^-+ finger",
+				End:   "^-+ end)$",
+			}, config.Trigger{
+				Name:      "rose",
+				Type:      "gag",
+				Match:     "Rose",
+				MatchMode: "fuzzy",
+			})
+			errs := c.FinalizeAndValidate()
+			So(len(errs), ShouldEqual, 3)
+			So(errs[0].Error(), ShouldEqual, "trigger page has an invalid match \"^(\\\\w+) pages, \\\"(.*\\\" to you$\": it has a ( with no closing ) at character 16 (to match punctuation as it is, escape it with \\ or set match_mode: literal)")
+			So(errs[1].Error(), ShouldEqual, "trigger finger has an invalid end \"^-+ end)$\": it has a ) with no opening ( at character 8 (to match punctuation as it is, escape it with \\ or set match_mode: literal)")
+			So(errs[2].Error(), ShouldEqual, "trigger rose has an unknown match_mode fuzzy; it should be one of regex, literal, glob, or word")
+		})
+
+		Convey("Matches may be literal, globs, or words, and may ignore case", func() {
+			matches := func(t config.Trigger, line string) bool {
+				t.Type = "gag"
+				compiled, err := t.Compile()
+				So(err, ShouldBeNil)
+				applies, _, _ := compiled.Run("world", line, nil)
+				return applies
+			}
+			literal := config.Trigger{Match: "Dr. Who?", MatchMode: "literal"}
+			So(matches(literal, "Is that Dr. Who?"), ShouldBeTrue)
+			So(matches(literal, "Is that Dr! Who"), ShouldBeFalse)
+			So(matches(literal, "Is that dr. who?"), ShouldBeFalse)
+			literal.IgnoreCase = true
+			So(matches(literal, "Is that dr. who?"), ShouldBeTrue)
+
+			glob := config.Trigger{Match: "* pages, \"*\" to you.", MatchMode: "glob"}
+			So(matches(glob, "Rose pages, \"Hi!\" to you."), ShouldBeTrue)
+			So(matches(glob, "Rose pages, \"Hi!\" to you. Twice."), ShouldBeFalse)
+			compiled, err := (config.Trigger{Type: "macro", Macro: "m", Match: "* pages, \"?\" to you.", MatchMode: "glob"}).Compile()
+			So(err, ShouldBeNil)
+			So(compiled.Submatches("Rose pages, \"!\" to you."), ShouldResemble, [][]string{{"Rose pages, \"!\" to you.", "Rose", "!"}})
+
+			word := config.Trigger{Match: "Rose", MatchMode: "word", IgnoreCase: true}
+			So(matches(word, "rose waves."), ShouldBeTrue)
+			So(matches(word, "Roseanne waves."), ShouldBeFalse)
+			So(matches(word, "Tyler, Rose."), ShouldBeTrue)
+			word = config.Trigger{Match: "Dr. Who?", MatchMode: "word"}
+			So(matches(word, "Dr. Who? Yes."), ShouldBeTrue)
+			So(matches(word, "Mr. Dr. Who?"), ShouldBeTrue)
+			So(matches(word, "XDr. Who?"), ShouldBeFalse)
+
+			block := config.Trigger{Type: "hilite", Match: "+finger *", End: "---*", MatchMode: "glob"}
+			_, err = block.Compile()
+			So(err, ShouldBeNil)
 		})
 	})
 
@@ -105,9 +370,9 @@ func TestTriggers(t *testing.T) {
 				So(len(errs), ShouldEqual, 0)
 				So(deAnsi(line), ShouldEqual, "C[35mHello, Rose, how're you?C[39m")
 				_, line, errs = hl2.Run("world", line, c)
-				So(deAnsi(line), ShouldEqual, "C[35mHello, C[36mRoseC[39mC[35m, how're you?C[39m")
+				So(deAnsi(line), ShouldEqual, "C[35mHello, C[36mRoseC[35m, how're you?C[39m")
 				_, line, errs = hl2.Run("world", "\x1b[35mHello\x1b[0m, Rose, how're you?", c)
-				So(deAnsi(line), ShouldEqual, "C[35mHelloC[0m, C[36mRoseC[39m, how're you?")
+				So(deAnsi(line), ShouldEqual, "C[35mHelloC[39m, C[36mRoseC[39m, how're you?")
 			})
 
 			Convey("But won't clash with multiple matches", func() {
@@ -129,18 +394,66 @@ func TestTriggers(t *testing.T) {
 				So(len(errs), ShouldEqual, 0)
 				_, line, errs = hl2.Run("world", line, c)
 				So(len(errs), ShouldEqual, 0)
-				So(deAnsi(line), ShouldEqual, "C[36mYou whisper, \"Hello, C[35mDonnaC[39mC[36m\" to C[35mDonnaC[39mC[36m.C[39m")
+				So(deAnsi(line), ShouldEqual, "C[36mYou whisper, \"Hello, C[35mDonnaC[36m\" to C[35mDonnaC[36m.C[39m")
 
-				// XXX https://github.com/makyo/stimmtausch/issues/62
-				SkipConvey("Even in reverse", func() {
+				Convey("Even in reverse", func() {
 					_, line, errs := hl2.Run("world", "You whisper, \"Hello, Donna\" to Donna.", c)
 					So(len(errs), ShouldEqual, 0)
 					_, line, errs = hl1.Run("world", line, c)
 					So(len(errs), ShouldEqual, 0)
-					So(deAnsi(line), ShouldEqual, "C[36mYou whisper, \"Hello, C[35mDonnaC[39mC[36m\" to C[35mDonnaC[39mC[36m.C[39m")
+					So(deAnsi(line), ShouldEqual, "C[36mYou whisper, \"Hello, C[35mDonnaC[36m\" to C[35mDonnaC[36m.C[39m")
 				})
 			})
 
+			Convey("Even when they overlap", func() {
+				hl1, err := (config.Trigger{
+					Type:       "hilite",
+					Match:      "Rose Tyler",
+					Attributes: "cyan",
+				}).Compile()
+				So(err, ShouldBeNil)
+				hl2, err := (config.Trigger{
+					Type:       "hilite",
+					Match:      "Tyler waves",
+					Attributes: "bold",
+				}).Compile()
+				So(err, ShouldBeNil)
+				_, line, errs := hl1.Run("world", "Rose Tyler waves.", c)
+				So(len(errs), ShouldEqual, 0)
+				_, line, errs = hl2.Run("world", line, c)
+				So(len(errs), ShouldEqual, 0)
+				So(deAnsi(line), ShouldEqual, "C[36mRose C[1mTylerC[39m wavesC[22m.")
+			})
+
+			Convey("Or only their capture groups", func() {
+				hl, err := (config.Trigger{
+					Type:  "hilite",
+					Match: `^\[(?P<channel>\w+)\] (\w+) says`,
+					Groups: map[string]string{
+						"channel": "magenta",
+						"2":       "bold",
+					},
+				}).Compile()
+				So(err, ShouldBeNil)
+				_, line, errs := hl.Run("world", `[Public] Donna says, "Oi!"`, c)
+				So(len(errs), ShouldEqual, 0)
+				So(deAnsi(line), ShouldEqual, `[C[35mPublicC[39m] C[1mDonnaC[22m says, "Oi!"`)
+			})
+
+			Convey("Or the full line", func() {
+				hl, err := (config.Trigger{
+					Type:       "hilite",
+					Match:      `(\w+) pages`,
+					Attributes: "cyan",
+					HiliteMode: "full-line",
+					Groups:     map[string]string{"1": "bold"},
+				}).Compile()
+				So(err, ShouldBeNil)
+				_, line, errs := hl.Run("world", `Martha pages, "Hi!"`, c)
+				So(len(errs), ShouldEqual, 0)
+				So(deAnsi(line), ShouldEqual, `C[36mC[1mMarthaC[22m pages, "Hi!"C[39m`)
+			})
+
 			Convey("They can be specific to a world", func() {
 				hl1, err := (config.Trigger{
 					Type:       "hilite",
@@ -185,23 +498,65 @@ func TestTriggers(t *testing.T) {
 			So(applies, ShouldBeTrue)
 		})
 
+		Convey("They can replace", func() {
+			replace, err := (config.Trigger{
+				Type:    "replace",
+				Match:   `^\[(\w+)\] (\w+) says, "(.*)"$`,
+				Replace: "$1> $2: $3",
+			}).Compile()
+			So(err, ShouldBeNil)
+
+			Convey("Using capture groups", func() {
+				applies, line, errs := replace.Run("world", `[Public] Donna says, "Oi!"`, c)
+				So(applies, ShouldBeTrue)
+				So(errs, ShouldBeEmpty)
+				So(line, ShouldEqual, "Public> Donna: Oi!")
+			})
+
+			Convey("More than once", func() {
+				ooc, err := (config.Trigger{
+					Type:    "replace",
+					Match:   `\(OOC: ([^)]*)\)`,
+					Replace: "<$1>",
+				}).Compile()
+				So(err, ShouldBeNil)
+				_, line, errs := ooc.Run("world", "Rose (OOC: brb) waves (OOC: back)", c)
+				So(errs, ShouldBeEmpty)
+				So(line, ShouldEqual, "Rose <brb> waves <back>")
+			})
+
+			Convey("And restore the ANSI state after the replacement", func() {
+				ooc, err := (config.Trigger{
+					Type:    "replace",
+					Match:   `OOC \S+`,
+					Replace: "ooc",
+				}).Compile()
+				So(err, ShouldBeNil)
+				_, line, errs := ooc.Run("world", "\x1b[35mRose OOC \x1b[36mtag says hello\x1b[0m", c)
+				So(errs, ShouldBeEmpty)
+				So(deAnsi(line), ShouldEqual, "C[35mRose oocC[36m says helloC[39m")
+			})
+		})
+
 		Convey("They can call a macro", func() {
-			_, line, errs := macro.Run("world", "Mickey Smith", c)
+			applies, line, errs := macro.Run("world", "Mickey Smith", c)
+			So(applies, ShouldBeTrue)
 			So(line, ShouldEqual, "Mickey Smith")
-
-			Convey("NOT IMPLEMENTED YET", func() {
-				So(len(errs), ShouldEqual, 1)
-				So(errs[0].Error(), ShouldEqual, "not implemented")
+			So(errs, ShouldBeEmpty)
+			So(macro.Macro, ShouldEqual, "greet")
+			So(macro.Submatches("Mickey Smith"), ShouldResemble, [][]string{
+				[]string{"Mickey Smith", "Mickey"},
 			})
 		})
 
 		Convey("They can call a script", func() {
-			_, line, errs := script.Run("world", "Donna Noble", c)
-			So(line, ShouldEqual, "Donna Noble")
-
-			Convey("NOT IMPLEMENTED YET", func() {
-				So(len(errs), ShouldEqual, 1)
-				So(errs[0].Error(), ShouldEqual, "not implemented")
+			applies, line, errs := script.Run("world", "Donna Noble and Donna Noble", c)
+			So(applies, ShouldBeTrue)
+			So(line, ShouldEqual, "Donna Noble and Donna Noble")
+			So(errs, ShouldBeEmpty)
+			So(script.Submatches("Donna Noble and Donna Noble"), ShouldResemble, [][]string{
+				[]string{"Donna Noble", "Noble"},
+				[]string{"Donna Noble", "Noble"},
 			})
 		})
 	})
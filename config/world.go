@@ -6,6 +6,8 @@
 
 package config
 
+import "fmt"
+
 // World represents the union between a server and a character.
 type World struct {
 	// The key for the world in the configuration file.
@@ -20,8 +22,103 @@ type World struct {
 	// The username and password to connect with.
 	Username, Password string
 
+	// A command to run to get the password, such as "pass show muck/fox", for
+	// those who would rather not keep it in the config file. The first line of
+	// its output is used as the password.
+	PasswordCommand string `yaml:"password_command" toml:"password_command"`
+
 	// Whether or not to maintain a rotated log of each connection to this world.
 	Log bool
+
+	// Whether or not to color characters' names as set up in the client's
+	// name colors.
+	ColorNames bool `yaml:"color_names" toml:"color_names"`
+
+	// Hooks to run at points in the life of a connection to this world, keyed
+	// by the event, such as HookLoggedIn.
+	Hooks map[string][]Hook
+}
+
+// The events for which a world may have hooks.
+const (
+	HookConnected    = "connected"
+	HookDisconnected = "disconnected"
+	HookReconnected  = "reconnected"
+	HookLoggedIn     = "logged_in"
+)
+
+// hookEvents holds the events for which a world may have hooks.
+var hookEvents = map[string]bool{
+	HookConnected:    true,
+	HookDisconnected: true,
+	HookReconnected:  true,
+	HookLoggedIn:     true,
+}
+
+// Hook is something to do when an event happens to a world's connection.
+// Exactly one of Command, Macro, or Script should be set.
+type Hook struct {
+	// A line to send to the world, or a command to run if it starts with a /.
+	Command string
+
+	// The name of a macro to run.
+	Macro string
+
+	// The path of a script/executable to run. It's passed the event as an
+	// argument, and the world and event as JSON on stdin.
+	Script string
+
+	// Whether to send each line the script prints to the world rather than
+	// showing it.
+	OutputToWorld bool `yaml:"output_to_world" toml:"output_to_world"`
+}
+
+// validateHooks checks that the world's hooks are for known events and each do
+// one thing.
+func (w World) validateHooks(macros map[string][]string) []error {
+	var errs []error
+	for event, hooks := range w.Hooks {
+		if !hookEvents[event] {
+			errs = append(errs, fmt.Errorf("world %s has hooks for unknown event %s", w.Name, event))
+			continue
+		}
+		for i, hook := range hooks {
+			set := 0
+			for _, field := range []string{hook.Command, hook.Macro, hook.Script} {
+				if field != "" {
+					set++
+				}
+			}
+			if set != 1 {
+				errs = append(errs, fmt.Errorf("world %s %s hook %d must have exactly one of a command, macro, or script", w.Name, event, i+1))
+			}
+			if _, ok := macros[hook.Macro]; hook.Macro != "" && !ok {
+				errs = append(errs, fmt.Errorf("world %s %s hook %d refers to unknown macro %s", w.Name, event, i+1, hook.Macro))
+			}
+		}
+	}
+	return errs
+}
+
+// redacted returns a copy of the world with the password redacted.
+func (w World) redacted() World {
+	if w.Password != "" {
+		w.Password = redacted
+	}
+	return w
+}
+
+// String fulfills fmt.Stringer, keeping the password out of logs.
+func (w World) String() string {
+	r := w.redacted()
+	return fmt.Sprintf("{Name:%s DisplayName:%s Server:%s Username:%s Password:%s PasswordCommand:%s Log:%t}",
+		r.Name, r.DisplayName, r.Server, r.Username, r.Password, r.PasswordCommand, r.Log)
+}
+
+// GoString fulfills fmt.GoStringer, keeping the password out of logs which use
+// %#v.
+func (w World) GoString() string {
+	return "config.World" + w.String()
 }
 
 // NewWorld returns a new world object for the given values.
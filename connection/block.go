@@ -0,0 +1,107 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"strings"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/styled"
+)
+
+// block holds the lines collected so far for a trigger acting on a block of
+// several lines.
+type block struct {
+	trigger *config.Trigger
+	lines   []string
+
+	// Whether or not the block has been finished. Finished blocks are kept
+	// until the next line, so that the line which ends a block can't start
+	// another for the same trigger.
+	finished bool
+}
+
+// startBlock starts collecting a block for a trigger whose start matched the
+// line.
+func (c *Connection) startBlock(t *config.Trigger, line *styled.Line) (gag bool) {
+	log.Tracef("starting block for trigger %s on %s", t.Label(), c.name)
+	b := &block{trigger: t}
+	c.blocks = append(c.blocks, b)
+	return c.addToBlock(b, line)
+}
+
+// continueBlocks adds the line to each block being collected, finishing any
+// which it ends. It returns whether the line should be gagged, and if so,
+// whether it should be logged anyway, along with the first route trigger whose
+// block the line is part of, if any.
+func (c *Connection) continueBlocks(line *styled.Line) (gag, logAnyway bool, route *config.Trigger) {
+	var blocks []*block
+	for _, b := range c.blocks {
+		if !b.finished {
+			blocks = append(blocks, b)
+		}
+	}
+	c.blocks = blocks
+	for _, b := range blocks {
+		if c.addToBlock(b, line) {
+			gag = true
+			logAnyway = b.trigger.LogAnyway
+		}
+		if b.trigger.Type == "route" && route == nil {
+			route = b.trigger
+		}
+	}
+	return gag, logAnyway, route
+}
+
+// inBlock returns whether or not a block is being collected for the trigger, or
+// has just been finished by the current line.
+func (c *Connection) inBlock(t *config.Trigger) bool {
+	for _, b := range c.blocks {
+		if b.trigger.Label() == t.Label() {
+			return true
+		}
+	}
+	return false
+}
+
+// addToBlock hilites the line or reports that it should be gagged as the
+// block's trigger specifies, finishing the block if the line ends it.
+func (c *Connection) addToBlock(b *block, line *styled.Line) (gag bool) {
+	b.lines = append(b.lines, line.Plain())
+	if b.trigger.Type == "hilite" {
+		b.trigger.HiliteLine(line)
+	}
+	// A block's start doesn't end it, unless it may only be one line long.
+	if (len(b.lines) > 1 || b.trigger.MaxLines == 1) && b.trigger.EndsBlock(line.Plain(), len(b.lines)) {
+		c.finishBlock(b)
+	}
+	return b.trigger.Type == "gag"
+}
+
+// finishBlock stops collecting a block and runs any action on the whole of
+// it, with the lines joined by newlines.
+func (c *Connection) finishBlock(b *block) {
+	log.Tracef("finishing block of %d lines for trigger %s on %s", len(b.lines), b.trigger.Label(), c.name)
+	b.finished = true
+	c.act(b.trigger, strings.Join(b.lines, "\n"))
+}
+
+// act runs the action of a script, macro, notify, or callback trigger which
+// matched some text.
+func (c *Connection) act(t *config.Trigger, text string) {
+	switch t.Type {
+	case "script":
+		c.startScript(t, text)
+	case "macro":
+		go c.runMacro(t, text)
+	case "notify":
+		c.notify(t, text)
+	case "callback":
+		go t.Callback(c.name, text, t.Submatches(text))
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"bufio"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/textproto"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -23,6 +25,7 @@ import (
 
 	"github.com/makyo/stimmtausch/config"
 	"github.com/makyo/stimmtausch/signal"
+	"github.com/makyo/stimmtausch/styled"
 	"github.com/makyo/stimmtausch/util"
 )
 
@@ -41,6 +44,12 @@ const (
 	// The name of the global output file.
 	outFile string = "out"
 
+	// The name of the file containing connection statistics.
+	statsFile string = "stats"
+
+	// How often to write out connection statistics.
+	statsInterval = 5 * time.Second
+
 	// The size of buffer to read from the connection.
 	bufferSize int = 1024
 
@@ -82,8 +91,13 @@ type Connection struct {
 	// The FIFO file used for maintaining the connection.
 	fifo *os.File
 
-	// The array of io.WriteClosers that output from the world is written to.
-	outputs []*output
+	// The array of io.WriteClosers that output from the world is written to,
+	// and a mutex guarding writes to them, as scripts may write to them too.
+	outputs  []*output
+	outputMu sync.Mutex
+
+	// A semaphore limiting the number of scripts running at once.
+	scripts chan bool
 
 	// A channel signalling a disconnect request.
 	disconnect chan bool
@@ -94,8 +108,42 @@ type Connection struct {
 	// A channel to listen for signal events.
 	listener chan signal.Signal
 
-	// Whether or not the server is connected.
-	Connected bool
+	// A channel signalling the stats monitor to stop.
+	stopMonitor chan bool
+
+	// Statistics about data sent and received.
+	stats statsTracker
+
+	// The login script being run, if the server type has one.
+	login *loginScript
+
+	// The blocks of lines being collected for triggers, which are only used
+	// while reading from the world.
+	blocks []*block
+
+	// The routes for which a view has been asked for, so that it's only asked
+	// for once.
+	routesRequested map[string]bool
+
+	// The characters' names found in lines from the world so far, and a
+	// regexp matching any of them, for coloring names.
+	names   map[string]bool
+	namesRe *regexp.Regexp
+
+	// The authentication key sent to the world once it has asked for MCP, and
+	// whether each trigger on out-of-band data matched the last value it saw.
+	mcpKey      string
+	dataMatched map[string]bool
+
+	// The recording to play back in place of connecting to a server, and
+	// the speed at which to do so.
+	replayFile  string
+	replaySpeed float64
+
+	// Whether or not the server is connected, guarded by a mutex as it's set
+	// and checked from several goroutines.
+	connected   bool
+	connectedMu sync.RWMutex
 }
 
 // lookupHostname gets the TCP address for the world's hostname.
@@ -140,7 +188,7 @@ func (c *Connection) makeFIFO() error {
 
 	log.Tracef("making FIFO")
 	if err = syscall.Mkfifo(file, 0644); err != nil {
-		log.Errorf("unable to make FIFO for %s!", c.name, err)
+		log.Errorf("unable to make FIFO for %s! %v", c.name, err)
 		return err
 	}
 	log.Tracef("FIFO created as %s", file)
@@ -175,7 +223,6 @@ func (c *Connection) connect() error {
 		log.Warningf("unable to set keep alive period for %s - you may get booted. %v", c.name, err)
 	}
 	c.connection = conn
-	fmt.Fprintln(c.connection, "\xff\xfdCHARSET unicode")
 	log.Debugf("connected to server for %s", c.name)
 
 	if c.server.SSL {
@@ -190,7 +237,29 @@ func (c *Connection) connect() error {
 		log.Debugf("connected to server over SSL for %s", c.name)
 	}
 
-	c.Connected = true
+	// This has to wait until after the SSL connection is set up, as otherwise
+	// it's sent in the clear and breaks the handshake.
+	fmt.Fprintln(c.connection, "\xff\xfdCHARSET unicode")
+
+	c.setConnected(true)
+	c.stats.connected()
+	return nil
+}
+
+// playback opens the recording to be replayed in place of a connection to a
+// server.
+func (c *Connection) playback() error {
+	log.Tracef("opening recording %s for %s", c.replayFile, c.name)
+	conn, err := newReplayConn(c.replayFile, c.replaySpeed)
+	if err != nil {
+		log.Errorf("unable to open recording %s for %s! %v", c.replayFile, c.name, err)
+		return err
+	}
+	c.connection = conn
+	log.Debugf("replaying %s at %gx for %s", c.replayFile, c.replaySpeed, c.name)
+
+	c.setConnected(true)
+	c.stats.connected()
 	return nil
 }
 
@@ -198,6 +267,11 @@ func (c *Connection) connect() error {
 func (c *Connection) readToConn() {
 	log.Tracef("reading from FIFO to connection %s", c.name)
 	tmpError := fmt.Sprintf("read %v: resource temporarily unavailable", c.fifo.Name())
+	// The reader is kept between reads so that several lines written to the
+	// FIFO at once aren't lost, and any partial line is kept until the rest of
+	// it arrives.
+	reader := bufio.NewReader(c.fifo)
+	var partial string
 	for {
 		select {
 		case <-c.disconnect:
@@ -209,84 +283,234 @@ func (c *Connection) readToConn() {
 			// and 100% cpu usage when idle. Also without this you will get excessive
 			// "read %v: resource temporarily unavailable" errors on some OSes.
 			time.Sleep(fifoReadDelay)
-			scanner := bufio.NewScanner(c.fifo)
-			if !scanner.Scan() {
-				continue
-			}
-			text := scanner.Text()
-			if len(text) == 0 {
-				log.Infof("got an empty string from the buffer, which is weird.")
-				continue
-			}
-			if text[0] == '/' {
-				s := strings.SplitN(text[1:], " ", 2)
-				if len(s) == 1 {
-					s = append(s, "")
+			for {
+				line, err := reader.ReadString('\n')
+				partial += line
+				if err != nil {
+					if err != io.EOF && err.Error() != tmpError {
+						log.Errorf("FIFO broke??¿? connection %s. %v", c.name, err)
+					}
+					break
 				}
-				go c.env.Dispatch(s[0], s[1])
-				continue
-			}
-			if err := scanner.Err(); err != nil && err.Error() != tmpError {
-				log.Errorf("FIFO broke??¿? connection %s. %v", c.name, err)
-				continue
+				c.sendLine(strings.TrimRight(partial, "\r\n"))
+				partial = ""
 			}
-			fmt.Fprintln(c.connection, text)
 		}
 	}
 }
 
+// sendLine sends a single line read from the FIFO to the server, or dispatches
+// it as a command if it starts with a slash. Aliases are applied first.
+func (c *Connection) sendLine(text string) {
+	if len(text) == 0 {
+		log.Infof("got an empty string from the buffer, which is weird.")
+		return
+	}
+	text = c.config.ApplyAliases(c.world.Name, text)
+	if len(text) == 0 {
+		return
+	}
+	if text[0] == '/' {
+		s := strings.SplitN(text[1:], " ", 2)
+		if len(s) == 1 {
+			s = append(s, "")
+		}
+		go c.env.Dispatch(s[0], s[1])
+		return
+	}
+	c.send(text)
+}
+
+// send writes a single line directly to the server.
+func (c *Connection) send(text string) {
+	n, err := fmt.Fprintln(c.connection, text)
+	if err != nil {
+		log.Warningf("unable to write to connection %s. %v", c.name, err)
+		return
+	}
+	c.stats.sent(n)
+}
+
 // readToFile reads from the connection and writes to outfiles.
 func (c *Connection) readToFile() {
 	log.Tracef("reading from connection %s to file", c.name)
-	reader := bufio.NewReader(c.connection)
+	var source io.Reader = c.connection
+	if c.config.Client.Logging.Record && c.replayFile == "" {
+		rec, err := c.startRecording()
+		if err != nil {
+			log.Warningf("unable to record %s, continuing without. %v", c.name, err)
+		} else {
+			defer func() { rec.out.Close() }()
+			source = rec
+		}
+	}
+	var data io.Reader = &telnetReader{
+		r:              &countingReader{r: source, stats: &c.stats},
+		w:              c.connection,
+		accept:         c.acceptOption,
+		enabled:        c.optionEnabled,
+		subnegotiation: c.handleSubnegotiation,
+	}
+	if c.login != nil {
+		data = &promptReader{r: data, prompt: c.login.feedPrompt}
+	}
+	reader := bufio.NewReader(data)
+	c.mcpKey = ""
+	c.dataMatched = map[string]bool{}
 	tp := textproto.NewReader(reader)
+	st := c.config.ServerTypes[c.server.ServerType]
 	for {
 		bareLine, err := tp.ReadLine()
 		line := strings.ToValidUTF8(bareLine, "")
 		if err != nil {
-			if !c.Connected {
+			if !c.IsConnected() {
 				return
 			}
-			log.Warningf("server disconnected with %v", err)
-			disconnectMsg := fmt.Sprintf("\n~Connection lost at %v\n", c.getTimestamp())
+			var disconnectMsg string
+			if c.replayFile != "" {
+				log.Infof("replay of %s finished", c.replayFile)
+				disconnectMsg = fmt.Sprintf("\n~Replay finished at %v\n", c.getTimestamp())
+			} else {
+				log.Warningf("server disconnected with %v", err)
+				disconnectMsg = fmt.Sprintf("\n~Connection lost at %v\n", c.getTimestamp())
+			}
+			c.outputMu.Lock()
 			for _, out := range c.outputs {
 				if _, err := fmt.Fprintln(out.output, disconnectMsg); err != nil {
 					log.Warningf("unable to write to output %s for %s. %v", out.name, c.name, err)
 				}
 			}
+			c.outputMu.Unlock()
 			c.Close()
-			c.Connected = false
 			return
 		}
 		log.Tracef("%d characters read from %s", len(line), c.name)
+		c.stats.receivedLine()
+
+		if c.mcpLine(line) {
+			continue
+		}
+		line = c.unquoteMCP(line)
+
+		display := styled.Parse(line)
+
+		if st.IsPingResponse(display.Plain()) && c.stats.ponged() {
+			log.Tracef("received ping response from %s", c.name)
+			continue
+		}
+
+		if c.login != nil {
+			c.login.feed(display.Plain())
+		}
+
+		if event, name := st.Announcement(display.Plain()); event != "" {
+			c.announce(event, name, display.Plain())
+		}
 
 		log.Tracef("running triggers against line")
-		var errs, triggerErrs []error
-		var applies, gag, logAnyway bool
-		orig := line
-		for _, trigger := range c.config.CompiledTriggers {
-			applies, line, triggerErrs = trigger.Run(c.world.Name, line, c.config)
-			if len(triggerErrs) != 0 {
-				errs = append(errs, triggerErrs...)
+		gag, logAnyway, route := c.continueBlocks(display)
+		var applies bool
+		// Replacements apply only to what is shown unless asked to apply to logs,
+		// so keep track of what is logged separately once the two differ.
+		var logged *styled.Line
+		// The pipeline skips triggers which can't match what's shown, but those
+		// still need to be run against what's logged once the two differ.
+		triggers := c.config.Pipeline().Iter(c.world.Name, display.Plain())
+		for trigger, candidate := triggers.Next(); trigger != nil; trigger, candidate = triggers.Next() {
+			displayOnly := trigger.Type == "replace" && !trigger.ApplyToLogs
+			if logged != nil && !displayOnly {
+				trigger.RunStyled(c.world.Name, logged, c.config)
+			}
+			if !candidate {
+				continue
+			}
+			if trigger.IsBlock() {
+				if c.inBlock(trigger) || !trigger.RunStyled(c.world.Name, display, c.config) {
+					continue
+				}
+				c.recordFire(trigger)
+				if c.startBlock(trigger, display) {
+					gag = true
+					logAnyway = trigger.LogAnyway
+				}
+				if trigger.Type == "route" && route == nil {
+					route = trigger
+				}
+				if !trigger.FallsThrough() {
+					break
+				}
+				continue
+			}
+			if logged == nil && displayOnly {
+				before := display.Clone()
+				if applies = trigger.RunStyled(c.world.Name, display, c.config); applies {
+					logged = before
+				}
+			} else {
+				applies = trigger.RunStyled(c.world.Name, display, c.config)
+			}
+			if applies {
+				c.recordFire(trigger)
+			}
+			if applies && trigger.Type == "replace" {
+				triggers.Retext(display.Plain())
 			}
 			if applies && trigger.Type == "gag" {
 				log.Tracef("gag %+v applies", trigger)
 				gag = true
 				logAnyway = trigger.LogAnyway
 			}
+			if applies && trigger.Type == "route" && route == nil {
+				log.Tracef("route %+v applies", trigger)
+				route = trigger
+			}
+			if applies && trigger.Type == "script" {
+				c.startScript(trigger, display.Plain())
+			}
+			if applies && trigger.Type == "macro" {
+				go c.runMacro(trigger, display.Plain())
+			}
+			if applies && trigger.Type == "notify" {
+				c.notify(trigger, display.Plain())
+			}
+			if applies && trigger.Type == "callback" {
+				go trigger.Callback(c.name, display.Plain(), trigger.Submatches(display.Plain()))
+			}
+			if applies && !trigger.FallsThrough() {
+				log.Tracef("trigger %s stops further triggers", trigger.Label())
+				break
+			}
+		}
+		if !gag {
+			c.colorNames(display)
+		}
+		if logged == nil {
+			logged = display
 		}
 		// Some worlds end a line with a ZWNJ (\u200c) in order to aid in triggers in wrapped text. Remove before printing
-		line = zwnjRe.ReplaceAllString(line, "")
-		if len(errs) != 0 {
-			log.Errorf("errors encountered processing triggers: %q", errs)
+		display.ReplaceAll(zwnjRe, "")
+		logged.ReplaceAll(zwnjRe, "")
+		c.outputMu.Lock()
+		// Lines for a route with nowhere to show them yet are shown with the
+		// world's own until there is.
+		if route != nil && !c.hasRoute(route.Route) {
+			c.requestRoute(route.Route)
+			route = nil
 		}
 		for _, out := range c.outputs {
 			if gag && !(logAnyway && out.global) {
 				continue
 			}
-			toWrite := orig
+			if !out.global && !out.userCreated && !routeShows(route, out) {
+				continue
+			}
+			toRender := display
+			if out.global || out.userCreated {
+				toRender = logged
+			}
+			toWrite := toRender.Plain()
 			if out.supportsANSI {
-				toWrite = line
+				toWrite = toRender.ANSI()
 			}
 			bytesOut, err := fmt.Fprintln(out.output, toWrite)
 			if err != nil {
@@ -294,12 +518,62 @@ func (c *Connection) readToFile() {
 			}
 			log.Tracef("%d bytes written to output %s for %s", bytesOut, out.name, c.name)
 		}
+		c.outputMu.Unlock()
+	}
+}
+
+// recordFire records that the trigger has fired, pruning it if that means it
+// has expired.
+func (c *Connection) recordFire(t *config.Trigger) {
+	t.RecordFire()
+	if t.IsTemporary() && t.Expired(time.Now()) {
+		log.Tracef("trigger %s has expired", t.Label())
+		c.config.PruneTriggers()
+	} else if t.MaxFires > 0 {
+		// Keep count of the fires left across restarts. The count is saved
+		// along with the stats, and when the connection closes.
+		c.config.TriggerStateChanged()
+	}
+}
+
+// routeShows returns whether or not the output shows a line sent to the route,
+// which is nil if the line wasn't routed.
+func routeShows(route *config.Trigger, out *output) bool {
+	if route == nil {
+		return out.route == ""
 	}
+	return out.route == route.Route || (out.route == "" && route.Copy)
+}
+
+// notify lets the client know that a notify trigger matched a line, so that it
+// can tell the user.
+func (c *Connection) notify(t *config.Trigger, line string) {
+	log.Tracef("trigger %s notifying for %s", t.Label(), c.name)
+	go c.env.DirectDispatch(signal.Signal{
+		Name:    "_client:notify",
+		Payload: []string{c.name, line},
+	})
+}
+
+// announce lets the client know that the world announced a character
+// connecting or disconnecting, for the watch list.
+func (c *Connection) announce(event, name, line string) {
+	log.Tracef("%s %s on %s", name, event, c.name)
+	go c.env.DirectDispatch(signal.Signal{
+		Name:    "_client:watch",
+		Payload: []string{c.name, event, name, line},
+	})
 }
 
 // closeConnection closes the world's TCP connection.
 func (c *Connection) closeConnection() {
-	if !c.Connected {
+	// Mark the connection closed first, so that reading from it stops
+	// quietly rather than reporting that the connection was lost.
+	c.connectedMu.Lock()
+	wasConnected := c.connected
+	c.connected = false
+	c.connectedMu.Unlock()
+	if !wasConnected {
 		log.Debugf("%s already closed", c.name)
 		return
 	}
@@ -307,7 +581,6 @@ func (c *Connection) closeConnection() {
 	if err := c.connection.Close(); err != nil {
 		log.Warningf("error closing connection. %v", err)
 	}
-	c.Connected = false
 	log.Debugf("connection closed for %s", c.name)
 }
 
@@ -340,6 +613,7 @@ func (c *Connection) cleanup() {
 	log.Tracef("cleaning up connection's environment on disk for %s", c.name)
 	c.closeFIFO()
 	c.closeOutputs()
+	c.removeStats()
 	c.removeWorkingDir()
 }
 
@@ -361,14 +635,19 @@ func (c *Connection) Write(in []byte) (int, error) {
 
 // Close closes the connection and all open files.
 func (c *Connection) Close() error {
-	if !c.Connected {
+	if !c.IsConnected() {
 		log.Debugf("%s already closed", c.name)
 		return nil
 	}
 	log.Tracef("closing connection %s", c.name)
 	c.disconnect <- true
 	if <-c.disconnected {
+		close(c.stopMonitor)
+		if c.login != nil {
+			c.login.stop()
+		}
 		c.closeConnection()
+		c.config.FlushTriggerState()
 		c.cleanup()
 		c.env.Dispatch("_client:disconnected", c.name)
 
@@ -416,9 +695,16 @@ func (c *Connection) Open() error {
 		c.cleanup()
 		return err
 	}
+	c.outputMu.Lock()
 	c.outputs = append(c.outputs, globalOut)
+	c.outputMu.Unlock()
 
-	if err = c.connect(); err != nil {
+	if c.replayFile != "" {
+		err = c.playback()
+	} else {
+		err = c.connect()
+	}
+	if err != nil {
 		log.Errorf("could not connect to %s! %v", c.name, err)
 		c.cleanup()
 		return err
@@ -430,32 +716,77 @@ func (c *Connection) Open() error {
 	go c.listen()
 	c.env.AddListener("connection", c.listener)
 
+	// The login script needs to be set up before anything is read from the
+	// server so that it doesn't miss the first lines.
+	st, ok := c.config.ServerTypes[c.server.ServerType]
+	login := ok && c.world.Username != ""
+	if login {
+		if c.world.Password, err = c.config.Password(c.world); err != nil {
+			log.Warningf("unable to get the password for %s, not logging in. %v", c.name, err)
+		}
+		login = c.world.Password != ""
+	}
+	if login && len(st.Login) != 0 {
+		c.login = newLoginScript(c, st.Login)
+		c.login.start()
+	}
+
 	c.disconnect = make(chan bool)
 	c.disconnected = make(chan bool)
+	c.stopMonitor = make(chan bool)
+	c.scripts = make(chan bool, c.config.Client.Scripts.MaxConcurrent)
 	go c.readToFile()
 	go c.readToConn()
+	go c.monitor(c.stopMonitor)
+	go c.env.Dispatch("_client:connected", c.name)
 
-	st, ok := c.config.ServerTypes[c.server.ServerType]
-	if ok && c.world.Username != "" && c.world.Password != "" {
+	if login && len(st.Login) == 0 {
 		connectStr := st.ConnectString
 		connectStr = userRe.ReplaceAllString(connectStr, c.world.Username)
 		connectStr = passRe.ReplaceAllString(connectStr, c.world.Password)
-		fmt.Fprintln(c, connectStr)
+		// Sent as it is, so that aliases can't change or leak the password.
+		c.send(connectStr)
+		c.loggedIn()
 	}
 
 	return nil
 }
 
+// loggedIn lets everyone know that the connection has finished logging in.
+func (c *Connection) loggedIn() {
+	log.Infof("logged in to %s", c.name)
+	go c.env.Dispatch("_client:loggedIn", c.name)
+}
+
 // GetConnectionName gets the name of the connection (the connectStr, usually).
 func (c *Connection) GetConnectionName() string {
 	return c.name
 }
 
+// IsConnected returns whether or not the server is connected.
+func (c *Connection) IsConnected() bool {
+	c.connectedMu.RLock()
+	defer c.connectedMu.RUnlock()
+	return c.connected
+}
+
+// setConnected sets whether or not the server is connected.
+func (c *Connection) setConnected(connected bool) {
+	c.connectedMu.Lock()
+	defer c.connectedMu.Unlock()
+	c.connected = connected
+}
+
 // GetDisplayName gets the world's display name.
 func (c *Connection) GetDisplayName() string {
 	return c.world.DisplayName
 }
 
+// GetWorldName gets the name of the world.
+func (c *Connection) GetWorldName() string {
+	return c.world.Name
+}
+
 // GetMaxBuffer returns the max buffer lengh for a server.
 func (c *Connection) GetMaxBuffer() uint {
 	return c.server.MaxBuffer
@@ -467,12 +798,11 @@ func (c *Connection) GetMaxBuffer() uint {
 func NewConnection(name string, w config.World, s config.Server, cfg *config.Config, env *signal.Dispatcher) (*Connection, error) {
 	log.Tracef("creating a new connection %s for world %s", name, w.Name)
 	c := &Connection{
-		name:      name,
-		world:     w,
-		server:    s,
-		config:    cfg,
-		env:       env,
-		Connected: false,
+		name:   name,
+		world:  w,
+		server: s,
+		config: cfg,
+		env:    env,
 	}
 
 	log.Tracef("ensuring connection working directory")
@@ -496,3 +826,37 @@ func NewConnection(name string, w config.World, s config.Server, cfg *config.Con
 
 	return c, nil
 }
+
+// NewReplayConnection creates a new connection which, rather than connecting
+// to a server, plays back the given recording at the given speed (or as fast
+// as possible if the speed is zero). Everything received is still run through
+// triggers and written to outputs as with any other connection.
+func NewReplayConnection(name, path string, speed float64, cfg *config.Config, env *signal.Dispatcher) (*Connection, error) {
+	log.Tracef("creating a new connection %s replaying %s", name, path)
+	c := &Connection{
+		name: name,
+		world: config.World{
+			Name:        name,
+			DisplayName: fmt.Sprintf("Replay: %s", filepath.Base(path)),
+		},
+		server:      config.Server{Name: name},
+		config:      cfg,
+		env:         env,
+		replayFile:  path,
+		replaySpeed: speed,
+	}
+
+	log.Tracef("ensuring connection working directory")
+	if err := util.EnsureDir(c.getConnectionFile("")); err != nil {
+		log.Errorf("unable to ensure connection directory! %v", err)
+		return nil, err
+	}
+
+	log.Tracef("ensuring world log directory")
+	if err := util.EnsureDir(c.getLogFile("")); err != nil {
+		log.Errorf("unable to ensure log directory! %v", err)
+		return nil, err
+	}
+
+	return c, nil
+}
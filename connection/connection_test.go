@@ -0,0 +1,985 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/connection"
+	"github.com/makyo/stimmtausch/fakemu"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// testOutput is an output which keeps everything written to it.
+type testOutput struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (o *testOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(b)
+}
+
+func (o *testOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	return nil
+}
+
+func (o *testOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// waitFor waits for the output to contain the given string.
+func (o *testOutput) waitFor(s string) bool {
+	deadline := time.Now().Add(fakemu.DefaultTimeout)
+	for time.Now().Before(deadline) {
+		if strings.Contains(o.String(), s) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// waitForDisconnect waits for the connection to be closed.
+func waitForDisconnect(conn *connection.Connection) bool {
+	deadline := time.Now().Add(fakemu.DefaultTimeout)
+	for time.Now().Before(deadline) {
+		if !conn.IsConnected() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// testConfig builds a config pointing at the given fake server, with working
+// and log directories in a temporary directory.
+func testConfig(t *testing.T, srv *fakemu.Server, ssl bool) *config.Config {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Version: 1,
+		ServerTypes: map[string]config.ServerType{
+			"tardis": config.ServerType{
+				ConnectString:    "connect $username $password",
+				DisconnectString: "QUIT",
+			},
+		},
+		Servers: map[string]config.Server{
+			"gallifrey": config.Server{
+				Host:       srv.Host(),
+				Port:       srv.Port(),
+				SSL:        ssl,
+				Insecure:   true,
+				ServerType: "tardis",
+			},
+		},
+		Worlds: map[string]config.World{
+			"rose": config.World{
+				DisplayName: "Rose Tyler",
+				Server:      "gallifrey",
+				Username:    "rose",
+				Password:    "badwolf",
+				Log:         true,
+			},
+		},
+		Triggers: []config.Trigger{
+			config.Trigger{
+				Type:       "hilite",
+				Match:      "Doctor",
+				Attributes: "cyan",
+			},
+			config.Trigger{
+				Type:  "gag",
+				Match: "Dalek",
+			},
+		},
+		Client: config.Client{
+			Logging: config.Logging{
+				TimeString: "2006-01-02T150405.000000",
+			},
+		},
+	}
+	errs := cfg.FinalizeAndValidate()
+	So(errs, ShouldBeEmpty)
+	cfg.WorkingDir = filepath.Join(dir, "share")
+	cfg.LogDir = filepath.Join(dir, "log")
+	return cfg
+}
+
+// refinalize finalizes and validates the config again after changing it,
+// keeping the temporary directories.
+func refinalize(cfg *config.Config) {
+	workingDir, logDir := cfg.WorkingDir, cfg.LogDir
+	So(cfg.FinalizeAndValidate(), ShouldBeEmpty)
+	cfg.WorkingDir, cfg.LogDir = workingDir, logDir
+}
+
+// open creates and opens a connection to the world in the config, attaching a
+// test output to it.
+func open(cfg *config.Config) (*connection.Connection, *testOutput) {
+	w := cfg.Worlds["rose"]
+	conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, signal.NewDispatcher())
+	So(err, ShouldBeNil)
+	out := &testOutput{}
+	conn.AddOutput("test", out, true)
+	So(conn.Open(), ShouldBeNil)
+	So(conn.IsConnected(), ShouldBeTrue)
+	return conn, out
+}
+
+func TestConnection(t *testing.T) {
+	Convey("When connecting to a server", t, func() {
+
+		Convey("It logs in and shows what the server sends", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("Welcome to the TARDIS!"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			So(out.waitFor("Welcome to the TARDIS!\n"), ShouldBeTrue)
+			So(conn.Close(), ShouldBeNil)
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+			So(conn.IsConnected(), ShouldBeFalse)
+			So(out.closed, ShouldBeTrue)
+		})
+
+		Convey("It can get the password from a command", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("Welcome to the TARDIS!"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			w := cfg.Worlds["rose"]
+			w.Password = ""
+			w.PasswordCommand = "echo badwolf"
+			cfg.Worlds["rose"] = w
+			conn, out := open(cfg)
+
+			So(out.waitFor("Welcome to the TARDIS!\n"), ShouldBeTrue)
+			conn.Close()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It matches login prompts which aren't followed by a newline", func() {
+			srv, err := fakemu.New(
+				fakemu.SendRaw([]byte("login: ")),
+				fakemu.Expect("^rose$"),
+				fakemu.SendRaw([]byte("\r\nPassword: ")),
+				fakemu.Expect("^badwolf$"),
+				fakemu.Send("", "Welcome to the TARDIS!"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.ServerTypes["tardis"] = config.ServerType{
+				DisconnectString: "QUIT",
+				Login: []config.LoginStep{
+					config.LoginStep{Expect: "^login:", Send: "$username"},
+					config.LoginStep{Expect: "^Password:", Send: "$password"},
+				},
+			}
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Welcome to the TARDIS!\n"), ShouldBeTrue)
+			So(out.String(), ShouldStartWith, "login: \nPassword: \n")
+			So(srv.Received()[1:], ShouldResemble, []string{"rose", "badwolf"})
+			conn.Close()
+		})
+
+		Convey("It runs the server type's login script", func() {
+			srv, err := fakemu.New(
+				fakemu.SendRaw([]byte("By what name do you wish to be known? ")),
+				fakemu.SendIAC(fakemu.GA),
+				fakemu.Expect("^rose$"),
+				fakemu.Send("Password:"),
+				fakemu.Expect("^badwolf$"),
+				fakemu.Send("Welcome to the TARDIS!"),
+				fakemu.Expect("^look$"),
+				fakemu.Send("You are in the console room."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.ServerTypes["tardis"] = config.ServerType{
+				DisconnectString: "QUIT",
+				Login: []config.LoginStep{
+					config.LoginStep{Expect: "^By what name", Send: "$username"},
+					config.LoginStep{Expect: "^Password:", Send: "$password"},
+					config.LoginStep{Expect: "^Press return", Optional: true, Timeout: 1},
+					config.LoginStep{Send: "look"},
+				},
+			}
+			refinalize(cfg)
+			env := signal.NewDispatcher()
+			listener := make(chan signal.Signal)
+			env.AddListener("test", listener)
+			w := cfg.Worlds["rose"]
+			conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+			So(err, ShouldBeNil)
+			out := &testOutput{}
+			conn.AddOutput("test", out, true)
+			So(conn.Open(), ShouldBeNil)
+
+			So(out.waitFor("You are in the console room.\n"), ShouldBeTrue)
+			So(out.String(), ShouldStartWith, "By what name do you wish to be known? \nPassword:\n")
+			loggedIn := false
+			for !loggedIn {
+				select {
+				case s := <-listener:
+					loggedIn = s.Name == "_client:loggedIn"
+				case <-time.After(fakemu.DefaultTimeout):
+					So(loggedIn, ShouldBeTrue)
+				}
+			}
+			So(srv.Received()[1:], ShouldResemble, []string{"rose", "badwolf", "look"})
+			conn.Close()
+		})
+
+		Convey("It can connect over SSL", func() {
+			srv, err := fakemu.NewTLS(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("Welcome to the secure TARDIS!"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, true))
+
+			So(out.waitFor("Welcome to the secure TARDIS!\n"), ShouldBeTrue)
+			So(conn.Close(), ShouldBeNil)
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It strips telnet sequences", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.SendIAC(fakemu.WILL, 1),
+				fakemu.SendRaw([]byte("Hello, ")),
+				fakemu.SendIAC(fakemu.NOP),
+				fakemu.SendSubnegotiation(201, []byte("Core.Hello {}")),
+				fakemu.Send("Rose"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			So(out.waitFor("Hello, Rose\n"), ShouldBeTrue)
+			conn.Close()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It runs triggers on what the server sends", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Exterminate! says the Dalek.", "Hello, Doctor."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			So(out.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldNotContainSubstring, "Exterminate!")
+			conn.Close()
+		})
+
+		Convey("It runs triggers by priority and stops when one doesn't fall through", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Doctor Who and the Daleks", "Hello, Doctor."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			stop := false
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:        "hilite",
+				Match:       "^Doctor Who.*",
+				Attributes:  "magenta",
+				Priority:    1,
+				Fallthrough: &stop,
+			})
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "\x1b[35mDoctor Who and the Daleks\x1b[39m\n")
+			conn.Close()
+		})
+
+		Convey("It runs triggers on blocks of lines", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send(
+					"Page from Martha:",
+					"Where are you?",
+					"--- end page",
+					"+finger Jack",
+					"Captain Jack Harkness",
+					"Torchwood",
+					"Hello, Doctor.",
+				),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:       "hilite",
+				Match:      "^Page from",
+				End:        "^--- end",
+				Attributes: "magenta",
+			}, config.Trigger{
+				Type:     "gag",
+				Match:    "^\\+finger",
+				MaxLines: 3,
+			})
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "\x1b[35mPage from Martha:\x1b[39m\n\x1b[35mWhere are you?\x1b[39m\n\x1b[35m--- end page\x1b[39m\n")
+			So(out.String(), ShouldNotContainSubstring, "Jack")
+			So(out.String(), ShouldNotContainSubstring, "Torchwood")
+			conn.Close()
+		})
+
+		Convey("It doesn't start a block with the line which ends one", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send(
+					"--- Torchwood",
+					"Captain Jack Harkness",
+					"--- end",
+					"Hello, Doctor.",
+				),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:  "gag",
+				Match: "^---",
+				End:   "^---",
+			})
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldNotContainSubstring, "Torchwood")
+			So(out.String(), ShouldNotContainSubstring, "--- end")
+			conn.Close()
+		})
+
+		Convey("It removes triggers once they have fired enough times", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Rose waves.", "Rose smiles.", "Rose grins.", "Hello, Doctor."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Name:     "rose",
+				Type:     "gag",
+				Match:    "^Rose",
+				MaxFires: 2,
+			})
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldNotContainSubstring, "Rose waves.")
+			So(out.String(), ShouldNotContainSubstring, "Rose smiles.")
+			So(out.String(), ShouldContainSubstring, "Rose grins.")
+			for _, t := range cfg.TriggerList() {
+				So(t.Name, ShouldNotEqual, "rose")
+			}
+			conn.Close()
+		})
+
+		Convey("It lets the client know when notify triggers match", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("The Doctor pages, \"Run!\" to you."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:  "notify",
+				Match: "pages, \".*\" to you\\.$",
+			})
+			refinalize(cfg)
+			env := signal.NewDispatcher()
+			listener := make(chan signal.Signal)
+			env.AddListener("test", listener)
+			w := cfg.Worlds["rose"]
+			conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+
+			var notified signal.Signal
+			for notified.Name != "_client:notify" {
+				select {
+				case notified = <-listener:
+				case <-time.After(fakemu.DefaultTimeout):
+					So(notified.Name, ShouldEqual, "_client:notify")
+				}
+			}
+			So(notified.Payload, ShouldResemble, []string{"rose", "The Doctor pages, \"Run!\" to you."})
+			conn.Close()
+		})
+
+		Convey("It lets the client know when characters connect and disconnect", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("Martha has connected."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			st := cfg.ServerTypes["tardis"]
+			st.WatchConnected = []string{"^(\\w+) has connected\\.$"}
+			cfg.ServerTypes["tardis"] = st
+			refinalize(cfg)
+			env := signal.NewDispatcher()
+			listener := make(chan signal.Signal)
+			env.AddListener("test", listener)
+			w := cfg.Worlds["rose"]
+			conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+			So(err, ShouldBeNil)
+			So(conn.Open(), ShouldBeNil)
+
+			var announced signal.Signal
+			for announced.Name != "_client:watch" {
+				select {
+				case announced = <-listener:
+				case <-time.After(fakemu.DefaultTimeout):
+					So(announced.Name, ShouldEqual, "_client:watch")
+				}
+			}
+			So(announced.Payload, ShouldResemble, []string{"rose", config.WatchEventConnected, "Martha", "Martha has connected."})
+			conn.Close()
+		})
+
+		Convey("It runs triggers on out-of-band data", func() {
+			nextNotify := func(listener chan signal.Signal, within time.Duration) []string {
+				for {
+					select {
+					case s := <-listener:
+						if s.Name == "_client:notify" {
+							return s.Payload
+						}
+					case <-time.After(within):
+						return nil
+					}
+				}
+			}
+			twenty := 20.0
+
+			Convey("From GMCP, firing when values cross a threshold", func() {
+				vitals := func(hp string) fakemu.Step {
+					return fakemu.SendSubnegotiation(201, []byte("Char.Vitals {\"hp\": "+hp+", \"maxhp\": 50}"))
+				}
+				srv, err := fakemu.New(
+					fakemu.Expect("^connect rose badwolf$"),
+					fakemu.SendIAC(fakemu.WILL, 201),
+					vitals("25"),
+					vitals("15"),
+					vitals("12"),
+					vitals("30"),
+					vitals("10"),
+					fakemu.WaitForDisconnect(),
+				)
+				So(err, ShouldBeNil)
+				defer srv.Close()
+				cfg := testConfig(t, srv, false)
+				cfg.Triggers = append(cfg.Triggers, config.Trigger{
+					Type:   "notify",
+					Source: "gmcp",
+					Path:   "Char.Vitals.hp",
+					Below:  &twenty,
+				})
+				refinalize(cfg)
+				env := signal.NewDispatcher()
+				listener := make(chan signal.Signal, 16)
+				env.AddListener("test", listener)
+				w := cfg.Worlds["rose"]
+				conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+				So(err, ShouldBeNil)
+				So(conn.Open(), ShouldBeNil)
+
+				// Notifications may arrive in any order.
+				notified := []string{
+					strings.Join(nextNotify(listener, fakemu.DefaultTimeout), " "),
+					strings.Join(nextNotify(listener, fakemu.DefaultTimeout), " "),
+				}
+				So(notified, ShouldContain, "rose 15")
+				So(notified, ShouldContain, "rose 10")
+				So(nextNotify(listener, 200*time.Millisecond), ShouldBeNil)
+				conn.Close()
+			})
+
+			Convey("From MCP, hiding its messages", func() {
+				srv, err := fakemu.New(
+					fakemu.Send("#$#mcp version: 2.1 to: 2.1"),
+					fakemu.Expect("^#\\$#mcp authentication-key: \\w+ version: 2.1 to: 2.1$"),
+					fakemu.Expect("^#\\$#mcp-negotiate-can \\w+ package: dns-com-awns-status min-version: 1.0 max-version: 1.0$"),
+					fakemu.Expect("^#\\$#mcp-negotiate-end \\w+$"),
+					fakemu.Send(
+						"#$#mcp-negotiate-can package: dns-com-awns-status min-version: 1.0 max-version: 1.0",
+						"#$#dns-com-awns-status text: \"Away for \\\"tea\\\"\"",
+						"#$\"#$#that isn't a message",
+						"Hello, Rose",
+					),
+					fakemu.WaitForDisconnect(),
+				)
+				So(err, ShouldBeNil)
+				defer srv.Close()
+				cfg := testConfig(t, srv, false)
+				cfg.Triggers = append(cfg.Triggers, config.Trigger{
+					Type:   "notify",
+					Source: "mcp",
+					Path:   "dns-com-awns-status.text",
+				})
+				refinalize(cfg)
+				env := signal.NewDispatcher()
+				listener := make(chan signal.Signal, 16)
+				env.AddListener("test", listener)
+				w := cfg.Worlds["rose"]
+				conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+				So(err, ShouldBeNil)
+				out := &testOutput{}
+				conn.AddOutput("test", out, true)
+				So(conn.Open(), ShouldBeNil)
+
+				So(nextNotify(listener, fakemu.DefaultTimeout), ShouldResemble, []string{"rose", "Away for \"tea\""})
+				So(out.waitFor("#$#that isn't a message\nHello, Rose\n"), ShouldBeTrue)
+				So(out.String(), ShouldNotContainSubstring, "mcp")
+				conn.Close()
+			})
+		})
+
+		Convey("It sends what is written to it", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Expect("^:waves\\.$"),
+				fakemu.Send("Rose waves."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			_, err = conn.Write([]byte(":waves."))
+			So(err, ShouldBeNil)
+			So(out.waitFor("Rose waves.\n"), ShouldBeTrue)
+
+			stats := conn.Stats()
+			So(stats.LinesOut, ShouldEqual, 2)
+			So(stats.LinesIn, ShouldEqual, 1)
+			So(stats.BytesIn, ShouldEqual, len("Rose waves.\r\n"))
+			conn.Close()
+		})
+
+		Convey("It sends every line written to it at once", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Expect("^:waves\\.$"),
+				fakemu.Expect("^:bows\\.$"),
+				fakemu.Send("Rose waves and bows."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			_, err = conn.Write([]byte(":waves.\n:bows."))
+			So(err, ShouldBeNil)
+			So(out.waitFor("Rose waves and bows.\n"), ShouldBeTrue)
+			So(srv.Received()[1:], ShouldResemble, []string{"connect rose badwolf", ":waves.", ":bows."})
+			conn.Close()
+		})
+
+		Convey("It applies aliases to what is written to it", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Expect("^:waves\\.$"),
+				fakemu.Expect("^page Rose=Hello!$"),
+				fakemu.Send("Rose waves."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Aliases = []config.Alias{
+				config.Alias{Match: "^;", Replace: ":"},
+				config.Alias{Match: "^p (\\w+)=", Replace: "page $1="},
+			}
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			_, err = conn.Write([]byte(";waves."))
+			So(err, ShouldBeNil)
+			_, err = conn.Write([]byte("p Rose=Hello!"))
+			So(err, ShouldBeNil)
+			So(out.waitFor("Rose waves.\n"), ShouldBeTrue)
+			conn.Close()
+		})
+
+		Convey("It doesn't apply aliases to the connect string", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect rose badwolf$"),
+				fakemu.Send("Rose connects."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Aliases = []config.Alias{
+				config.Alias{Match: "^connect (\\w+) (\\w+)", Replace: "page Doctor=$1 $2"},
+			}
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Rose connects.\n"), ShouldBeTrue)
+			So(srv.Received()[1:], ShouldResemble, []string{"connect rose badwolf"})
+			conn.Close()
+		})
+
+		Convey("It runs scripts when triggers match", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Donna Noble waves to Rose Tyler."),
+				fakemu.Expect("^wave Donna$"),
+				fakemu.Expect("^:grins\\.$"),
+				fakemu.Send("Donna Noble hugs Rose Tyler."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			dir := t.TempDir()
+			script := filepath.Join(dir, "script.sh")
+			So(os.WriteFile(script, []byte("#!/bin/sh\n"+
+				"echo \"$# args: $1 / $2 / $3\"\n"+
+				"cat > "+filepath.Join(dir, "stdin")+"\n"+
+				"[ \"$3\" = hugs ] && echo 'The Doctor looks on.'\n"+
+				"[ \"$3\" = waves ] && echo \"wave $2\" && echo ';grins.'\n"+
+				"exit 0\n"), 0755), ShouldBeNil)
+			cfg.Triggers = []config.Trigger{
+				config.Trigger{
+					Type:   "script",
+					Match:  "^(\\w+) Noble (waves)",
+					Script: script,
+
+					OutputToWorld: true,
+				},
+				config.Trigger{
+					Name:   "hugs",
+					Type:   "script",
+					Match:  "^(\\w+) Noble (hugs)",
+					Script: script,
+				},
+			}
+			cfg.Aliases = []config.Alias{
+				config.Alias{Match: "^;", Replace: ":"},
+			}
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("The Doctor looks on.\n"), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "Donna Noble hugs Rose Tyler.\n")
+			So(out.String(), ShouldContainSubstring, "3 args: Donna Noble hugs Rose Tyler. / Donna / hugs\n")
+			So(out.String(), ShouldNotContainSubstring, "Donna Noble waves to Rose Tyler. / Donna")
+			stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+			So(err, ShouldBeNil)
+			So(string(stdin), ShouldEqual, `{"world":"rose","trigger":"hugs","line":"Donna Noble hugs Rose Tyler.","matches":[["Donna Noble hugs","Donna","hugs"]]}`)
+			conn.Close()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It kills scripts which leave something running in the background", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Donna Noble waves to Rose Tyler."),
+				fakemu.Pause(2*time.Second),
+				fakemu.Send("Donna Noble hugs Rose Tyler."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Client.Scripts.Timeout = 1
+			cfg.Client.Scripts.MaxConcurrent = 1
+			script := filepath.Join(t.TempDir(), "script.sh")
+			So(os.WriteFile(script, []byte("#!/bin/sh\n"+
+				"[ \"$3\" = waves ] && sleep 30 &\n"+
+				"[ \"$3\" = hugs ] && echo 'The Doctor looks on.'\n"+
+				"exit 0\n"), 0755), ShouldBeNil)
+			cfg.Triggers = []config.Trigger{
+				config.Trigger{
+					Type:   "script",
+					Match:  "^(\\w+) Noble (\\w+)",
+					Script: script,
+				},
+			}
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("The Doctor looks on.\n"), ShouldBeTrue)
+			conn.Close()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It notices when the server drops the connection", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("The Doctor is leaving."),
+				fakemu.Drop(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			conn, out := open(testConfig(t, srv, false))
+
+			So(out.waitFor("~Connection lost at"), ShouldBeTrue)
+			So(waitForDisconnect(conn), ShouldBeTrue)
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+		})
+
+		Convey("It keeps a log of the world, minus ANSI codes and gags", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Hello, Doctor.", "Dalek!"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Doctor"), ShouldBeTrue)
+			conn.Close()
+			logs, err := filepath.Glob(filepath.Join(cfg.LogDir, "rose", "*.log"))
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			contents, err := os.ReadFile(logs[0])
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldStartWith, "Hello, Doctor.\n")
+			So(string(contents), ShouldNotContainSubstring, "\x1b")
+			So(string(contents), ShouldNotContainSubstring, "Dalek")
+		})
+
+		Convey("It only replaces text in the log when asked to", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("[Public] Donna says, \"Oi!\"", "Rose (OOC: brb)"),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:    "replace",
+				Match:   "^\\[Public\\] ",
+				Replace: "P> ",
+			}, config.Trigger{
+				Type:        "replace",
+				Match:       "\\(OOC: ([^)]*)\\)",
+				Replace:     "<$1>",
+				ApplyToLogs: true,
+			})
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("Rose <brb>\n"), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "P> Donna says, \"Oi!\"\n")
+			conn.Close()
+			logs, err := filepath.Glob(filepath.Join(cfg.LogDir, "rose", "*.log"))
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			contents, err := os.ReadFile(logs[0])
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldStartWith, "[Public] Donna says, \"Oi!\"\nRose <brb>\n")
+		})
+
+		Convey("It routes lines to their own outputs while still logging them", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("The Doctor pages, \"Run!\" to you.", "[Public] Donna says, \"Oi!\"", "Rose waves."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:  "route",
+				Match: "pages, \".*\" to you\\.$",
+				Route: "pages",
+			}, config.Trigger{
+				Type:  "route",
+				Match: "^\\[Public\\] ",
+				Route: "chan-public",
+				Copy:  true,
+			})
+			refinalize(cfg)
+			w := cfg.Worlds["rose"]
+			conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, signal.NewDispatcher())
+			So(err, ShouldBeNil)
+			So(conn.Routes(), ShouldResemble, []string{"pages", "chan-public"})
+			out, pages, public := &testOutput{}, &testOutput{}, &testOutput{}
+			conn.AddOutput("test", out, true)
+			conn.AddRouteOutput("pages", "pages", pages, true)
+			conn.AddRouteOutput("chan-public", "public", public, true)
+			So(conn.Open(), ShouldBeNil)
+
+			So(out.waitFor("Rose waves.\n"), ShouldBeTrue)
+			So(out.String(), ShouldNotContainSubstring, "pages")
+			So(out.String(), ShouldContainSubstring, "[Public] Donna says, \"Oi!\"\n")
+			So(pages.String(), ShouldEqual, "The \x1b[36mDoctor\x1b[39m pages, \"Run!\" to you.\n")
+			So(public.String(), ShouldEqual, "[Public] Donna says, \"Oi!\"\n")
+			conn.Close()
+			logs, err := filepath.Glob(filepath.Join(cfg.LogDir, "rose", "*.log"))
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			contents, err := os.ReadFile(logs[0])
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldStartWith, "The Doctor pages, \"Run!\" to you.\n[Public] Donna says, \"Oi!\"\nRose waves.\n")
+		})
+
+		Convey("It asks for somewhere to show routes with no outputs", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("The Doctor pages, \"Run!\" to you.", "Rose pages, \"Doctor!\" to you."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Triggers = append(cfg.Triggers, config.Trigger{
+				Type:  "route",
+				Match: "pages, \".*\" to you\\.$",
+				Route: "pages",
+			})
+			refinalize(cfg)
+			env := signal.NewDispatcher()
+			listener := make(chan signal.Signal, 16)
+			env.AddListener("test", listener)
+			w := cfg.Worlds["rose"]
+			conn, err := connection.NewConnection("rose", w, cfg.Servers[w.Server], cfg, env)
+			So(err, ShouldBeNil)
+			out := &testOutput{}
+			conn.AddOutput("test", out, true)
+			So(conn.Open(), ShouldBeNil)
+
+			So(out.waitFor("Rose pages, \""), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "pages, \"Run!\" to you.\n")
+			var requests []signal.Signal
+			for done := false; !done; {
+				select {
+				case res := <-listener:
+					if res.Name == "_client:route" {
+						requests = append(requests, res)
+					}
+				case <-time.After(100 * time.Millisecond):
+					done = true
+				}
+			}
+			So(requests, ShouldHaveLength, 1)
+			So(requests[0].Payload, ShouldResemble, []string{"rose", "pages"})
+			conn.Close()
+		})
+
+		Convey("It colors names in worlds which ask for it", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Martha hugs Rose.", "Martha says, \"Hello!\"", "Rose hugs Martha."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Client.NameColors = config.NameColors{
+				Palette:  []string{"magenta"},
+				Patterns: []string{"^(\\w+) says,"},
+			}
+			w := cfg.Worlds["rose"]
+			w.ColorNames = true
+			cfg.Worlds["rose"] = w
+			refinalize(cfg)
+			conn, out := open(cfg)
+
+			So(out.waitFor("hugs \x1b[35mMartha\x1b[39m.\n"), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "Martha hugs Rose.\n")
+			So(out.String(), ShouldContainSubstring, "\x1b[35mMartha\x1b[39m says, \"Hello!\"\n")
+			So(out.String(), ShouldNotContainSubstring, "\x1b[35mRose")
+			conn.Close()
+		})
+
+		Convey("It can record the session and play it back", func() {
+			srv, err := fakemu.New(
+				fakemu.Expect("^connect"),
+				fakemu.Send("Hello, Doctor."),
+				fakemu.WaitForDisconnect(),
+			)
+			So(err, ShouldBeNil)
+			defer srv.Close()
+			cfg := testConfig(t, srv, false)
+			cfg.Client.Logging.Record = true
+			conn, out := open(cfg)
+
+			So(out.waitFor("Doctor"), ShouldBeTrue)
+			conn.Close()
+			recordings, err := filepath.Glob(filepath.Join(cfg.LogDir, "rose", "*.ttyrec"))
+			So(err, ShouldBeNil)
+			So(len(recordings), ShouldEqual, 1)
+
+			replay, err := connection.NewReplayConnection("replay", recordings[0], 0, cfg, signal.NewDispatcher())
+			So(err, ShouldBeNil)
+			replayOut := &testOutput{}
+			replay.AddOutput("test", replayOut, true)
+			So(replay.Open(), ShouldBeNil)
+			So(replayOut.waitFor("Hello, \x1b[36mDoctor\x1b[39m.\n"), ShouldBeTrue)
+			So(replayOut.waitFor("~Replay finished at"), ShouldBeTrue)
+			So(waitForDisconnect(replay), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,55 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// hookInput is the JSON sent to a hook's script on stdin.
+type hookInput struct {
+	World string `json:"world"`
+	Event string `json:"event"`
+}
+
+// RunHooks runs the world's hooks for the given event, such as
+// config.HookLoggedIn, in the order they're listed.
+func (c *Connection) RunHooks(event string) {
+	for i, hook := range c.world.Hooks[event] {
+		what := fmt.Sprintf("%s hook %d", event, i+1)
+		log.Tracef("running %s on %s", what, c.name)
+		switch {
+		case hook.Command != "":
+			// Go through the FIFO while connected, so that commands are sent
+			// after anything already written, such as the connect string.
+			if c.IsConnected() {
+				if _, err := c.Write([]byte(hook.Command)); err != nil {
+					log.Errorf("unable to send %q for %s. %v", hook.Command, what, err)
+				}
+			} else if hook.Command[0] == '/' {
+				c.sendLine(hook.Command)
+			} else {
+				log.Warningf("not sending %q for %s, as %s isn't connected", hook.Command, what, c.name)
+			}
+		case hook.Macro != "":
+			if err := c.env.RunMacro(hook.Macro, c.name, nil); err != nil {
+				log.Errorf("macro %s for %s failed. %v", hook.Macro, what, err)
+			}
+		case hook.Script != "":
+			stdin, err := json.Marshal(hookInput{World: c.world.Name, Event: event})
+			if err != nil {
+				log.Errorf("unable to encode input for script %s. %v", hook.Script, err)
+				continue
+			}
+			hook := hook
+			c.inBackground(hook.Script, what, func() {
+				c.execScript(hook.Script, what, []string{event}, stdin, hook.OutputToWorld)
+			})
+		}
+	}
+}
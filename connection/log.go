@@ -49,12 +49,16 @@ func (c *Connection) openLog(name string) error {
 		log.Warningf("unable to start logging. %v", err)
 		return err
 	}
+	c.outputMu.Lock()
 	c.outputs = append(c.outputs, out)
+	c.outputMu.Unlock()
 	return nil
 }
 
 func (c *Connection) closeLog(name string) {
 	log.Tracef("closing log %s for %s via /log", name, c.name)
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
 	for i, out := range c.outputs {
 		if out.userCreated && out.name == name {
 			log.Infof("log %s closed", name)
@@ -69,11 +73,13 @@ func (c *Connection) closeLog(name string) {
 func (c *Connection) listLogs() {
 	log.Tracef("listing open logs for %s", c.name)
 	logs := []string{}
+	c.outputMu.Lock()
 	for _, out := range c.outputs {
 		if out.userCreated {
 			logs = append(logs, "* "+out.name)
 		}
 	}
+	c.outputMu.Unlock()
 	if len(logs) == 0 {
 		logs = []string{"(none)"}
 	}
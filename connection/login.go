@@ -0,0 +1,164 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/styled"
+)
+
+// loginScript runs a server type's login script against the lines received
+// from the server, sending each step's line once the line it is waiting for
+// arrives.
+type loginScript struct {
+	c     *Connection
+	steps []config.LoginStep
+
+	mu      sync.Mutex
+	current int
+	timer   *time.Timer
+	done    bool
+}
+
+// newLoginScript creates a login script for the connection.
+func newLoginScript(c *Connection, steps []config.LoginStep) *loginScript {
+	return &loginScript{
+		c:     c,
+		steps: steps,
+	}
+}
+
+// start runs any steps which don't need to wait for anything, then starts
+// waiting for the first one that does.
+func (l *loginScript) start() {
+	log.Tracef("starting login script for %s", l.c.name)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance()
+}
+
+// feed checks the given line against the step currently waiting, moving on if
+// it matches.
+func (l *loginScript) feed(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.match(line)
+}
+
+// feedPrompt checks the text received so far of a line which hasn't been
+// finished against the step currently waiting, moving on and returning true if
+// it matches.
+func (l *loginScript) feedPrompt(text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.match(styled.Parse(strings.TrimRight(text, "\r")).Plain())
+}
+
+// match checks the given text against the step currently waiting, moving on
+// and returning true if it matches. It must be called with the lock held.
+func (l *loginScript) match(text string) bool {
+	if l.done || !l.steps[l.current].Matches(text) {
+		return false
+	}
+	log.Tracef("login step %d for %s matched %q", l.current+1, l.c.name, text)
+	l.timer.Stop()
+	l.sendCurrent()
+	l.advance()
+	return true
+}
+
+// stop abandons the script.
+func (l *loginScript) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finish()
+}
+
+// sendCurrent sends the current step's line and moves to the next step. It
+// must be called with the lock held.
+func (l *loginScript) sendCurrent() {
+	step := l.steps[l.current]
+	l.current++
+	text := userRe.ReplaceAllString(step.Send, l.c.world.Username)
+	text = passRe.ReplaceAllString(text, l.c.world.Password)
+	l.c.send(text)
+}
+
+// advance runs steps until one needs to wait for a line, at which point it
+// starts a timer for that step. It must be called with the lock held.
+func (l *loginScript) advance() {
+	for l.current < len(l.steps) && !l.steps[l.current].Waits() {
+		l.sendCurrent()
+	}
+	if l.current >= len(l.steps) {
+		log.Debugf("login script for %s complete", l.c.name)
+		l.finish()
+		l.c.loggedIn()
+		return
+	}
+	step := l.current
+	l.timer = time.AfterFunc(time.Duration(l.steps[step].Timeout)*time.Second, func() {
+		l.timeout(step)
+	})
+}
+
+// timeout is called when a step has waited too long for its line. Optional
+// steps are skipped, otherwise the script is abandoned.
+func (l *loginScript) timeout(step int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done || l.current != step {
+		return
+	}
+	if l.steps[step].Optional {
+		log.Debugf("skipping optional login step %d for %s", step+1, l.c.name)
+		l.current++
+		l.advance()
+		return
+	}
+	log.Warningf("timed out waiting for %q in login step %d for %s; giving up on logging in", l.steps[step].Expect, step+1, l.c.name)
+	l.finish()
+}
+
+// finish marks the script as done. It must be called with the lock held.
+func (l *loginScript) finish() {
+	l.done = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+}
+
+// promptReader wraps an io.Reader, passing the text read since the last newline
+// to prompt after each read, so that prompts which the server doesn't follow
+// with a newline, go ahead, or end of record may still be matched. The text is
+// forgotten once prompt has matched it, so that it isn't matched again.
+type promptReader struct {
+	r       io.Reader
+	prompt  func(text string) bool
+	pending []byte
+}
+
+// Read fulfills io.Reader.
+func (p *promptReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	data := b[:n]
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		p.pending = append(p.pending[:0], data[i+1:]...)
+	} else {
+		p.pending = append(p.pending, data...)
+	}
+	if len(p.pending) > 0 && p.prompt(string(p.pending)) {
+		p.pending = p.pending[:0]
+	}
+	return n, err
+}
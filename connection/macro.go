@@ -0,0 +1,23 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"github.com/makyo/stimmtausch/config"
+)
+
+// runMacro runs the macro for a trigger which matched a line once for each
+// match, with the full match as $0 and each capture group as $1 onwards.
+// Anything the macro sends goes to this world.
+func (c *Connection) runMacro(t *config.Trigger, line string) {
+	for _, match := range t.Submatches(line) {
+		log.Tracef("running macro %s for trigger %s on %s", t.Macro, t.Label(), c.name)
+		if err := c.env.RunMacro(t.Macro, c.name, match); err != nil {
+			log.Errorf("macro %s for trigger %s failed. %v", t.Macro, t.Label(), err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/styled"
+)
+
+// colorNames colors characters' names in the line if the world has name
+// coloring turned on, first looking for any new names it mentions.
+func (c *Connection) colorNames(line *styled.Line) {
+	if !c.world.ColorNames {
+		return
+	}
+	nc := &c.config.Client.NameColors
+	added := false
+	for _, name := range nc.Find(line.Plain()) {
+		if c.names[name] {
+			continue
+		}
+		if c.names == nil {
+			c.names = map[string]bool{}
+		}
+		log.Tracef("found name %s in %s", name, c.name)
+		c.names[name] = true
+		added = true
+	}
+	if added {
+		names := make([]string, 0, len(c.names))
+		for name := range c.names {
+			names = append(names, name)
+		}
+		c.namesRe = config.NamesRegexp(names)
+	}
+	nc.Color(line, c.namesRe)
+}
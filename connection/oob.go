@@ -0,0 +1,334 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+// Telnet options carrying out-of-band data.
+const (
+	telnetMSDP byte = 69
+	telnetGMCP byte = 201
+)
+
+// MSDP's markers within a subnegotiation.
+const (
+	msdpVar        byte = 1
+	msdpVal        byte = 2
+	msdpTableOpen  byte = 3
+	msdpTableClose byte = 4
+	msdpArrayOpen  byte = 5
+	msdpArrayClose byte = 6
+)
+
+// MCP messages start with mcpPrefix, while lines of text which happen to start
+// with it are sent starting with mcpQuote instead.
+const (
+	mcpPrefix = "#$#"
+	mcpQuote  = "#$\""
+)
+
+// optionSource returns the source of out-of-band data carried by a telnet
+// option, or an empty string if it doesn't carry any.
+func optionSource(option byte) string {
+	switch option {
+	case telnetGMCP:
+		return config.SourceGMCP
+	case telnetMSDP:
+		return config.SourceMSDP
+	}
+	return ""
+}
+
+// acceptOption returns whether or not to agree to the server's offer of a
+// telnet option, which is only done for those carrying out-of-band data that
+// triggers for this world are watching.
+func (c *Connection) acceptOption(option byte) bool {
+	source := optionSource(option)
+	return source != "" && len(c.config.DataPackages(c.world.Name, source)) != 0
+}
+
+// optionEnabled asks the world for the packages triggers are watching once it
+// has agreed to send out-of-band data.
+func (c *Connection) optionEnabled(option byte) {
+	packages := c.config.DataPackages(c.world.Name, optionSource(option))
+	log.Tracef("asking %s for %s data for %v", c.name, optionSource(option), packages)
+	var messages [][]byte
+	switch option {
+	case telnetGMCP:
+		supports := make([]string, len(packages))
+		for i, pkg := range packages {
+			supports[i] = pkg + " 1"
+		}
+		list, _ := json.Marshal(supports)
+		messages = append(messages,
+			[]byte(`Core.Hello {"client":"Stimmtausch"}`),
+			append([]byte("Core.Supports.Set "), list...))
+	case telnetMSDP:
+		report := append([]byte{msdpVar}, "REPORT"...)
+		for _, pkg := range packages {
+			report = append(append(report, msdpVal), pkg...)
+		}
+		messages = append(messages, report)
+	}
+	for _, data := range messages {
+		if err := writeSubnegotiation(c.connection, option, data); err != nil {
+			log.Warningf("unable to write to connection %s. %v", c.name, err)
+			return
+		}
+	}
+}
+
+// handleSubnegotiation runs the triggers watching out-of-band data against the
+// data the world sent for a telnet option.
+func (c *Connection) handleSubnegotiation(option byte, data []byte) {
+	switch option {
+	case telnetGMCP:
+		pkg, payload := string(data), []byte{}
+		if i := bytes.IndexByte(data, ' '); i >= 0 {
+			pkg, payload = string(data[:i]), bytes.TrimSpace(data[i+1:])
+		}
+		var value interface{}
+		if len(payload) != 0 {
+			if err := json.Unmarshal(payload, &value); err != nil {
+				log.Warningf("unable to parse GMCP %s from %s. %v", pkg, c.name, err)
+				return
+			}
+		}
+		c.runDataTriggers(config.SourceGMCP, pkg, value)
+	case telnetMSDP:
+		vars := parseMSDP(data)
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.runDataTriggers(config.SourceMSDP, name, vars[name])
+		}
+	}
+}
+
+// mcpLine handles a line if it's an MCP message, returning whether or not it
+// was one, in which case it isn't shown. Messages are only handled once the
+// world has asked for MCP and some trigger for this world is watching it.
+func (c *Connection) mcpLine(line string) bool {
+	if !strings.HasPrefix(line, mcpPrefix) {
+		return false
+	}
+	name, args := parseMCP(line[len(mcpPrefix):])
+	if name == "mcp" {
+		return c.startMCP()
+	}
+	if c.mcpKey == "" {
+		return false
+	}
+	switch {
+	case name == "*" || name == ":":
+		log.Tracef("ignoring multi-line MCP data from %s", c.name)
+	case strings.HasPrefix(name, "mcp-"):
+		// Only the packages triggers are watching are asked for, so there's
+		// nothing to do with what the world can do.
+	default:
+		c.runDataTriggers(config.SourceMCP, name, args)
+	}
+	return true
+}
+
+// unquoteMCP removes the quoting from a line of text which starts like an MCP
+// message, once MCP has started.
+func (c *Connection) unquoteMCP(line string) string {
+	if c.mcpKey == "" {
+		return line
+	}
+	return strings.TrimPrefix(line, mcpQuote)
+}
+
+// startMCP answers the world's offer of MCP with an authentication key and the
+// packages triggers are watching, returning whether or not it did so.
+func (c *Connection) startMCP() bool {
+	packages := c.config.DataPackages(c.world.Name, config.SourceMCP)
+	if len(packages) == 0 {
+		return false
+	}
+	c.mcpKey = strconv.FormatUint(uint64(rand.Uint32()), 36)
+	log.Tracef("starting MCP with %s for %v", c.name, packages)
+	c.send(fmt.Sprintf("%smcp authentication-key: %s version: 2.1 to: 2.1", mcpPrefix, c.mcpKey))
+	c.send(fmt.Sprintf("%smcp-negotiate-can %s package: mcp-negotiate min-version: 1.0 max-version: 2.0", mcpPrefix, c.mcpKey))
+	for _, pkg := range packages {
+		c.send(fmt.Sprintf("%smcp-negotiate-can %s package: %s min-version: 1.0 max-version: 1.0", mcpPrefix, c.mcpKey, pkg))
+	}
+	c.send(fmt.Sprintf("%smcp-negotiate-end %s", mcpPrefix, c.mcpKey))
+	return true
+}
+
+// runDataTriggers runs the triggers watching out-of-band data from the source
+// against a message for a package. Those comparing the value with a number
+// only fire when it crosses that number.
+func (c *Connection) runDataTriggers(source, pkg string, data interface{}) {
+	log.Tracef("running triggers against %s %s from %s", source, pkg, c.name)
+	for _, t := range c.config.TriggerList() {
+		if t == nil {
+			continue
+		}
+		text, found, applies := t.MatchData(c.world.Name, source, pkg, data)
+		if !found {
+			continue
+		}
+		matched := c.dataMatched[t.Label()]
+		c.dataMatched[t.Label()] = applies
+		if !applies || (matched && t.IsThreshold()) {
+			continue
+		}
+		c.recordFire(t)
+		c.act(t, text)
+		if !t.FallsThrough() {
+			log.Tracef("trigger %s stops further triggers", t.Label())
+			break
+		}
+	}
+}
+
+// parseMCP splits an MCP message into its name and its keywords and values,
+// leaving out anything else, such as an authentication key. Keywords are
+// case-insensitive, so they are lowercased.
+func parseMCP(message string) (string, map[string]interface{}) {
+	name, rest := message, ""
+	if i := strings.IndexByte(message, ' '); i >= 0 {
+		name, rest = message[:i], message[i+1:]
+	}
+	args := map[string]interface{}{}
+	var key, token string
+	for rest = strings.TrimLeft(rest, " "); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		token, rest = mcpToken(rest)
+		if key != "" {
+			args[key] = token
+			key = ""
+			continue
+		}
+		if strings.HasSuffix(token, ":") {
+			key = strings.ToLower(strings.TrimSuffix(token, ":"))
+		}
+	}
+	return name, args
+}
+
+// mcpToken returns the first word or quoted string in the text, unquoted, and
+// the rest of the text after it.
+func mcpToken(text string) (string, string) {
+	if text[0] != '"' {
+		if i := strings.IndexByte(text, ' '); i >= 0 {
+			return text[:i], text[i:]
+		}
+		return text, ""
+	}
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) {
+				i++
+				b.WriteByte(text[i])
+			}
+		case '"':
+			return b.String(), text[i+1:]
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// msdpParser reads the variables and values in MSDP data.
+type msdpParser struct {
+	data []byte
+	i    int
+}
+
+// parseMSDP returns the variables in MSDP data along with their values, which
+// are strings, tables as maps, or arrays as slices.
+func parseMSDP(data []byte) map[string]interface{} {
+	p := &msdpParser{data: data}
+	return p.table(false)
+}
+
+// table reads variables and their values until the end of the table, if it's
+// nested, or else the end of the data.
+func (p *msdpParser) table(nested bool) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for p.i < len(p.data) {
+		switch p.data[p.i] {
+		case msdpTableClose:
+			p.i++
+			if nested {
+				return vars
+			}
+		case msdpVar:
+			p.i++
+			name := p.text()
+			vars[name] = ""
+			if p.i < len(p.data) && p.data[p.i] == msdpVal {
+				p.i++
+				vars[name] = p.value()
+			}
+		default:
+			p.i++
+		}
+	}
+	return vars
+}
+
+// array reads values until the end of the array.
+func (p *msdpParser) array() []interface{} {
+	values := []interface{}{}
+	for p.i < len(p.data) {
+		switch p.data[p.i] {
+		case msdpArrayClose:
+			p.i++
+			return values
+		case msdpVal:
+			p.i++
+			values = append(values, p.value())
+		default:
+			p.i++
+		}
+	}
+	return values
+}
+
+// value reads a single value, which may be a table or array.
+func (p *msdpParser) value() interface{} {
+	if p.i < len(p.data) {
+		switch p.data[p.i] {
+		case msdpTableOpen:
+			p.i++
+			return p.table(true)
+		case msdpArrayOpen:
+			p.i++
+			return p.array()
+		}
+	}
+	return p.text()
+}
+
+// text reads text up to the next marker.
+func (p *msdpParser) text() string {
+	start := p.i
+	for p.i < len(p.data) && p.data[p.i] > msdpArrayClose {
+		p.i++
+	}
+	return string(p.data[start:p.i])
+}
@@ -0,0 +1,42 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOutOfBand(t *testing.T) {
+	Convey("When reading out-of-band data", t, func() {
+
+		Convey("MSDP variables may hold text, tables, and arrays", func() {
+			data := []byte("\x01HEALTH\x0215\x01ROOM\x02\x03\x01NAME\x02Console room\x01EXITS\x02\x05\x02n\x02e\x06\x04\x01EMPTY")
+			So(parseMSDP(data), ShouldResemble, map[string]interface{}{
+				"HEALTH": "15",
+				"ROOM": map[string]interface{}{
+					"NAME":  "Console room",
+					"EXITS": []interface{}{"n", "e"},
+				},
+				"EMPTY": "",
+			})
+		})
+
+		Convey("MCP messages have a name and keywords with values", func() {
+			name, args := parseMCP(`dns-com-awns-status abc123 Text: "Away for \"tea\"" who: Rose`)
+			So(name, ShouldEqual, "dns-com-awns-status")
+			So(args, ShouldResemble, map[string]interface{}{
+				"text": `Away for "tea"`,
+				"who":  "Rose",
+			})
+			name, args = parseMCP("mcp-negotiate-end")
+			So(name, ShouldEqual, "mcp-negotiate-end")
+			So(args, ShouldBeEmpty)
+		})
+	})
+}
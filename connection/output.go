@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/makyo/stimmtausch/styled"
 	"github.com/makyo/stimmtausch/util"
 )
 
@@ -30,6 +31,10 @@ type output struct {
 
 	// Whether or not the output supports ANSI escape codes.
 	supportsANSI bool
+
+	// The route the output shows lines for, if it isn't one of the world's
+	// own outputs.
+	route string
 }
 
 // makeLogfile creates a logfile from a given name.
@@ -57,6 +62,8 @@ func (c *Connection) makeLogfile(out *output) error {
 // closeOutputs closes open outfiles.
 func (c *Connection) closeOutputs() {
 	log.Tracef("closing all outputs for %s", c.name)
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
 	for _, out := range c.outputs {
 		log.Tracef("closing output file %s for %s", out.name, c.name)
 		if err := out.output.Close(); err != nil {
@@ -85,6 +92,8 @@ func (c *Connection) closeOutputs() {
 // that the UI uses.
 func (c *Connection) AddOutput(name string, w io.WriteCloser, supportsANSI bool) {
 	log.Tracef("creating output %s for %s", name, c.name)
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
 	c.outputs = append(c.outputs, &output{
 		name:         name,
 		global:       false,
@@ -92,3 +101,42 @@ func (c *Connection) AddOutput(name string, w io.WriteCloser, supportsANSI bool)
 		supportsANSI: supportsANSI,
 	})
 }
+
+// RemoveOutput closes and removes the output added with AddOutput under the
+// given name, returning whether there was one.
+func (c *Connection) RemoveOutput(name string) bool {
+	log.Tracef("removing output %s for %s", name, c.name)
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+	for i, out := range c.outputs {
+		if out.global || out.userCreated || out.route != "" || out.name != name {
+			continue
+		}
+		if err := out.output.Close(); err != nil {
+			log.Warningf("error closing output %s for %s. %v", out.name, c.name, err)
+		}
+		c.outputs = append(c.outputs[0:i], c.outputs[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// Echo writes a line to the connection's outputs as though it came from the
+// world, without running triggers against it. This is used to show the user
+// things such as the output of scripts.
+func (c *Connection) Echo(line string) {
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+	for _, out := range c.outputs {
+		if out.route != "" {
+			continue
+		}
+		toWrite := line
+		if !out.supportsANSI {
+			toWrite = styled.Parse(line).Plain()
+		}
+		if _, err := fmt.Fprintln(out.output, toWrite); err != nil {
+			log.Warningf("unable to write to output %s for connection %s. %v", out.name, c.name, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Recordings are stored in the same format as ttyrec: each chunk of data read
+// from the connection is preceded by a twelve byte header holding the seconds
+// and microseconds of the time it was read and the length of the chunk, each
+// as a little-endian uint32.
+const frameHeaderSize = 12
+
+// writeFrame writes a single ttyrec frame to the given writer.
+func writeFrame(w io.Writer, at time.Time, data []byte) error {
+	var header [frameHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(at.Unix()))
+	binary.LittleEndian.PutUint32(header[4:8], uint32(at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame reads a single ttyrec frame from the given reader.
+func readFrame(r io.Reader) (time.Time, []byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return time.Time{}, nil, err
+	}
+	sec := binary.LittleEndian.Uint32(header[0:4])
+	usec := binary.LittleEndian.Uint32(header[4:8])
+	data := make([]byte, binary.LittleEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return time.Time{}, nil, err
+	}
+	return time.Unix(int64(sec), int64(usec)*1000), data, nil
+}
+
+// recorder wraps an io.Reader, writing everything read from it to a recording
+// along with the time at which it was read.
+type recorder struct {
+	r   io.Reader
+	out io.WriteCloser
+}
+
+// Read fulfills io.Reader.
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if n > 0 {
+		if werr := writeFrame(rec.out, time.Now(), p[:n]); werr != nil {
+			log.Warningf("unable to write to recording, no longer recording. %v", werr)
+			rec.out.Close()
+			rec.out = nopWriteCloser{io.Discard}
+		}
+	}
+	return n, err
+}
+
+// nopWriteCloser wraps an io.Writer, turning it into an io.WriteCloser that
+// does nothing on close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing, and does it splendidly.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// startRecording opens a new recording in the connection's log directory and
+// returns a reader which records everything read from the connection.
+func (c *Connection) startRecording() (*recorder, error) {
+	name := c.getLogFile(c.getTimestamp() + ".ttyrec")
+	log.Tracef("recording %s to %s", c.name, name)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("recording %s to %s", c.name, name)
+	return &recorder{r: c.connection, out: f}, nil
+}
+
+// replayAddr is the net.Addr of a replayed recording.
+type replayAddr string
+
+// Network fulfills net.Addr.
+func (a replayAddr) Network() string {
+	return "replay"
+}
+
+// String fulfills net.Addr.
+func (a replayAddr) String() string {
+	return string(a)
+}
+
+// replayConn is a net.Conn which plays back a recorded session, sleeping
+// between frames as long as the original session did (divided by the speed).
+// A speed of zero plays back the session as fast as possible. Anything
+// written to the connection is discarded.
+type replayConn struct {
+	f      *os.File
+	speed  float64
+	last   time.Time
+	buf    []byte
+	closed chan bool
+}
+
+// newReplayConn opens a recording for playback.
+func newReplayConn(path string, speed float64) (*replayConn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &replayConn{
+		f:      f,
+		speed:  speed,
+		closed: make(chan bool),
+	}, nil
+}
+
+// Read fulfills io.Reader.
+func (r *replayConn) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		at, data, err := readFrame(r.f)
+		if err != nil {
+			return 0, err
+		}
+		if !r.last.IsZero() && r.speed > 0 {
+			select {
+			case <-time.After(time.Duration(float64(at.Sub(r.last)) / r.speed)):
+			case <-r.closed:
+				return 0, io.EOF
+			}
+		}
+		r.last = at
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Write fulfills io.Writer, discarding everything written.
+func (r *replayConn) Write(p []byte) (int, error) {
+	log.Tracef("discarding %d bytes sent to replay", len(p))
+	return len(p), nil
+}
+
+// Close fulfills io.Closer.
+func (r *replayConn) Close() error {
+	close(r.closed)
+	return r.f.Close()
+}
+
+// LocalAddr fulfills net.Conn.
+func (r *replayConn) LocalAddr() net.Addr {
+	return replayAddr(r.f.Name())
+}
+
+// RemoteAddr fulfills net.Conn.
+func (r *replayConn) RemoteAddr() net.Addr {
+	return replayAddr(r.f.Name())
+}
+
+// SetDeadline fulfills net.Conn.
+func (r *replayConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+// SetReadDeadline fulfills net.Conn.
+func (r *replayConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline fulfills net.Conn.
+func (r *replayConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecording(t *testing.T) {
+	Convey("When recording a session", t, func() {
+		start := time.Unix(1234567890, 123456000)
+
+		Convey("Frames can be written and read back", func() {
+			var b bytes.Buffer
+			So(writeFrame(&b, start, []byte("Rose Tyler")), ShouldBeNil)
+			So(b.Len(), ShouldEqual, frameHeaderSize+10)
+			at, data, err := readFrame(&b)
+			So(err, ShouldBeNil)
+			So(at.Equal(start), ShouldBeTrue)
+			So(string(data), ShouldEqual, "Rose Tyler")
+
+			_, _, err = readFrame(&b)
+			So(err, ShouldEqual, io.EOF)
+		})
+
+		Convey("A truncated frame is an error", func() {
+			var b bytes.Buffer
+			So(writeFrame(&b, start, []byte("Rose Tyler")), ShouldBeNil)
+			_, _, err := readFrame(bytes.NewReader(b.Bytes()[:b.Len()-2]))
+			So(err, ShouldEqual, io.ErrUnexpectedEOF)
+		})
+
+		Convey("The recorder records everything read through it", func() {
+			var out bytes.Buffer
+			rec := &recorder{
+				r:   bytes.NewBufferString("Hello, Rose\n"),
+				out: nopWriteCloser{&out},
+			}
+			read, err := io.ReadAll(rec)
+			So(err, ShouldBeNil)
+			So(string(read), ShouldEqual, "Hello, Rose\n")
+			_, data, err := readFrame(&out)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "Hello, Rose\n")
+		})
+
+		Convey("Recordings can be played back", func() {
+			path := filepath.Join(t.TempDir(), "session.ttyrec")
+			f, err := os.Create(path)
+			So(err, ShouldBeNil)
+			So(writeFrame(f, start, []byte("Hello, ")), ShouldBeNil)
+			So(writeFrame(f, start.Add(50*time.Millisecond), []byte("Rose\n")), ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+
+			Convey("In real time", func() {
+				r, err := newReplayConn(path, 1)
+				So(err, ShouldBeNil)
+				defer r.Close()
+				before := time.Now()
+				read, err := io.ReadAll(r)
+				So(err, ShouldBeNil)
+				So(string(read), ShouldEqual, "Hello, Rose\n")
+				So(time.Since(before), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			})
+
+			Convey("Or as fast as possible", func() {
+				r, err := newReplayConn(path, 0)
+				So(err, ShouldBeNil)
+				defer r.Close()
+				read, err := io.ReadAll(r)
+				So(err, ShouldBeNil)
+				So(string(read), ShouldEqual, "Hello, Rose\n")
+			})
+
+			Convey("Writes are discarded", func() {
+				r, err := newReplayConn(path, 0)
+				So(err, ShouldBeNil)
+				defer r.Close()
+				n, err := r.Write([]byte("connect rose tyler\n"))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 19)
+				So(r.RemoteAddr().String(), ShouldEqual, path)
+			})
+		})
+	})
+}
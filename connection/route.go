@@ -0,0 +1,70 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"io"
+
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// AddRouteOutput adds an output which shows the lines sent to the given route
+// by route triggers, such as the buffer for a view of just pages.
+func (c *Connection) AddRouteOutput(route, name string, w io.WriteCloser, supportsANSI bool) {
+	log.Tracef("creating output %s for route %s on %s", name, route, c.name)
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+	c.outputs = append(c.outputs, &output{
+		name:         name,
+		output:       w,
+		supportsANSI: supportsANSI,
+		route:        route,
+	})
+}
+
+// Routes returns the names of the routes which the triggers for the world
+// send lines to, in the order the triggers are run.
+func (c *Connection) Routes() []string {
+	var routes []string
+	seen := map[string]bool{}
+	for _, t := range c.config.TriggerList() {
+		if t == nil || t.Type != "route" || seen[t.Route] || (t.World != "" && t.World != c.world.Name) {
+			continue
+		}
+		seen[t.Route] = true
+		routes = append(routes, t.Route)
+	}
+	return routes
+}
+
+// hasRoute returns whether or not there are any outputs for the route. The
+// caller must hold outputMu.
+func (c *Connection) hasRoute(route string) bool {
+	for _, out := range c.outputs {
+		if out.route == route {
+			return true
+		}
+	}
+	return false
+}
+
+// requestRoute asks for somewhere to show the lines sent to a route which has
+// no outputs yet. Until there is one, they're shown with the world's own.
+func (c *Connection) requestRoute(route string) {
+	if c.routesRequested[route] {
+		return
+	}
+	if c.routesRequested == nil {
+		c.routesRequested = map[string]bool{}
+	}
+	c.routesRequested[route] = true
+	log.Debugf("asking for a view for route %s on %s", route, c.name)
+	go c.env.DirectDispatch(signal.Signal{
+		Name:    "_client:route",
+		Payload: []string{c.name, route},
+	})
+}
@@ -0,0 +1,137 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/makyo/stimmtausch/config"
+)
+
+// scriptInput is the JSON sent to a script on stdin.
+type scriptInput struct {
+	// The name of the world and trigger.
+	World   string `json:"world"`
+	Trigger string `json:"trigger"`
+
+	// The line which matched, without any ANSI codes.
+	Line string `json:"line"`
+
+	// Each match within the line, as the full match followed by any capture
+	// groups.
+	Matches [][]string `json:"matches"`
+}
+
+// startScript runs the script for a trigger which matched a line in the
+// background, so long as there aren't already too many running.
+func (c *Connection) startScript(t *config.Trigger, line string) {
+	c.inBackground(t.Script, "trigger "+t.Label(), func() {
+		c.runScript(t, line)
+	})
+}
+
+// inBackground runs a script for something in the background, so long as
+// there aren't already too many running.
+func (c *Connection) inBackground(script, what string, run func()) {
+	select {
+	case c.scripts <- true:
+	default:
+		log.Warningf("too many scripts running for %s, not running %s for %s", c.name, script, what)
+		return
+	}
+	go func() {
+		defer func() { <-c.scripts }()
+		run()
+	}()
+}
+
+// runScript runs the script for a trigger which matched a line. The script is
+// passed the line followed by each capture group as arguments, and all of the
+// same as JSON on stdin. Each line the script prints is either sent to the
+// world or shown to the user, depending on the trigger.
+func (c *Connection) runScript(t *config.Trigger, line string) {
+	input := scriptInput{
+		World:   c.world.Name,
+		Trigger: t.Label(),
+		Line:    line,
+		Matches: t.Submatches(line),
+	}
+	args := []string{line}
+	for _, match := range input.Matches {
+		args = append(args, match[1:]...)
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		log.Errorf("unable to encode input for script %s. %v", t.Script, err)
+		return
+	}
+	c.execScript(t.Script, "trigger "+t.Label(), args, stdin, t.OutputToWorld)
+}
+
+// execScript runs a script with the given arguments and stdin, then either
+// writes each line it prints to the connection, as if the user had typed it, or
+// shows it to the user.
+func (c *Connection) execScript(path, what string, args []string, stdin []byte, outputToWorld bool) {
+	script, err := homedir.Expand(path)
+	if err != nil {
+		log.Errorf("unable to find script %s. %v", path, err)
+		return
+	}
+
+	timeout := time.Duration(c.config.Client.Scripts.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.Command(script, args...)
+	// The script runs in its own process group so that anything it leaves
+	// running in the background is killed along with it, rather than holding
+	// on to its output and keeping it from ever finishing.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Tracef("running script %s for %s on %s", script, what, c.name)
+	if err = cmd.Start(); err == nil {
+		done := make(chan bool)
+		go func() {
+			select {
+			case <-ctx.Done():
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("script %s for %s took longer than %v and was killed", path, what, timeout)
+		return
+	}
+	if err != nil {
+		log.Errorf("script %s for %s failed. %v %s", path, what, err, strings.TrimSpace(stderr.String()))
+		return
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		if outputToWorld {
+			c.Write(scanner.Bytes())
+		} else {
+			c.Echo(scanner.Text())
+		}
+	}
+}
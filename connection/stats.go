@@ -0,0 +1,200 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats holds statistics about a connection, useful for figuring out whether
+// slowness is the fault of the server or the network.
+type Stats struct {
+	// The number of bytes and lines received from the server.
+	BytesIn int64 `json:"bytes_in"`
+	LinesIn int64 `json:"lines_in"`
+
+	// The number of bytes and lines sent to the server.
+	BytesOut int64 `json:"bytes_out"`
+	LinesOut int64 `json:"lines_out"`
+
+	// When the connection was opened.
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// When data was last sent or received.
+	LastActivity time.Time `json:"last_activity"`
+
+	// The most recently measured round-trip time to the server. This is zero
+	// if the server type has no ping command configured.
+	Lag time.Duration `json:"lag"`
+}
+
+// statsTracker guards a connection's stats, as they are updated from the
+// goroutines reading from and writing to the connection.
+type statsTracker struct {
+	Stats
+
+	// When the most recent ping was sent, if it hasn't been answered yet.
+	pingSent time.Time
+
+	mu sync.Mutex
+}
+
+// snapshot returns a copy of the stats safe for reading.
+func (s *statsTracker) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Stats
+}
+
+// connected resets the stats for a newly opened connection.
+func (s *statsTracker) connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.Stats = Stats{
+		ConnectedAt:  now,
+		LastActivity: now,
+	}
+	s.pingSent = time.Time{}
+}
+
+// received records bytes received from the server.
+func (s *statsTracker) received(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BytesIn += int64(n)
+	s.LastActivity = time.Now()
+}
+
+// receivedLine records a complete line received from the server.
+func (s *statsTracker) receivedLine() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LinesIn++
+}
+
+// sent records a line sent to the server.
+func (s *statsTracker) sent(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BytesOut += int64(n)
+	s.LinesOut++
+	s.LastActivity = time.Now()
+}
+
+// pinged records that a ping was just sent to the server.
+func (s *statsTracker) pinged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pingSent = time.Now()
+}
+
+// ponged records the response to a ping, returning whether or not a ping was
+// actually pending.
+func (s *statsTracker) ponged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pingSent.IsZero() {
+		return false
+	}
+	s.Lag = time.Since(s.pingSent)
+	s.pingSent = time.Time{}
+	return true
+}
+
+// String renders the stats in a human-readable form.
+func (s Stats) String() string {
+	lag := "not measured"
+	if s.Lag != 0 {
+		lag = s.Lag.Round(time.Millisecond).String()
+	}
+	lines := []string{
+		fmt.Sprintf("Connected at:  %s (%s ago)", s.ConnectedAt.Format(time.RFC1123), time.Since(s.ConnectedAt).Round(time.Second)),
+		fmt.Sprintf("Last activity: %s ago", time.Since(s.LastActivity).Round(time.Second)),
+		fmt.Sprintf("Received:      %d lines (%d bytes)", s.LinesIn, s.BytesIn),
+		fmt.Sprintf("Sent:          %d lines (%d bytes)", s.LinesOut, s.BytesOut),
+		fmt.Sprintf("Lag:           %s", lag),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// countingReader wraps an io.Reader, recording the number of bytes read in
+// the connection's stats.
+type countingReader struct {
+	r     io.Reader
+	stats *statsTracker
+}
+
+// Read fulfills io.Reader.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.stats.received(n)
+	return n, err
+}
+
+// Stats returns a snapshot of the connection's statistics.
+func (c *Connection) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// writeStats writes the connection's statistics as JSON to the stats file in
+// the connection's working directory for the benefit of headless UIs.
+func (c *Connection) writeStats() error {
+	out, err := json.MarshalIndent(c.Stats(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.getConnectionFile(statsFile), out, 0644)
+}
+
+// removeStats removes the stats file from the connection's working directory.
+func (c *Connection) removeStats() {
+	if err := os.Remove(c.getConnectionFile(statsFile)); err != nil && !os.IsNotExist(err) {
+		log.Warningf("unable to remove stats file for %s. %v", c.name, err)
+	}
+}
+
+// monitor periodically writes the stats file and, if the server type has a
+// ping command, sends it in order to measure lag.
+func (c *Connection) monitor(done chan bool) {
+	st, ok := c.config.ServerTypes[c.server.ServerType]
+	pings := ok && st.Pings()
+	statsTicker := time.NewTicker(statsInterval)
+	defer statsTicker.Stop()
+	var pingTick <-chan time.Time
+	if pings {
+		pingTicker := time.NewTicker(time.Duration(st.PingInterval) * time.Second)
+		defer pingTicker.Stop()
+		pingTick = pingTicker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-statsTicker.C:
+			if err := c.writeStats(); err != nil {
+				log.Warningf("unable to write stats for %s. %v", c.name, err)
+			}
+			c.config.FlushTriggerState()
+		case <-pingTick:
+			log.Tracef("pinging %s", c.name)
+			c.stats.pinged()
+			if _, err := fmt.Fprintln(c.connection, st.PingCommand); err != nil {
+				log.Warningf("unable to ping %s. %v", c.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"io"
+)
+
+// Telnet commands.
+const (
+	telnetIAC  byte = 255
+	telnetDONT byte = 254
+	telnetDO   byte = 253
+	telnetWONT byte = 252
+	telnetWILL byte = 251
+	telnetSB   byte = 250
+	telnetGA   byte = 249
+	telnetSE   byte = 240
+	telnetEOR  byte = 239
+)
+
+// States of the telnet parser.
+const (
+	telnetData = iota
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+// telnetReader wraps the reader for a connection, stripping telnet commands
+// out of the data received so that they don't end up in the output. Options
+// the server offers are refused unless accept agrees to them, and any it asks
+// us to enable are refused.
+type telnetReader struct {
+	r io.Reader
+
+	// Where to send responses to the server.
+	w io.Writer
+
+	// Whether or not to agree to an option the server offers, what to do once
+	// it has been agreed to, and what to do with the data of each
+	// subnegotiation for an option agreed to. Any of them may be nil.
+	accept         func(option byte) bool
+	enabled        func(option byte)
+	subnegotiation func(option byte, data []byte)
+
+	// The options agreed to.
+	agreed map[byte]bool
+
+	// The parser's current state, which is kept between reads as commands
+	// may be split across them.
+	state   int
+	command byte
+
+	// The subnegotiation being read, starting with its option.
+	sb []byte
+
+	// The last byte of data passed through.
+	last byte
+}
+
+// Read fulfills io.Reader.
+func (t *telnetReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.r.Read(p)
+		n = t.filter(p[:n])
+		// Don't return an empty read unless there's an error, as bufio will
+		// eventually complain about that.
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter strips telnet commands from the buffer in place, returning the
+// length of the data left over. Prompts are often not followed by a newline,
+// but instead by a go ahead or end of record, so those are turned into
+// newlines so that the prompt may be read as a line.
+func (t *telnetReader) filter(b []byte) int {
+	n := 0
+	for _, c := range b {
+		switch t.state {
+		case telnetData:
+			if c == telnetIAC {
+				t.state = telnetCommand
+				continue
+			}
+			b[n] = c
+			n++
+			t.last = c
+		case telnetCommand:
+			switch c {
+			case telnetIAC:
+				// An escaped 0xff.
+				b[n] = c
+				n++
+				t.last = c
+				t.state = telnetData
+			case telnetGA, telnetEOR:
+				if t.last != '\n' {
+					b[n] = '\n'
+					n++
+					t.last = '\n'
+				}
+				t.state = telnetData
+			case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+				t.command = c
+				t.state = telnetOption
+			case telnetSB:
+				t.sb = t.sb[:0]
+				t.state = telnetSubnegotiation
+			default:
+				// Everything else (NOP, GA, AYT, etc.) is a single byte.
+				t.state = telnetData
+			}
+		case telnetOption:
+			t.negotiate(t.command, c)
+			t.state = telnetData
+		case telnetSubnegotiation:
+			if c == telnetIAC {
+				t.state = telnetSubnegotiationIAC
+				continue
+			}
+			t.sb = append(t.sb, c)
+		case telnetSubnegotiationIAC:
+			switch c {
+			case telnetSE:
+				t.finishSubnegotiation()
+				t.state = telnetData
+			case telnetIAC:
+				// An escaped 0xff within the data.
+				t.sb = append(t.sb, c)
+				t.state = telnetSubnegotiation
+			default:
+				t.state = telnetSubnegotiation
+			}
+		}
+	}
+	return n
+}
+
+// negotiate responds to the server offering to enable or disable an option or
+// asking us to, agreeing to those it offers which accept agrees to.
+func (t *telnetReader) negotiate(command, option byte) {
+	switch {
+	case command == telnetWILL && t.agreed[option]:
+		// Already agreed to, so it needs no response.
+	case command == telnetWILL && t.accept != nil && t.accept(option):
+		log.Tracef("agreeing to telnet option %d", option)
+		if t.agreed == nil {
+			t.agreed = map[byte]bool{}
+		}
+		t.agreed[option] = true
+		t.respond(telnetDO, option)
+		if t.enabled != nil {
+			t.enabled(option)
+		}
+	case command == telnetWONT && t.agreed[option]:
+		delete(t.agreed, option)
+		t.respond(telnetDONT, option)
+	default:
+		t.refuse(command, option)
+	}
+}
+
+// finishSubnegotiation passes on the data of the subnegotiation just read, if
+// it's for an option agreed to.
+func (t *telnetReader) finishSubnegotiation() {
+	if len(t.sb) == 0 || !t.agreed[t.sb[0]] || t.subnegotiation == nil {
+		return
+	}
+	data := make([]byte, len(t.sb)-1)
+	copy(data, t.sb[1:])
+	t.subnegotiation(t.sb[0], data)
+}
+
+// refuse responds to the server offering to enable an option or asking us to
+// enable one by declining.
+func (t *telnetReader) refuse(command, option byte) {
+	var response byte
+	switch command {
+	case telnetWILL:
+		response = telnetDONT
+	case telnetDO:
+		response = telnetWONT
+	default:
+		// Agreeing to disable something requires no response.
+		return
+	}
+	log.Tracef("refusing telnet option %d", option)
+	t.respond(response, option)
+}
+
+// respond sends a response to the server's negotiation of an option.
+func (t *telnetReader) respond(response, option byte) {
+	if _, err := t.w.Write([]byte{telnetIAC, response, option}); err != nil {
+		log.Warningf("unable to respond to telnet negotiation. %v", err)
+	}
+}
+
+// writeSubnegotiation sends the data for an option to the server as a
+// subnegotiation, escaping any 0xff within it.
+func writeSubnegotiation(w io.Writer, option byte, data []byte) error {
+	out := []byte{telnetIAC, telnetSB, option}
+	for _, c := range data {
+		out = append(out, c)
+		if c == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+	}
+	out = append(out, telnetIAC, telnetSE)
+	_, err := w.Write(out)
+	return err
+}
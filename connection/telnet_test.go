@@ -0,0 +1,95 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package connection
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTelnet(t *testing.T) {
+	Convey("When reading from a telnet connection", t, func() {
+		var responses bytes.Buffer
+
+		read := func(chunks ...[]byte) string {
+			r := &telnetReader{
+				r: io.MultiReader(func() []io.Reader {
+					readers := []io.Reader{}
+					for _, chunk := range chunks {
+						readers = append(readers, bytes.NewReader(chunk))
+					}
+					return readers
+				}()...),
+				w: &responses,
+			}
+			out, err := io.ReadAll(r)
+			So(err, ShouldBeNil)
+			return string(out)
+		}
+
+		Convey("Plain text is left alone", func() {
+			So(read([]byte("Hello, Rose\r\n")), ShouldEqual, "Hello, Rose\r\n")
+		})
+
+		Convey("Commands are stripped", func() {
+			So(read([]byte("Hello,\xff\xf1 Rose\xff\xf6\r\n")), ShouldEqual, "Hello, Rose\r\n")
+		})
+
+		Convey("Go aheads end prompts", func() {
+			So(read([]byte("By what name do you wish to be known? \xff\xf9")), ShouldEqual, "By what name do you wish to be known? \n")
+			So(read([]byte("Password: \xff\xef")), ShouldEqual, "Password: \n")
+			So(read([]byte("Hello, Rose\r\n\xff\xf9")), ShouldEqual, "Hello, Rose\r\n")
+		})
+
+		Convey("Escaped IACs are kept", func() {
+			So(read([]byte("\xff\xff")), ShouldEqual, "\xff")
+		})
+
+		Convey("Subnegotiations are stripped", func() {
+			So(read([]byte("Hello, \xff\xfa\xc9Core.Hello {}\xff\xf0Rose")), ShouldEqual, "Hello, Rose")
+		})
+
+		Convey("Commands split across reads are stripped", func() {
+			So(read([]byte("Hello, \xff"), []byte("\xfa\xc9Core"), []byte(".Hello {}\xff"), []byte("\xf0Rose")), ShouldEqual, "Hello, Rose")
+		})
+
+		Convey("Options are refused", func() {
+			So(read([]byte("\xff\xfb\x01\xff\xfd\x18\xff\xfc\x03Rose")), ShouldEqual, "Rose")
+			So(responses.Bytes(), ShouldResemble, []byte("\xff\xfe\x01\xff\xfc\x18"))
+		})
+
+		Convey("Options may be agreed to, passing on their subnegotiations", func() {
+			var enabled []byte
+			var data []string
+			r := &telnetReader{
+				r:      bytes.NewReader([]byte("\xff\xfb\xc9\xff\xfb\xc9\xff\xfb\x01\xff\xfa\xc9Char {\"a\": \"\xff\xff\"}\xff\xf0\xff\xfa\x01x\xff\xf0Rose")),
+				w:      &responses,
+				accept: func(option byte) bool { return option == telnetGMCP },
+				enabled: func(option byte) {
+					enabled = append(enabled, option)
+				},
+				subnegotiation: func(option byte, b []byte) {
+					data = append(data, string(b))
+				},
+			}
+			out, err := io.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, "Rose")
+			So(responses.Bytes(), ShouldResemble, []byte("\xff\xfd\xc9\xff\xfe\x01"))
+			So(enabled, ShouldResemble, []byte{telnetGMCP})
+			So(data, ShouldResemble, []string{"Char {\"a\": \"\xff\"}"})
+		})
+
+		Convey("Subnegotiations are written with 0xff escaped", func() {
+			So(writeSubnegotiation(&responses, telnetGMCP, []byte("a\xffb")), ShouldBeNil)
+			So(responses.Bytes(), ShouldResemble, []byte("\xff\xfa\xc9a\xff\xffb\xff\xf0"))
+		})
+	})
+}
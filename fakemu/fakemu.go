@@ -0,0 +1,360 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+// Package fakemu provides a scriptable local MU* server for use in tests.
+//
+// A server is started with a script, which is a list of steps to run against
+// each client that connects: wait for a line matching a pattern, send some
+// output, send raw telnet sequences, pause, or drop the connection. Every line
+// the server receives (minus any telnet sequences) is kept so that tests can
+// check what the client sent.
+//
+//	srv, err := fakemu.New(
+//	    fakemu.Expect("^connect rose tyler$"),
+//	    fakemu.Send("Welcome to the TARDIS!"),
+//	    fakemu.SendIAC(fakemu.WILL, 1),
+//	    fakemu.Expect("^QUIT$"),
+//	    fakemu.Drop(),
+//	)
+//	defer srv.Close()
+//	// connect to srv.Host():srv.Port()...
+//	err = srv.Wait(5 * time.Second)
+package fakemu
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Telnet commands which may be sent with SendIAC.
+const (
+	IAC  byte = 255
+	DONT byte = 254
+	DO   byte = 253
+	WONT byte = 252
+	WILL byte = 251
+	SB   byte = 250
+	GA   byte = 249
+	NOP  byte = 241
+	SE   byte = 240
+)
+
+// DefaultTimeout is how long Expect waits for a matching line by default.
+const DefaultTimeout = 5 * time.Second
+
+// Step is a single step in a server's script.
+type Step func(s *Session) error
+
+// Server is a fake MU* server listening on localhost.
+type Server struct {
+	listener net.Listener
+	steps    []Step
+
+	mu       sync.Mutex
+	received []string
+	sessions int
+
+	// Errors from each session, in the order they finished.
+	done chan error
+}
+
+// Session represents a single client connected to the server.
+type Session struct {
+	server *Server
+	conn   net.Conn
+	lines  chan string
+}
+
+// Expect waits for the client to send a line matching the given regexp,
+// failing if it takes longer than DefaultTimeout.
+func Expect(pattern string) Step {
+	return ExpectWithin(pattern, DefaultTimeout)
+}
+
+// ExpectWithin waits for the client to send a line matching the given regexp,
+// failing if it takes longer than the given timeout. Lines which don't match
+// are skipped.
+func ExpectWithin(pattern string, timeout time.Duration) Step {
+	re := regexp.MustCompile(pattern)
+	return func(s *Session) error {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case line, ok := <-s.lines:
+				if !ok {
+					return fmt.Errorf("client disconnected while expecting %q", pattern)
+				}
+				if re.MatchString(line) {
+					return nil
+				}
+			case <-deadline:
+				return fmt.Errorf("timed out expecting %q", pattern)
+			}
+		}
+	}
+}
+
+// Send sends each of the given lines to the client, terminated with CRLF.
+func Send(lines ...string) Step {
+	return func(s *Session) error {
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(s.conn, "%s\r\n", line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SendRaw sends the given bytes to the client exactly as they are.
+func SendRaw(b []byte) Step {
+	return func(s *Session) error {
+		_, err := s.conn.Write(b)
+		return err
+	}
+}
+
+// SendIAC sends a telnet command (IAC followed by the given bytes) to the
+// client.
+func SendIAC(cmd ...byte) Step {
+	return SendRaw(append([]byte{IAC}, cmd...))
+}
+
+// SendSubnegotiation sends a telnet subnegotiation for the given option with
+// the given data to the client.
+func SendSubnegotiation(option byte, data []byte) Step {
+	b := []byte{IAC, SB, option}
+	for _, c := range data {
+		b = append(b, c)
+		if c == IAC {
+			b = append(b, IAC)
+		}
+	}
+	return SendRaw(append(b, IAC, SE))
+}
+
+// Pause waits for the given duration before continuing.
+func Pause(d time.Duration) Step {
+	return func(_ *Session) error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+// Drop closes the connection to the client without warning, as if the server
+// went away.
+func Drop() Step {
+	return func(s *Session) error {
+		return s.conn.Close()
+	}
+}
+
+// WaitForDisconnect waits for the client to close the connection, failing if
+// it takes longer than DefaultTimeout.
+func WaitForDisconnect() Step {
+	return func(s *Session) error {
+		deadline := time.After(DefaultTimeout)
+		for {
+			select {
+			case _, ok := <-s.lines:
+				if !ok {
+					return nil
+				}
+			case <-deadline:
+				return fmt.Errorf("timed out waiting for the client to disconnect")
+			}
+		}
+	}
+}
+
+// readLines reads lines from the client, stripping telnet sequences, and
+// both records them on the server and sends them to the session.
+func (s *Session) readLines() {
+	defer close(s.lines)
+	scanner := bufio.NewScanner(s.conn)
+	for scanner.Scan() {
+		line := StripIAC(scanner.Bytes())
+		s.server.mu.Lock()
+		s.server.received = append(s.server.received, line)
+		s.server.mu.Unlock()
+		s.lines <- line
+	}
+}
+
+// StripIAC removes telnet commands and trailing carriage returns from a line.
+func StripIAC(b []byte) string {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != IAC {
+			out = append(out, b[i])
+			continue
+		}
+		if i+1 >= len(b) {
+			break
+		}
+		switch b[i+1] {
+		case IAC:
+			out = append(out, IAC)
+			i++
+		case DO, DONT, WILL, WONT:
+			i += 2
+		case SB:
+			for i++; i < len(b) && !(b[i] == SE && b[i-1] == IAC); i++ {
+			}
+		default:
+			i++
+		}
+	}
+	if len(out) > 0 && out[len(out)-1] == '\r' {
+		out = out[:len(out)-1]
+	}
+	return string(out)
+}
+
+// serve accepts connections and runs the script against each.
+func (srv *Server) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.sessions++
+		srv.mu.Unlock()
+		go srv.run(conn)
+	}
+}
+
+// run runs the script against a single connection.
+func (srv *Server) run(conn net.Conn) {
+	s := &Session{
+		server: srv,
+		conn:   conn,
+		lines:  make(chan string, 100),
+	}
+	go s.readLines()
+	var err error
+	for _, step := range srv.steps {
+		if err = step(s); err != nil {
+			break
+		}
+	}
+	srv.done <- err
+}
+
+// Host returns the host on which the server is listening.
+func (srv *Server) Host() string {
+	return srv.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port on which the server is listening.
+func (srv *Server) Port() uint {
+	return uint(srv.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Received returns every line the server has received so far, across all
+// sessions.
+func (srv *Server) Received() []string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]string{}, srv.received...)
+}
+
+// Sessions returns the number of clients that have connected so far.
+func (srv *Server) Sessions() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.sessions
+}
+
+// Wait waits for the script to finish running against a client, returning
+// the error it failed with, if any.
+func (srv *Server) Wait(timeout time.Duration) error {
+	select {
+	case err := <-srv.done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out waiting for script to finish")
+	}
+}
+
+// Close stops the server listening.
+func (srv *Server) Close() error {
+	return srv.listener.Close()
+}
+
+// start begins serving on the given listener.
+func start(l net.Listener, steps []Step) *Server {
+	srv := &Server{
+		listener: l,
+		steps:    steps,
+		done:     make(chan error, 10),
+	}
+	go srv.serve()
+	return srv
+}
+
+// New starts a new server listening for plain TCP connections on localhost,
+// which will run the given script against each client.
+func New(steps ...Step) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return start(l, steps), nil
+}
+
+// NewTLS starts a new server listening for TLS connections on localhost, which
+// will run the given script against each client. The server uses a freshly
+// generated self-signed certificate, so clients will need to skip verifying
+// it.
+func NewTLS(steps ...Step) (*Server, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	return start(l, steps), nil
+}
+
+// selfSignedCert generates a certificate for localhost.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"Stimmtausch tests"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
@@ -0,0 +1,58 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package fakemu_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/fakemu"
+)
+
+func TestServer(t *testing.T) {
+	Convey("When running a fake server", t, func() {
+		srv, err := fakemu.New(
+			fakemu.Expect("^connect rose badwolf$"),
+			fakemu.Send("Welcome to the TARDIS!"),
+			fakemu.Drop(),
+		)
+		So(err, ShouldBeNil)
+		defer srv.Close()
+		conn, err := net.Dial("tcp", net.JoinHostPort(srv.Host(), strconv.Itoa(int(srv.Port()))))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		Convey("It runs its script against the client", func() {
+			fmt.Fprint(conn, "look\r\n\xff\xfb\x01connect rose badwolf\r\n")
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			So(err, ShouldBeNil)
+			So(line, ShouldEqual, "Welcome to the TARDIS!\r\n")
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldBeNil)
+			So(srv.Received(), ShouldResemble, []string{"look", "connect rose badwolf"})
+			So(srv.Sessions(), ShouldEqual, 1)
+		})
+
+		Convey("It fails if the client goes away early", func() {
+			conn.Close()
+			So(srv.Wait(fakemu.DefaultTimeout), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStripIAC(t *testing.T) {
+	Convey("StripIAC removes telnet sequences", t, func() {
+		So(fakemu.StripIAC([]byte("hello\r")), ShouldEqual, "hello")
+		So(fakemu.StripIAC([]byte("\xff\xfd\x18he\xff\xf1llo")), ShouldEqual, "hello")
+		So(fakemu.StripIAC([]byte("\xff\xfa\xc9Core.Hello {}\xff\xf0hi")), ShouldEqual, "hi")
+		So(fakemu.StripIAC([]byte("a\xff\xffb")), ShouldEqual, "a\xffb")
+	})
+}
@@ -52,6 +52,39 @@ func (h *headless) listen() {
 			log.Infof("Headless Stimmtausch help")
 		case "_client:connect":
 			h.connect(res.Payload[0])
+		case "_client:send", "_client:echo":
+			// There's no current world without a UI, so sends and echoes
+			// without a world only go anywhere if there's just the one.
+			if len(res.Payload) < 2 || res.Payload[0] != "" {
+				continue
+			}
+			names := h.client.ConnNames()
+			if len(names) != 1 {
+				log.Warningf("not sure which world to use for %s, ignoring %q", res.Name, res.Payload[1:])
+				continue
+			}
+			if res.Name == "_client:send" {
+				h.client.Send(names[0], res.Payload[1:]...)
+			} else {
+				h.client.Echo(names[0], res.Payload[1:]...)
+			}
+		case "trigger":
+			// Likewise, trigger tests without a world use the only one
+			// connected, if there are any.
+			if res.Err != nil || len(res.Payload) != 3 || res.Payload[0] != "test" || res.Payload[1] != "" {
+				continue
+			}
+			names := h.client.ConnNames()
+			if len(names) == 0 {
+				continue
+			}
+			conn, ok := h.client.Conn(names[0])
+			if len(names) != 1 || !ok {
+				log.Warningf("not sure which world to test %q against", res.Payload[2])
+				continue
+			}
+			res.Payload = []string{"test", conn.GetWorldName(), res.Payload[2]}
+			go h.client.Env.DirectDispatch(res)
 		default:
 			log.Tracef("got unknown signal result %v", res)
 		}
@@ -69,7 +102,6 @@ func (h *headless) mainLoop() error {
 			return errQuit
 		}
 	}
-	return nil
 }
 
 func (h *headless) Run(done chan bool) {
@@ -81,6 +113,7 @@ func (h *headless) Run(done chan bool) {
 		log.Criticalf("headless unexpectedly quit: %v", err)
 	}
 	h.client.CloseAll()
+	h.client.StopPlugins()
 	log.Infof("I think we're done?")
 	done <- true
 }
@@ -94,6 +94,94 @@ var HelpMessages = map[string]Help{
 		Description: "Quitting Stimmtausch is accomplished to the /quit command.", // Note that if you send `/quit` from _any_ client attached to Stimmtausch (e.g: if you're using Stimmtausch in headless mode or as a server), it will quit, detaching every connected client.",
 	},
 
+	"dnd": Help{
+		Name:      "/dnd",
+		ShortDesc: "do not disturb",
+		Synopsis: map[string]string{
+			"":    "toggle do not disturb",
+			"on":  "turn do not disturb on",
+			"off": "turn do not disturb off",
+		},
+		Overview:    "Command to turn notifications off for a while.",
+		Description: "While do not disturb is on, notify triggers don't ring the bell, mark worlds as urgent, or run the notifier command. The send title shows when it's on.",
+	},
+
+	"watch": Help{
+		Name:      "/watch",
+		ShortDesc: "watch for characters connecting",
+		Synopsis: map[string]string{
+			"":                         "show who you're watching for in the current world",
+			"<name>":                   "watch for someone in the current world",
+			"--world <world> [<name>]": "show or add to the list for the world specified",
+		},
+		Overview:    "Command to keep track of when characters connect and disconnect.",
+		Description: "The /watch command keeps a list of characters in each world, notifying you as a notify trigger would when one of them connects, and showing whether each is online or when they were last seen. Connections and disconnections are spotted using the `watch_connected` and `watch_disconnected` patterns of the world's server type, which for MUCKs match messages like `Martha has connected.`. The list and when each character was last seen are kept in `watch.yaml` in the working directory.",
+		SeeAlso:     "`/unwatch`, `/dnd`",
+	},
+
+	"unwatch": Help{
+		Name:      "/unwatch",
+		ShortDesc: "stop watching for a character",
+		Synopsis: map[string]string{
+			"<name>":                 "stop watching for someone in the current world",
+			"--world <world> <name>": "stop watching for someone in the world specified",
+		},
+		Overview:    "Command to remove a character from the watch list.",
+		Description: "The /unwatch command removes a character from the list kept by /watch.",
+		SeeAlso:     "`/watch`",
+	},
+
+	"stats": Help{
+		Name:      "/stats",
+		ShortDesc: "connection statistics",
+		Synopsis: map[string]string{
+			"":        "show statistics for the current world",
+			"<world>": "show statistics for the world specified",
+		},
+		Overview:    "Command to show statistics about a connection.",
+		Description: "The /stats command shows how much data has been sent to and received from a world, when it was connected, when it was last active, and how laggy it is. Lag is only measured if the world's server type has a `ping_command` and `ping_response` set. These statistics are also written as JSON to the `stats` file in the connection's working directory every few seconds for the benefit of headless UIs.",
+	},
+
+	"alias": Help{
+		Name:      "/alias",
+		ShortDesc: "rewrite what you type",
+		Synopsis: map[string]string{
+			"":                                  "list all aliases",
+			"<name> <pattern> => <replacement>": "add an alias, or replace the one with that name",
+		},
+		Overview:    "Command to add aliases, which rewrite lines before they are sent.",
+		Description: "Aliases rewrite what you type before it is sent to the world. The pattern is a regular expression, and the first match of the first alias which matches is replaced, with capture groups available as `$1`, `$2`, and so on. If the result starts with a `/`, it is run as a command. For instance, `/alias page ^p (\\w+)=(.*) => page $1=$2` lets you page someone with `p Rose=Hello!`. Aliases added this way last until Stimmtausch quits or reloads; add them to your configuration to keep them.",
+		SeeAlso:     "`/unalias`",
+	},
+
+	"unalias": Help{
+		Name:      "/unalias",
+		ShortDesc: "remove an alias",
+		Synopsis: map[string]string{
+			"<name>": "remove the alias with that name",
+		},
+		Overview:    "Command to remove aliases.",
+		Description: "Removes an alias, whether it was added with /alias or came from your configuration (until the next reload).",
+		SeeAlso:     "`/alias`",
+	},
+
+	"trigger": Help{
+		Name:      "/trigger",
+		ShortDesc: "manage triggers",
+		Synopsis: map[string]string{
+			"":     "list all triggers",
+			"list": "list all triggers, in the order they're run, with how many times each has matched",
+			"add [--world <world>] [--for <duration>] [--times <n>|--once] <name> <type> <pattern> [=> <value>]": "add a trigger, which may be removed after some time or number of matches",
+			"enable <name>":                 "enable a trigger",
+			"disable <name>":                "disable a trigger, so that it doesn't match until it's enabled again",
+			"remove <name>":                 "remove a trigger",
+			"save":                          "save triggers added with /trigger add",
+			"test [--world <world>] <line>": "show which triggers match a line, and what it would look like",
+		},
+		Overview:    "Command to manage triggers while Stimmtausch is running.",
+		Description: "Triggers may be added, enabled, disabled, and removed without editing your configuration and reloading. When adding a trigger, the type is one of `hilite`, `gag`, `replace`, `route`, `script`, `macro`, or `notify`, and the value after `=>` is the attributes for a hilite, the replacement for a replacement, the view for a route, the path of a script, or the name of a macro. For instance, `/trigger add rose hilite [Rr]ose => bold+cyan` hilites Rose's name in every world. Adding `--for 1h` removes the trigger after an hour, and `--times 5` or `--once` removes it after it has matched that many times. Triggers added this way last until Stimmtausch quits or reloads, unless they are saved with `/trigger save`, which writes them to `session-triggers.st.yaml` in your config directory. Disabling or removing a trigger from your configuration lasts until the next reload. Testing a line only applies hilites, gags, and replacements; no scripts or macros are run.",
+	},
+
 	"syslog": Help{
 		Name:      "/syslog",
 		ShortDesc: "log to the system log",
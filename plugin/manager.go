@@ -0,0 +1,244 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+// Package plugin runs long-lived plugin programs, which may be written in any
+// language, and talks to them using JSON-RPC over their stdin and stdout.
+package plugin
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/juju/loggo"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/connection"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+var log = loggo.GetLogger("stimmtausch.plugin")
+
+// Manager starts and stops plugins, and passes on signals and lines received
+// from worlds to them.
+type Manager struct {
+	config   *config.Config
+	env      *signal.Dispatcher
+	conn     func(string) (*connection.Connection, bool)
+	listener chan signal.Signal
+
+	mu      sync.Mutex
+	plugins map[string]*plugin
+
+	// The worlds whose lines are passed on to the plugins while any of them
+	// want lines. attachMu keeps outputs from being added and removed at
+	// the same time.
+	worlds   map[string]bool
+	attached bool
+	attachMu sync.Mutex
+}
+
+// Start starts every plugin in the config.
+func (m *Manager) Start() {
+	m.mu.Lock()
+	for name, cfg := range m.config.Plugins {
+		p := newPlugin(cfg, m.env)
+		p.exited = m.exited(name, p)
+		if err := p.start(); err != nil {
+			log.Errorf("unable to start plugin %s. %v", name, err)
+			continue
+		}
+		m.plugins[name] = p
+	}
+	m.mu.Unlock()
+	m.updateOutputs()
+}
+
+// exited returns a function to be called when the given plugin exits, which
+// forgets about it and stops passing on lines if no other plugin wants them.
+func (m *Manager) exited(name string, p *plugin) func() {
+	return func() {
+		m.mu.Lock()
+		if m.plugins[name] == p {
+			delete(m.plugins, name)
+		}
+		m.mu.Unlock()
+		m.updateOutputs()
+	}
+}
+
+// Stop stops every running plugin, waiting for them to exit.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	plugins := m.plugins
+	m.plugins = map[string]*plugin{}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range plugins {
+		wg.Add(1)
+		go func(p *plugin) {
+			defer wg.Done()
+			p.stop()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Restart stops all plugins and starts them again, such as after the config
+// has been reloaded.
+func (m *Manager) Restart() {
+	m.Stop()
+	m.Start()
+}
+
+// each calls f with each running plugin.
+func (m *Manager) each(f func(p *plugin)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.plugins {
+		f(p)
+	}
+}
+
+// listen passes on signals to the plugins which want them, and watches for
+// new connections so that the plugins can be sent their lines.
+func (m *Manager) listen() {
+	for {
+		res := <-m.listener
+		if res.Name == "_client:connect" && res.Err == nil && len(res.Payload) != 0 {
+			m.attach(res.Payload[0])
+		}
+		if res.Name == "_client:disconnect" && len(res.Payload) != 0 {
+			m.detach(res.Payload[len(res.Payload)-1])
+		}
+		params := signalParams{
+			Name:    res.Name,
+			Payload: res.Payload,
+		}
+		if res.Err != nil {
+			params.Error = res.Err.Error()
+		}
+		m.each(func(p *plugin) {
+			if p.config.WantsSignal(res.Name) {
+				p.notify("signal", params)
+			}
+		})
+	}
+}
+
+// wantLines returns whether any running plugin wants lines from worlds.
+func (m *Manager) wantLines() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.plugins {
+		if p.config.Lines {
+			return true
+		}
+	}
+	return false
+}
+
+// attach starts passing on lines from the world with the given name to the
+// plugins.
+func (m *Manager) attach(name string) {
+	m.attachMu.Lock()
+	defer m.attachMu.Unlock()
+	m.worlds[name] = true
+	if m.attached {
+		m.addOutput(name)
+	}
+}
+
+// detach stops passing on lines from the world with the given name.
+func (m *Manager) detach(name string) {
+	m.attachMu.Lock()
+	defer m.attachMu.Unlock()
+	if !m.worlds[name] {
+		return
+	}
+	delete(m.worlds, name)
+	if m.attached {
+		m.removeOutput(name)
+	}
+}
+
+// updateOutputs adds outputs to the worlds' connections which pass each line
+// on to the plugins if any of them want lines, and removes them if none do.
+func (m *Manager) updateOutputs() {
+	m.attachMu.Lock()
+	defer m.attachMu.Unlock()
+	want := m.wantLines()
+	if want == m.attached {
+		return
+	}
+	m.attached = want
+	for name := range m.worlds {
+		if want {
+			m.addOutput(name)
+		} else {
+			m.removeOutput(name)
+		}
+	}
+}
+
+// addOutput adds an output to the connection which passes each line on to
+// the plugins.
+func (m *Manager) addOutput(name string) {
+	conn, ok := m.conn(name)
+	if !ok {
+		log.Warningf("unable to find connection %s to pass on lines from", name)
+		return
+	}
+	conn.AddOutput("plugins", &lineWriter{m: m, world: name}, false)
+}
+
+// removeOutput removes the output added by addOutput.
+func (m *Manager) removeOutput(name string) {
+	if conn, ok := m.conn(name); ok {
+		conn.RemoveOutput("plugins")
+	}
+}
+
+// lineWriter is a connection output which sends each line written to it to
+// the plugins that want them.
+type lineWriter struct {
+	m     *Manager
+	world string
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	w.m.each(func(p *plugin) {
+		if !p.config.Lines {
+			return
+		}
+		for _, line := range lines {
+			p.notify("line", lineParams{World: w.world, Line: line})
+		}
+	})
+	return len(b), nil
+}
+
+func (w *lineWriter) Close() error {
+	return nil
+}
+
+// New creates a new plugin manager, which listens for signals but doesn't
+// start any plugins until Start is called. Connections are looked up by name
+// using conn.
+func New(cfg *config.Config, env *signal.Dispatcher, conn func(string) (*connection.Connection, bool)) *Manager {
+	m := &Manager{
+		config:   cfg,
+		env:      env,
+		conn:     conn,
+		listener: make(chan signal.Signal),
+		plugins:  map[string]*plugin{},
+		worlds:   map[string]bool{},
+	}
+	go m.listen()
+	env.AddListener("plugins", m.listener)
+	return m
+}
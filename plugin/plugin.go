@@ -0,0 +1,276 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+const (
+	// How many messages may be waiting to be sent to a plugin before any more
+	// are dropped.
+	queueSize = 256
+
+	// How long a plugin has to exit once its stdin is closed before it is
+	// killed.
+	stopTimeout = 2 * time.Second
+
+	// The longest line a plugin may send.
+	maxMessageSize = 1024 * 1024
+)
+
+// plugin is a single running plugin.
+type plugin struct {
+	config config.Plugin
+	env    *signal.Dispatcher
+	cmd    *exec.Cmd
+
+	// Messages waiting to be written to the plugin's stdin.
+	queue chan []byte
+
+	// stopping is closed when the plugin should stop, and done once it has.
+	stopping chan bool
+	done     chan bool
+	stopOnce sync.Once
+
+	// Called once the plugin has exited and been cleaned up after.
+	exited func()
+
+	// The commands the plugin has registered.
+	commandsMu sync.Mutex
+	commands   []string
+}
+
+// start starts the plugin's program and begins talking to it.
+func (p *plugin) start() error {
+	command, err := homedir.Expand(p.config.Command)
+	if err != nil {
+		return err
+	}
+	p.cmd = exec.Command(command, p.config.Args...)
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	p.cmd.Stderr = &stderrLogger{name: p.config.Name}
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	log.Infof("started plugin %s", p.config.Name)
+	go p.write(stdin)
+	go p.read(stdout)
+	go p.wait()
+	return nil
+}
+
+// stop closes the plugin's stdin, which should be its cue to exit, killing it
+// if it doesn't do so in time.
+func (p *plugin) stop() {
+	p.stopOnce.Do(func() { close(p.stopping) })
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		log.Warningf("plugin %s didn't exit, killing it", p.config.Name)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// wait waits for the plugin to exit, then cleans up after it.
+func (p *plugin) wait() {
+	err := p.cmd.Wait()
+	if err != nil {
+		log.Errorf("plugin %s exited. %v", p.config.Name, err)
+	} else {
+		log.Infof("plugin %s exited", p.config.Name)
+	}
+	p.stopOnce.Do(func() { close(p.stopping) })
+	p.commandsMu.Lock()
+	for _, name := range p.commands {
+		p.env.RemoveHandler(name)
+	}
+	p.commands = nil
+	p.commandsMu.Unlock()
+	if p.exited != nil {
+		p.exited()
+	}
+	close(p.done)
+}
+
+// write writes queued messages to the plugin until it's stopped.
+func (p *plugin) write(stdin io.WriteCloser) {
+	defer stdin.Close()
+	for {
+		select {
+		case <-p.stopping:
+			return
+		case msg := <-p.queue:
+			if _, err := stdin.Write(append(msg, '\n')); err != nil {
+				log.Errorf("unable to write to plugin %s. %v", p.config.Name, err)
+				return
+			}
+		}
+	}
+}
+
+// send queues a message for the plugin, dropping it if the plugin isn't
+// keeping up.
+func (p *plugin) send(v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("unable to encode message for plugin %s. %v", p.config.Name, err)
+		return
+	}
+	select {
+	case <-p.stopping:
+	case p.queue <- msg:
+	default:
+		log.Warningf("plugin %s isn't keeping up, dropping %s", p.config.Name, msg)
+	}
+}
+
+// notify sends the plugin a notification.
+func (p *plugin) notify(method string, params interface{}) {
+	p.send(notification{
+		JSONRPC: jsonrpcVersion,
+		Method:  method,
+		Params:  params,
+	})
+}
+
+// read reads requests from the plugin's stdout, one per line, and responds
+// to any which have an ID.
+func (p *plugin) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+	for scanner.Scan() {
+		var req request
+		var result interface{}
+		var rpcErr *rpcError
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			rpcErr = &rpcError{Code: errParse, Message: err.Error()}
+			req.ID = json.RawMessage("null")
+		} else {
+			log.Tracef("plugin %s requested %s", p.config.Name, req.Method)
+			result, rpcErr = p.handle(req)
+		}
+		if len(req.ID) == 0 {
+			if rpcErr != nil {
+				log.Warningf("plugin %s sent a bad notification. %s", p.config.Name, rpcErr.Message)
+			}
+			continue
+		}
+		if rpcErr != nil {
+			p.send(errorResponse{JSONRPC: jsonrpcVersion, ID: req.ID, Error: rpcErr})
+		} else {
+			p.send(response{JSONRPC: jsonrpcVersion, ID: req.ID, Result: result})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Errorf("unable to read from plugin %s. %v", p.config.Name, err)
+	}
+}
+
+// handle carries out a request from the plugin.
+func (p *plugin) handle(req request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "send", "echo":
+		var params sendParams
+		if err := p.unmarshal(req, &params); err != nil {
+			return nil, err
+		}
+		p.env.Deliver(signal.Signal{
+			Name:    "_client:" + req.Method,
+			Payload: []string{params.World, params.Text},
+		})
+	case "register_command":
+		var params registerCommandParams
+		if err := p.unmarshal(req, &params); err != nil {
+			return nil, err
+		}
+		if err := p.registerCommand(params.Name); err != nil {
+			return nil, &rpcError{Code: errServer, Message: err.Error()}
+		}
+	case "show_modal":
+		var params showModalParams
+		if err := p.unmarshal(req, &params); err != nil {
+			return nil, err
+		}
+		go p.env.Dispatch("_client:showModal", fmt.Sprintf("%s::\n%s", params.Title, params.Content))
+	case "dispatch":
+		var params dispatchParams
+		if err := p.unmarshal(req, &params); err != nil {
+			return nil, err
+		}
+		go p.env.Dispatch(params.Name, params.Args)
+	default:
+		return nil, &rpcError{Code: errMethodNotFound, Message: fmt.Sprintf("unknown method %s", req.Method)}
+	}
+	return true, nil
+}
+
+// unmarshal unmarshals a request's params.
+func (p *plugin) unmarshal(req request, params interface{}) *rpcError {
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &rpcError{Code: errInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// registerCommand adds a command which, when run, sends the plugin a command
+// notification.
+func (p *plugin) registerCommand(name string) error {
+	err := p.env.AddHandler(name, func(args string) ([]string, error) {
+		p.notify("command", commandParams{Name: name, Args: args})
+		return []string{args}, nil
+	})
+	if err != nil {
+		return err
+	}
+	p.commandsMu.Lock()
+	defer p.commandsMu.Unlock()
+	p.commands = append(p.commands, name)
+	return nil
+}
+
+// stderrLogger logs anything a plugin writes to stderr.
+type stderrLogger struct {
+	name string
+}
+
+func (l *stderrLogger) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		log.Infof("plugin %s: %s", l.name, line)
+	}
+	return len(b), nil
+}
+
+func newPlugin(cfg config.Plugin, env *signal.Dispatcher) *plugin {
+	return &plugin{
+		config:   cfg,
+		env:      env,
+		queue:    make(chan []byte, queueSize),
+		stopping: make(chan bool),
+		done:     make(chan bool),
+	}
+}
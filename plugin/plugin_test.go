@@ -0,0 +1,152 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package plugin_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/connection"
+	"github.com/makyo/stimmtausch/plugin"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// companion is a plugin which registers a command, tries a method which
+// doesn't exist, and keeps track of everything it's sent.
+const companion = `#!/bin/sh
+echo '{"jsonrpc":"2.0","id":1,"method":"register_command","params":{"name":"tardis"}}'
+echo '{"jsonrpc":"2.0","id":2,"method":"regenerate","params":{}}'
+while read -r line; do
+  echo "$line" >> "$1"
+  case "$line" in
+    *'"method":"command"'*)
+      echo '{"jsonrpc":"2.0","method":"send","params":{"world":"rose","text":"allons-y"}}' ;;
+    *'"method":"line"'*)
+      echo '{"jsonrpc":"2.0","method":"show_modal","params":{"title":"Line","content":"got a line"}}' ;;
+  esac
+done
+`
+
+// waitForSignal waits for a signal with the given name on the listener,
+// skipping any others.
+func waitForSignal(listener chan signal.Signal, name string) (signal.Signal, bool) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-listener:
+			if s.Name == name {
+				return s, true
+			}
+		case <-deadline:
+			return signal.Signal{}, false
+		}
+	}
+}
+
+// waitForReceived waits for the plugin to have received a message containing
+// the given string.
+func waitForReceived(received, contains string) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, _ := os.ReadFile(received)
+		if strings.Contains(string(b), contains) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPlugins(t *testing.T) {
+	Convey("When running plugins", t, func() {
+		dir := t.TempDir()
+		command := filepath.Join(dir, "companion.sh")
+		received := filepath.Join(dir, "received")
+		So(os.WriteFile(command, []byte(companion), 0755), ShouldBeNil)
+		cfg := &config.Config{
+			Plugins: map[string]config.Plugin{
+				"companion": config.Plugin{
+					Name:    "companion",
+					Command: command,
+					Args:    []string{received},
+					Signals: []string{"_client:loggedIn"},
+					Lines:   true,
+				},
+			},
+			WorkingDir: filepath.Join(dir, "share"),
+			LogDir:     filepath.Join(dir, "log"),
+		}
+		env := signal.NewDispatcher()
+		listener := make(chan signal.Signal)
+		env.AddListener("test", listener)
+		conn, err := connection.NewConnection("rose", config.World{Name: "rose"}, config.Server{Host: "127.0.0.1"}, cfg, env)
+		So(err, ShouldBeNil)
+		m := plugin.New(cfg, env, func(name string) (*connection.Connection, bool) {
+			return conn, name == "rose"
+		})
+		m.Start()
+		defer m.Stop()
+		So(waitForReceived(received, `{"jsonrpc":"2.0","id":1,"result":true}`), ShouldBeTrue)
+
+		Convey("They can register commands", func() {
+			go env.Dispatch("tardis", "Gallifrey")
+			So(waitForReceived(received, `{"jsonrpc":"2.0","method":"command","params":{"name":"tardis","args":"Gallifrey"}}`), ShouldBeTrue)
+			res, ok := waitForSignal(listener, "_client:send")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"rose", "allons-y"})
+
+			Convey("Which are removed when they stop", func() {
+				m.Stop()
+				go env.Dispatch("tardis", "Gallifrey")
+				res, ok := waitForSignal(listener, "tardis")
+				So(ok, ShouldBeTrue)
+				So(res.Err.Error(), ShouldEqual, "unknown macro tardis")
+			})
+		})
+
+		Convey("They are told about bad requests", func() {
+			So(waitForReceived(received, `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"unknown method regenerate"}}`), ShouldBeTrue)
+		})
+
+		Convey("They are sent the signals they want", func() {
+			go env.Dispatch("_", "Bad Wolf")
+			go env.Dispatch("_client:loggedIn", "rose")
+			So(waitForReceived(received, `{"jsonrpc":"2.0","method":"signal","params":{"name":"_client:loggedIn","payload":["rose"]}}`), ShouldBeTrue)
+			b, err := os.ReadFile(received)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldNotContainSubstring, "Bad Wolf")
+		})
+
+		Convey("They are sent lines from worlds", func() {
+			env.DirectDispatch(signal.Signal{Name: "_client:connect", Payload: []string{"rose"}})
+			// The output is attached in the background, so keep trying.
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				conn.Echo("\x1b[1mHello\x1b[0m, Doctor.")
+				if b, _ := os.ReadFile(received); strings.Contains(string(b), `"method":"line"`) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(waitForReceived(received, `{"jsonrpc":"2.0","method":"line","params":{"world":"rose","line":"Hello, Doctor."}}`), ShouldBeTrue)
+			res, ok := waitForSignal(listener, "_client:showModal")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"Line", "got a line"})
+
+			Convey("Until they stop", func() {
+				m.Stop()
+				So(conn.RemoveOutput("plugins"), ShouldBeFalse)
+			})
+		})
+	})
+}
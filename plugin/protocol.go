@@ -0,0 +1,95 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package plugin
+
+import "encoding/json"
+
+// Plugins speak JSON-RPC 2.0, one message per line.
+const jsonrpcVersion = "2.0"
+
+// JSON-RPC error codes.
+const (
+	errParse          = -32700
+	errMethodNotFound = -32601
+	errInvalidParams  = -32602
+	errServer         = -32000
+)
+
+// request is a request (or, without an ID, a notification) from a plugin.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// notification is a message sent to a plugin, which it needn't respond to.
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// response is the successful result of a plugin's request.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+// errorResponse is the result of a plugin's request which failed.
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Params for notifications sent to plugins.
+type (
+	signalParams struct {
+		Name    string   `json:"name"`
+		Payload []string `json:"payload"`
+		Error   string   `json:"error,omitempty"`
+	}
+
+	lineParams struct {
+		World string `json:"world"`
+		Line  string `json:"line"`
+	}
+
+	commandParams struct {
+		Name string `json:"name"`
+		Args string `json:"args"`
+	}
+)
+
+// Params for requests from plugins.
+type (
+	sendParams struct {
+		World string `json:"world"`
+		Text  string `json:"text"`
+	}
+
+	registerCommandParams struct {
+		Name string `json:"name"`
+	}
+
+	showModalParams struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+
+	dispatchParams struct {
+		Name string `json:"name"`
+		Args string `json:"args"`
+	}
+)
@@ -0,0 +1,274 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package scripting
+
+import (
+	"fmt"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+// builtin is a function in the `st` module. It's passed the name of the
+// script it was called from.
+type builtin func(r *Runtime, script string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var api = map[string]builtin{
+	"send":           send,
+	"echo":           echo,
+	"dispatch":       dispatch,
+	"connections":    connections,
+	"add_trigger":    addTrigger,
+	"remove_trigger": removeTrigger,
+	"command":        command,
+	"after":          after,
+	"every":          every,
+	"cancel":         cancel,
+}
+
+// module returns the `st` module for the given script.
+func (r *Runtime) module(script string) *starlarkstruct.Module {
+	members := starlark.StringDict{}
+	for name, f := range api {
+		f := f
+		members[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return f(r, script, args, kwargs)
+		})
+	}
+	return &starlarkstruct.Module{
+		Name:    "st",
+		Members: members,
+	}
+}
+
+// send sends a line to the given world, or the current one: send(text, world="")
+func send(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text, world string
+	if err := starlark.UnpackArgs("send", args, kwargs, "text", &text, "world?", &world); err != nil {
+		return nil, err
+	}
+	r.env.Deliver(signal.Signal{
+		Name:    "_client:send",
+		Payload: []string{world, text},
+	})
+	return starlark.None, nil
+}
+
+// echo shows a line to the user in the given world, or the current one, without
+// sending it: echo(text, world="")
+func echo(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text, world string
+	if err := starlark.UnpackArgs("echo", args, kwargs, "text", &text, "world?", &world); err != nil {
+		return nil, err
+	}
+	r.env.Deliver(signal.Signal{
+		Name:    "_client:echo",
+		Payload: []string{world, text},
+	})
+	return starlark.None, nil
+}
+
+// dispatch runs a command as if it had been typed with a slash in front of it:
+// dispatch(name, args="")
+func dispatch(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, cmdArgs string
+	if err := starlark.UnpackArgs("dispatch", args, kwargs, "name", &name, "args?", &cmdArgs); err != nil {
+		return nil, err
+	}
+	// This is done in the background, as the command may well be one from a
+	// script, which can't run until this one is done.
+	go r.env.Dispatch(name, cmdArgs)
+	return starlark.None, nil
+}
+
+// connections returns a list of the names of all connections: connections()
+func connections(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs("connections", args, kwargs); err != nil {
+		return nil, err
+	}
+	var names []starlark.Value
+	for _, name := range r.connNames() {
+		names = append(names, starlark.String(name))
+	}
+	return starlark.NewList(names), nil
+}
+
+// addTrigger adds a trigger which calls fn(world, line, matches) whenever
+// match matches a line, where matches is a list of the full match and its
+// capture groups for each match. The trigger may expire after some time or
+// number of fires: add_trigger(name, match, fn, world="", expires_after="",
+// max_fires=0, one_shot=False)
+func addTrigger(r *Runtime, script string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, match, world, expiresAfter string
+	var maxFires int
+	var oneShot bool
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs("add_trigger", args, kwargs, "name", &name, "match", &match, "fn", &fn, "world?", &world,
+		"expires_after?", &expiresAfter, "max_fires?", &maxFires, "one_shot?", &oneShot); err != nil {
+		return nil, err
+	}
+	err := r.config.AddTrigger(config.Trigger{
+		Name:         name,
+		Type:         "callback",
+		World:        world,
+		Match:        match,
+		ExpiresAfter: expiresAfter,
+		MaxFires:     maxFires,
+		OneShot:      oneShot,
+		Callback: func(world, line string, matches [][]string) {
+			var matchList []starlark.Value
+			for _, m := range matches {
+				var groups []starlark.Value
+				for _, group := range m {
+					groups = append(groups, starlark.String(group))
+				}
+				matchList = append(matchList, starlark.NewList(groups))
+			}
+			if _, err := r.call(script, fn, starlark.String(world), starlark.String(line), starlark.NewList(matchList)); err != nil {
+				log.Errorf("trigger %s from %s failed. %v", name, script, err)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.triggers = append(r.triggers, name)
+	return starlark.None, nil
+}
+
+// removeTrigger removes a trigger added by a script, returning whether or not
+// there was one: remove_trigger(name)
+func removeTrigger(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs("remove_trigger", args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	for i, t := range r.triggers {
+		if t == name {
+			r.triggers = append(r.triggers[:i], r.triggers[i+1:]...)
+			return starlark.Bool(r.config.RemoveTrigger(name)), nil
+		}
+	}
+	return starlark.False, nil
+}
+
+// command adds a command, so that typing /name calls fn with everything after
+// the name: command(name, fn)
+func command(r *Runtime, script string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs("command", args, kwargs, "name", &name, "fn", &fn); err != nil {
+		return nil, err
+	}
+	err := r.env.AddHandler(name, func(cmdArgs string) ([]string, error) {
+		if _, err := r.call(script, fn, starlark.String(cmdArgs)); err != nil {
+			return []string{cmdArgs}, fmt.Errorf("command %s from %s failed. %v", name, script, err)
+		}
+		return []string{cmdArgs}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.commands = append(r.commands, name)
+	return starlark.None, nil
+}
+
+// after calls fn() once after the given number of seconds, returning an ID
+// which may be passed to cancel: after(seconds, fn)
+func after(r *Runtime, script string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seconds starlark.Value
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs("after", args, kwargs, "seconds", &seconds, "fn", &fn); err != nil {
+		return nil, err
+	}
+	d, err := duration("after", seconds)
+	if err != nil {
+		return nil, err
+	}
+	id := r.addTimer(func(id int64, stopped chan bool) {
+		select {
+		case <-stopped:
+		case <-time.After(d):
+			r.runTimer(script, fn)
+			r.mu.Lock()
+			delete(r.timers, id)
+			r.mu.Unlock()
+		}
+	})
+	return starlark.MakeInt64(id), nil
+}
+
+// every calls fn() every given number of seconds until cancelled, returning
+// an ID which may be passed to cancel: every(seconds, fn)
+func every(r *Runtime, script string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seconds starlark.Value
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs("every", args, kwargs, "seconds", &seconds, "fn", &fn); err != nil {
+		return nil, err
+	}
+	d, err := duration("every", seconds)
+	if err != nil {
+		return nil, err
+	}
+	id := r.addTimer(func(_ int64, stopped chan bool) {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				r.runTimer(script, fn)
+			}
+		}
+	})
+	return starlark.MakeInt64(id), nil
+}
+
+// cancel stops a timer started with after or every, returning whether or not
+// there was one: cancel(id)
+func cancel(r *Runtime, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id int64
+	if err := starlark.UnpackArgs("cancel", args, kwargs, "id", &id); err != nil {
+		return nil, err
+	}
+	stop, ok := r.timers[id]
+	if ok {
+		stop()
+		delete(r.timers, id)
+	}
+	return starlark.Bool(ok), nil
+}
+
+// addTimer starts the timer in the background, returning its ID.
+func (r *Runtime) addTimer(run func(id int64, stopped chan bool)) int64 {
+	r.nextTimer++
+	stopped := make(chan bool)
+	r.timers[r.nextTimer] = func() { close(stopped) }
+	go run(r.nextTimer, stopped)
+	return r.nextTimer
+}
+
+// runTimer calls the function for a timer, logging any errors.
+func (r *Runtime) runTimer(script string, fn starlark.Callable) {
+	if _, err := r.call(script, fn); err != nil {
+		log.Errorf("timer from %s failed. %v", script, err)
+	}
+}
+
+// duration turns a number of seconds into a duration.
+func duration(fnname string, seconds starlark.Value) (time.Duration, error) {
+	f, ok := starlark.AsFloat(seconds)
+	if !ok || f <= 0 {
+		return 0, fmt.Errorf("%s: seconds must be a positive number, got %s", fnname, seconds)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
@@ -0,0 +1,141 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+// Package scripting runs user scripts written in Starlark, a dialect of
+// Python, giving them access to Stimmtausch through the `st` module.
+package scripting
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/loggo"
+	"go.starlark.net/starlark"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+var log = loggo.GetLogger("stimmtausch.scripting")
+
+// Runtime holds all of the loaded scripts along with everything they've set
+// up, so that it can all be torn down again on reload.
+type Runtime struct {
+	config    *config.Config
+	env       *signal.Dispatcher
+	connNames func() []string
+
+	// mu is held while any script is running, so that scripts can keep state
+	// without worrying about being run from several goroutines at once. It
+	// also guards everything below.
+	mu sync.Mutex
+
+	// The commands and triggers scripts have added.
+	commands []string
+	triggers []string
+
+	// The timers scripts have started, by ID.
+	timers    map[int64]func()
+	nextTimer int64
+}
+
+// dir returns the directory scripts are loaded from.
+func (r *Runtime) dir() string {
+	return filepath.Join(r.config.ConfigDir, "scripts")
+}
+
+// Load loads every script (files ending in .star) in the scripts directory,
+// first unloading anything loaded before. Errors are logged as well as
+// returned, and don't stop the rest of the scripts from loading.
+func (r *Runtime) Load() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unload()
+
+	files, err := filepath.Glob(filepath.Join(r.dir(), "*.star"))
+	if err != nil {
+		log.Errorf("unable to find scripts in %s. %v", r.dir(), err)
+		return []error{err}
+	}
+	var errs []error
+	for _, file := range files {
+		log.Tracef("loading script %s", file)
+		if err := r.loadFile(file); err != nil {
+			log.Errorf("unable to load script %s. %v", file, err)
+			errs = append(errs, err)
+		}
+	}
+	log.Debugf("loaded %d scripts", len(files)-len(errs))
+	return errs
+}
+
+// loadFile runs a single script, which is given its own state dict.
+func (r *Runtime) loadFile(file string) error {
+	name := filepath.Base(file)
+	predeclared := starlark.StringDict{
+		"st":    r.module(name),
+		"state": starlark.NewDict(0),
+	}
+	_, err := r.run(name, func(thread *starlark.Thread) (starlark.Value, error) {
+		_, err := starlark.ExecFile(thread, file, nil, predeclared)
+		return starlark.None, err
+	})
+	return err
+}
+
+// unload removes every command, trigger, and timer scripts have added.
+func (r *Runtime) unload() {
+	for _, name := range r.commands {
+		r.env.RemoveHandler(name)
+	}
+	r.commands = nil
+	for _, name := range r.triggers {
+		r.config.RemoveTrigger(name)
+	}
+	r.triggers = nil
+	for _, stop := range r.timers {
+		stop()
+	}
+	r.timers = map[int64]func(){}
+}
+
+// call calls a function from a script, waiting for any other script to
+// finish first.
+func (r *Runtime) call(name string, fn starlark.Callable, args ...starlark.Value) (starlark.Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.run(name, func(thread *starlark.Thread) (starlark.Value, error) {
+		return starlark.Call(thread, fn, args, nil)
+	})
+}
+
+// run runs f with a new thread, cancelling it if it takes too long.
+func (r *Runtime) run(name string, f func(*starlark.Thread) (starlark.Value, error)) (starlark.Value, error) {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Infof("%s: %s", name, msg)
+		},
+	}
+	timeout := time.Duration(r.config.Client.Scripts.Timeout) * time.Second
+	timer := time.AfterFunc(timeout, func() {
+		thread.Cancel(fmt.Sprintf("took longer than %v", timeout))
+	})
+	defer timer.Stop()
+	return f(thread)
+}
+
+// New creates a new scripting runtime. Nothing is loaded until Load is called.
+func New(cfg *config.Config, env *signal.Dispatcher, connNames func() []string) *Runtime {
+	return &Runtime{
+		config:    cfg,
+		env:       env,
+		connNames: connNames,
+		timers:    map[int64]func(){},
+	}
+}
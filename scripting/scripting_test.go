@@ -0,0 +1,155 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package scripting_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/config"
+	"github.com/makyo/stimmtausch/scripting"
+	"github.com/makyo/stimmtausch/signal"
+)
+
+const companion = `
+def greet(args):
+    state["greeted"] = state.get("greeted", 0) + 1
+    st.send("wave " + args)
+    st.echo("greeted %d" % state["greeted"], world = "tardis")
+
+st.command("greet", greet)
+
+def on_doctor(world, line, matches):
+    st.send("follow " + matches[0][1], world = world)
+
+st.add_trigger("doctor", "(Doctor) enters", on_doctor)
+st.after(0.01, lambda: st.send(", ".join(st.connections())))
+`
+
+// waitForSignal waits for a signal with the given name on the listener,
+// skipping any others.
+func waitForSignal(listener chan signal.Signal, name string) (signal.Signal, bool) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-listener:
+			if s.Name == name {
+				return s, true
+			}
+		case <-deadline:
+			return signal.Signal{}, false
+		}
+	}
+}
+
+func TestScripting(t *testing.T) {
+	Convey("When running scripts", t, func() {
+		dir := t.TempDir()
+		So(os.Mkdir(filepath.Join(dir, "scripts"), 0755), ShouldBeNil)
+		write := func(name, src string) {
+			So(os.WriteFile(filepath.Join(dir, "scripts", name), []byte(src), 0644), ShouldBeNil)
+		}
+		cfg := &config.Config{
+			ConfigDir: dir,
+			Client: config.Client{
+				Scripts: config.Scripts{Timeout: 1},
+			},
+		}
+		env := signal.NewDispatcher()
+		listener := make(chan signal.Signal)
+		env.AddListener("test", listener)
+		r := scripting.New(cfg, env, func() []string { return []string{"rose", "tardis"} })
+
+		Convey("They can use the API", func() {
+			write("companion.star", companion)
+			So(r.Load(), ShouldBeEmpty)
+
+			res, ok := waitForSignal(listener, "_client:send")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"", "rose, tardis"})
+
+			Convey("Adding commands, which keep state", func() {
+				var echo signal.Signal
+				for i := 0; i < 2; i++ {
+					go env.Dispatch("greet", "Rose")
+					res, ok := waitForSignal(listener, "_client:send")
+					So(ok, ShouldBeTrue)
+					So(res.Payload, ShouldResemble, []string{"", "wave Rose"})
+					echo, ok = waitForSignal(listener, "_client:echo")
+					So(ok, ShouldBeTrue)
+					So(echo.Payload[0], ShouldEqual, "tardis")
+				}
+				So(echo.Payload[1], ShouldEqual, "greeted 2")
+			})
+
+			Convey("Adding triggers", func() {
+				triggers := cfg.TriggerList()
+				So(len(triggers), ShouldEqual, 1)
+				applies, _, errs := triggers[0].Run("rose", "The Doctor enters.", cfg)
+				So(applies, ShouldBeTrue)
+				So(errs, ShouldBeEmpty)
+				go triggers[0].Callback("rose", "The Doctor enters.", triggers[0].Submatches("The Doctor enters."))
+				res, ok := waitForSignal(listener, "_client:send")
+				So(ok, ShouldBeTrue)
+				So(res.Payload, ShouldResemble, []string{"rose", "follow Doctor"})
+			})
+
+			Convey("All of which is removed when reloading", func() {
+				So(os.Remove(filepath.Join(dir, "scripts", "companion.star")), ShouldBeNil)
+				So(r.Load(), ShouldBeEmpty)
+				So(cfg.TriggerList(), ShouldBeEmpty)
+				go env.Dispatch("greet", "Rose")
+				res, ok := waitForSignal(listener, "greet")
+				So(ok, ShouldBeTrue)
+				So(res.Err.Error(), ShouldEqual, "unknown macro greet")
+			})
+		})
+
+		Convey("Timers can be cancelled", func() {
+			write("timer.star", `
+id = st.every(0.01, lambda: st.send("tick"))
+st.cancel(id)
+st.after(0.05, lambda: st.send("done"))
+`)
+			So(r.Load(), ShouldBeEmpty)
+			res, ok := waitForSignal(listener, "_client:send")
+			So(ok, ShouldBeTrue)
+			So(res.Payload, ShouldResemble, []string{"", "done"})
+		})
+
+		Convey("Errors don't stop other scripts from loading", func() {
+			write("a.star", "st.send(\"nope\"")
+			write("b.star", "st.command(\"hello\", lambda args: None)")
+			errs := r.Load()
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Error(), ShouldContainSubstring, "a.star")
+			go env.Dispatch("hello", "")
+			res, ok := waitForSignal(listener, "hello")
+			So(ok, ShouldBeTrue)
+			So(res.Err, ShouldBeNil)
+		})
+
+		Convey("Scripts which take too long are stopped", func() {
+			write("slow.star", `
+def slow(args):
+    for i in range(1000000000):
+        pass
+
+st.command("slow", slow)
+`)
+			So(r.Load(), ShouldBeEmpty)
+			go env.Dispatch("slow", "")
+			res, ok := waitForSignal(listener, "slow")
+			So(ok, ShouldBeTrue)
+			So(res.Err.Error(), ShouldContainSubstring, "took longer than 1s")
+		})
+	})
+}
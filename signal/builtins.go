@@ -8,6 +8,7 @@ package signal
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/juju/loggo"
@@ -33,6 +34,23 @@ var builtins = map[string]func(string) ([]string, error){
 	// Logging
 	"log": partsPassthrough,
 
+	// Aliases
+	"alias":   aliasSplit,
+	"unalias": passthrough,
+
+	// Triggers
+	"trigger": triggerSplit,
+
+	// Notifications
+	"dnd": passthrough,
+
+	// Watch list
+	"watch":   watchSplit,
+	"unwatch": watchSplit,
+
+	// Statistics
+	"stats": passthrough,
+
 	// Help
 	"help": passthrough,
 
@@ -45,6 +63,8 @@ var builtins = map[string]func(string) ([]string, error){
 	"_util:split":             split,
 	"_client:connected":       passthrough,
 	"_client:disconnected":    passthrough,
+	"_client:reconnected":     passthrough,
+	"_client:loggedIn":        passthrough,
 	"_client:allDisconnected": passthrough,
 	"_client:showModal":       titleSplit,
 	"_client:removeWorld":     passthrough,
@@ -81,6 +101,121 @@ func syslog(args string) ([]string, error) {
 	return parts, nil
 }
 
+// aliasSplit splits the arguments to /alias into the name, pattern, and
+// replacement, or nothing at all if there aren't any.
+func aliasSplit(args string) ([]string, error) {
+	if args == "" {
+		return []string{}, nil
+	}
+	parts := wsRE.Split(args, 2)
+	if len(parts) == 2 {
+		parts = append(parts[:1], strings.SplitN(parts[1], " => ", 2)...)
+	}
+	if len(parts) != 3 {
+		return parts, fmt.Errorf("usage: /alias <name> <pattern> => <replacement>")
+	}
+	return parts, nil
+}
+
+// watchUsage describes how to use /watch and /unwatch.
+const watchUsage = "usage: /watch [--world <world>] [<name>] or /unwatch [--world <world>] <name>"
+
+// watchSplit splits the arguments to /watch or /unwatch into the world (if
+// given) and the name of the character (if any).
+func watchSplit(args string) ([]string, error) {
+	world := ""
+	if strings.HasPrefix(args, "--") {
+		parts := wsRE.Split(args, 3)
+		if parts[0] != "--world" || len(parts) < 2 {
+			return parts, fmt.Errorf(watchUsage)
+		}
+		world, args = parts[1], ""
+		if len(parts) == 3 {
+			args = parts[2]
+		}
+	}
+	if wsRE.MatchString(args) {
+		return []string{world, args}, fmt.Errorf(watchUsage)
+	}
+	return []string{world, args}, nil
+}
+
+// triggerUsage describes how to use /trigger.
+const triggerUsage = "usage: /trigger [list|add|enable|disable|remove|save|test] ...; see /help trigger"
+
+// triggerSplit splits the arguments to /trigger into the subcommand and its
+// arguments. For add, those are the world (if any), name, type, pattern, value
+// (if any), how long the trigger lasts (if limited), and how many times it may
+// fire (if limited); for test, the world (if any) and the line to test.
+func triggerSplit(args string) ([]string, error) {
+	if args == "" {
+		return []string{"list"}, nil
+	}
+	parts := wsRE.Split(args, 2)
+	sub, rest := parts[0], ""
+	if len(parts) == 2 {
+		rest = parts[1]
+	}
+	// Options come before the rest of the arguments.
+	world, expiresAfter, maxFires := "", "", ""
+	for (sub == "add" || sub == "test") && strings.HasPrefix(rest, "--") {
+		optParts := wsRE.Split(rest, 2)
+		opt, value := optParts[0], ""
+		if opt != "--once" {
+			optParts = wsRE.Split(rest, 3)
+			if len(optParts) != 3 {
+				return parts, fmt.Errorf(triggerUsage)
+			}
+			value = optParts[1]
+		}
+		rest = optParts[len(optParts)-1]
+		if len(optParts) == 1 {
+			rest = ""
+		}
+		switch {
+		case opt == "--world":
+			world = value
+		case opt == "--for" && sub == "add":
+			expiresAfter = value
+		case opt == "--times" && sub == "add":
+			if _, err := strconv.Atoi(value); err != nil {
+				return parts, fmt.Errorf("the number of times a trigger may fire must be a number, not %s", value)
+			}
+			maxFires = value
+		case opt == "--once" && sub == "add":
+			maxFires = "1"
+		default:
+			return parts, fmt.Errorf(triggerUsage)
+		}
+	}
+	switch sub {
+	case "list", "save":
+		return []string{sub}, nil
+	case "enable", "disable", "remove":
+		if rest == "" {
+			return parts, fmt.Errorf("usage: /trigger %s <name>", sub)
+		}
+		return []string{sub, rest}, nil
+	case "test":
+		if rest == "" {
+			return parts, fmt.Errorf("usage: /trigger test [--world <world>] <line>")
+		}
+		return []string{sub, world, rest}, nil
+	case "add":
+		addParts := wsRE.Split(rest, 3)
+		if len(addParts) != 3 {
+			return parts, fmt.Errorf("usage: /trigger add [--world <world>] [--for <duration>] [--times <n>|--once] <name> <type> <pattern> [=> <value>]")
+		}
+		patternParts := strings.SplitN(addParts[2], " => ", 2)
+		if len(patternParts) == 1 {
+			patternParts = append(patternParts, "")
+		}
+		return []string{sub, world, addParts[0], addParts[1], patternParts[0], patternParts[1], expiresAfter, maxFires}, nil
+	default:
+		return parts, fmt.Errorf(triggerUsage)
+	}
+}
+
 // titleSplit passes on the given args after splitting on the title separator,
 // "::\n".
 func titleSplit(args string) ([]string, error) {
@@ -45,5 +45,102 @@ func TestBuiltins(t *testing.T) {
 			So(result.Payload, ShouldResemble, []string{"switch", "rose_tyler"})
 			So(result.Err, ShouldBeNil)
 		})
+
+		Convey("alias builtin", func() {
+			go e.Dispatch("alias", "page ^p (\\w+) (.*) => page $1=$2")
+			result := <-listener
+			So(result.Payload, ShouldResemble, []string{"page", "^p (\\w+) (.*)", "page $1=$2"})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("alias", "")
+			result = <-listener
+			So(result.Payload, ShouldBeEmpty)
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("alias", "page ^p")
+			result = <-listener
+			So(result.Err.Error(), ShouldEqual, "usage: /alias <name> <pattern> => <replacement>")
+		})
+
+		Convey("watch builtins", func() {
+			for _, name := range []string{"watch", "unwatch"} {
+				go e.Dispatch(name, "Martha")
+				result := <-listener
+				So(result.Payload, ShouldResemble, []string{"", "Martha"})
+				So(result.Err, ShouldBeNil)
+			}
+
+			go e.Dispatch("watch", "")
+			result := <-listener
+			So(result.Payload, ShouldResemble, []string{"", ""})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("watch", "--world rose Martha")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"rose", "Martha"})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("watch", "--world rose")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"rose", ""})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("unwatch", "Martha Jones")
+			result = <-listener
+			So(result.Err.Error(), ShouldEqual, "usage: /watch [--world <world>] [<name>] or /unwatch [--world <world>] <name>")
+
+			go e.Dispatch("watch", "--planet earth Martha")
+			result = <-listener
+			So(result.Err, ShouldNotBeNil)
+		})
+
+		Convey("trigger builtin", func() {
+			go e.Dispatch("trigger", "")
+			result := <-listener
+			So(result.Payload, ShouldResemble, []string{"list"})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "add --world rose doctor hilite (the )?Doctor => bold+cyan")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"add", "rose", "doctor", "hilite", "(the )?Doctor", "bold+cyan", "", ""})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "add dalek gag Dalek")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"add", "", "dalek", "gag", "Dalek", "", "", ""})
+
+			go e.Dispatch("trigger", "add --for 1h --times 5 --world rose rose hilite Rose => bold")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"add", "rose", "rose", "hilite", "Rose", "bold", "1h", "5"})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "add --once quiet gag .")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"add", "", "quiet", "gag", ".", "", "", "1"})
+			So(result.Err, ShouldBeNil)
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "disable dalek")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"disable", "dalek"})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "test Hello, Doctor.")
+			result = <-listener
+			So(result.Payload, ShouldResemble, []string{"test", "", "Hello, Doctor."})
+			So(result.Err, ShouldBeNil)
+
+			go e.Dispatch("trigger", "add dalek")
+			result = <-listener
+			So(result.Err.Error(), ShouldEqual, "usage: /trigger add [--world <world>] [--for <duration>] [--times <n>|--once] <name> <type> <pattern> [=> <value>]")
+
+			go e.Dispatch("trigger", "add --times lots quiet gag .")
+			result = <-listener
+			So(result.Err.Error(), ShouldEqual, "the number of times a trigger may fire must be a number, not lots")
+
+			go e.Dispatch("trigger", "exterminate")
+			result = <-listener
+			So(result.Err.Error(), ShouldStartWith, "usage: /trigger")
+		})
 	})
 }
@@ -10,13 +10,17 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/juju/loggo"
 )
 
 var (
-	wsRE        = regexp.MustCompile("\\s+")
-	macroNameRE = regexp.MustCompile("[[:alpha:]][[:word:]]*")
+	wsRE = regexp.MustCompile("\\s+")
+
+	// MacroNameRE matches valid macro names. Macros are called as /<name>,
+	// so their names can't have spaces in them.
+	MacroNameRE = regexp.MustCompile(`^[[:alpha:]][[:word:]-]*$`)
 
 	log = loggo.GetLogger("stimmtausch.macro")
 )
@@ -24,35 +28,54 @@ var (
 type Dispatcher struct {
 	// listeners is a list of channels to which send the results of handlers
 	// running.
-	listeners map[string]chan Signal
+	listeners   map[string]chan Signal
+	listenersMu sync.RWMutex
 
 	// handlers is a map from handler name to function.
-	handlers map[string]func(string) ([]string, error)
+	handlers   map[string]func(string) ([]string, error)
+	handlersMu sync.RWMutex
+
+	// macros is a map from macro name to the commands it runs.
+	macros   map[string][]string
+	macrosMu sync.RWMutex
 }
 
+// Dispatch runs the handler with the given name, falling back to a macro by
+// that name if there's no handler, and sends the result to all listeners.
 func (e *Dispatcher) Dispatch(name, args string) {
-	var result Signal
+	e.DirectDispatch(e.handle(name, args, "", 0))
+}
+
+// handle runs the handler or macro with the given name and returns the
+// resulting signal. The world is the one any lines sent by a macro will go to
+// (the current world if blank).
+func (e *Dispatcher) handle(name, args, world string, depth int) Signal {
 	args = strings.TrimSpace(args)
 	name = strings.TrimSpace(name)
-	if m, ok := e.handlers[name]; ok {
+	if m, ok := e.handler(name); ok {
 		results, err := m(args)
-		result = Signal{
+		return Signal{
 			Name:    name,
 			Payload: results,
 			Err:     err,
 		}
+	}
+	var err error
+	if _, ok := e.macro(name); ok {
+		err = e.runMacro(name, world, macroParams(args), depth)
 	} else {
-		// TODO here is where we'll fall back to trying macros.
-		result = Signal{
-			Name:    name,
-			Payload: []string{args},
-			Err:     fmt.Errorf("unknown macro %s", name),
-		}
+		err = fmt.Errorf("unknown macro %s", name)
+	}
+	return Signal{
+		Name:    name,
+		Payload: []string{args},
+		Err:     err,
 	}
-	e.DirectDispatch(result)
 }
 
 func (e *Dispatcher) DirectDispatch(result Signal) {
+	e.listenersMu.RLock()
+	defer e.listenersMu.RUnlock()
 	log.Tracef("dispatching %+v to %d listeners", result, len(e.listeners))
 	for whence, listener := range e.listeners {
 		go func(l chan Signal) { l <- result }(listener)
@@ -60,13 +83,70 @@ func (e *Dispatcher) DirectDispatch(result Signal) {
 	}
 }
 
+// Deliver sends the result to all listeners like DirectDispatch, but waits
+// until each has received it, so that signals sent one after another are
+// received in that order.
+func (e *Dispatcher) Deliver(result Signal) {
+	e.listenersMu.RLock()
+	log.Tracef("delivering %+v to %d listeners", result, len(e.listeners))
+	var wg sync.WaitGroup
+	for _, listener := range e.listeners {
+		wg.Add(1)
+		go func(l chan Signal) {
+			defer wg.Done()
+			l <- result
+		}(listener)
+	}
+	e.listenersMu.RUnlock()
+	wg.Wait()
+}
+
+// handler returns the handler with the given name.
+func (e *Dispatcher) handler(name string) (func(string) ([]string, error), bool) {
+	e.handlersMu.RLock()
+	defer e.handlersMu.RUnlock()
+	m, ok := e.handlers[name]
+	return m, ok
+}
+
+// AddHandler adds a handler with the given name, so long as there isn't
+// already one by that name.
+func (e *Dispatcher) AddHandler(name string, m func(string) ([]string, error)) error {
+	e.handlersMu.Lock()
+	defer e.handlersMu.Unlock()
+	if _, ok := e.handlers[name]; ok {
+		return fmt.Errorf("there is already a command named %s", name)
+	}
+	e.handlers[name] = m
+	return nil
+}
+
+// RemoveHandler removes the handler with the given name, unless it's a
+// builtin.
+func (e *Dispatcher) RemoveHandler(name string) {
+	e.handlersMu.Lock()
+	defer e.handlersMu.Unlock()
+	if _, ok := builtins[name]; ok {
+		log.Warningf("not removing builtin %s", name)
+		return
+	}
+	delete(e.handlers, name)
+}
+
 func (e *Dispatcher) AddListener(whence string, listener chan Signal) {
+	e.listenersMu.Lock()
+	defer e.listenersMu.Unlock()
 	e.listeners[whence] = listener
 }
 
 func NewDispatcher() *Dispatcher {
+	handlers := map[string]func(string) ([]string, error){}
+	for name, m := range builtins {
+		handlers[name] = m
+	}
 	return &Dispatcher{
-		handlers:  builtins,
+		handlers:  handlers,
 		listeners: map[string]chan Signal{},
+		macros:    map[string][]string{},
 	}
 }
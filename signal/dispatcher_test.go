@@ -1,6 +1,7 @@
 package signal_test
 
 import (
+	"fmt"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -39,5 +40,102 @@ func TestEnvironment(t *testing.T) {
 				So(m1.Err.Error(), ShouldEqual, "unknown macro bad-wolf")
 			})
 		})
+
+		Convey("One can add listeners while signals are being delivered", func() {
+			e := signal.NewDispatcher()
+			l := make(chan signal.Signal)
+			e.AddListener("l", l)
+			done := make(chan bool)
+			go func() {
+				for i := 0; i < 10; i++ {
+					e.Deliver(signal.Signal{Name: "_"})
+				}
+				done <- true
+			}()
+			for i := 0; i < 10; i++ {
+				e.AddListener(fmt.Sprintf("extra%d", i), make(chan signal.Signal, 10))
+				<-l
+			}
+			<-done
+		})
+
+		Convey("One can add and remove handlers", func() {
+			e := signal.NewDispatcher()
+			l := make(chan signal.Signal)
+			e.AddListener("l", l)
+			So(e.AddHandler("bad-wolf", func(args string) ([]string, error) {
+				return []string{"I create myself", args}, nil
+			}), ShouldBeNil)
+			So(e.AddHandler("connect", nil).Error(), ShouldEqual, "there is already a command named connect")
+			go e.Dispatch("bad-wolf", "Rose")
+			m := <-l
+			So(m.Payload, ShouldResemble, []string{"I create myself", "Rose"})
+
+			e.RemoveHandler("bad-wolf")
+			e.RemoveHandler("connect")
+			go e.Dispatch("bad-wolf", "Rose")
+			m = <-l
+			So(m.Err.Error(), ShouldEqual, "unknown macro bad-wolf")
+			go e.Dispatch("connect", "Rose")
+			m = <-l
+			So(m.Err, ShouldBeNil)
+
+			Convey("Without affecting other dispatchers", func() {
+				So(signal.NewDispatcher().AddHandler("bad-wolf", nil), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestMacros(t *testing.T) {
+
+	Convey("When working with macros", t, func() {
+		e := signal.NewDispatcher()
+		listener := make(chan signal.Signal)
+		e.AddListener("l", listener)
+		e.SetMacros(map[string][]string{
+			"greet":   []string{"/_ greeting $1", ":waves to $1 ($0) $$1"},
+			"call":    []string{"/greet $1 Tyler"},
+			"forever": []string{"/forever"},
+			"broken":  []string{"/bad-wolf", "never sent"},
+		})
+
+		Convey("Unknown commands fall back to macros", func() {
+			go e.Dispatch("greet", "Rose  Tyler")
+			res := <-listener
+			So(res.Name, ShouldEqual, "_")
+			So(res.Payload, ShouldResemble, []string{"greeting Rose"})
+			res = <-listener
+			So(res.Name, ShouldEqual, "_client:send")
+			So(res.Payload, ShouldResemble, []string{"", ":waves to Rose (Rose  Tyler) $1"})
+			res = <-listener
+			So(res.Name, ShouldEqual, "greet")
+			So(res.Err, ShouldBeNil)
+		})
+
+		Convey("They can be run with a world and params", func() {
+			errs := make(chan error)
+			go func() { errs <- e.RunMacro("call", "rose", []string{"Rose Tyler", "Jackie"}) }()
+			res := <-listener
+			So(res.Name, ShouldEqual, "_")
+			So(res.Payload, ShouldResemble, []string{"greeting Jackie"})
+			res = <-listener
+			So(res.Payload, ShouldResemble, []string{"rose", ":waves to Jackie (Jackie Tyler) $1"})
+			res = <-listener
+			So(res.Name, ShouldEqual, "greet")
+			So(<-errs, ShouldBeNil)
+		})
+
+		Convey("They stop at the first error", func() {
+			go func() {
+				for range listener {
+				}
+			}()
+			err := e.RunMacro("broken", "", nil)
+			So(err.Error(), ShouldEqual, "macro broken failed running /bad-wolf. unknown macro bad-wolf")
+			err = e.RunMacro("forever", "", nil)
+			So(err.Error(), ShouldEndWith, "macro forever nested too deeply")
+			So(e.RunMacro("nonesuch", "", nil).Error(), ShouldEqual, "unknown macro nonesuch")
+		})
 	})
 }
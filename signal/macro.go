@@ -0,0 +1,102 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package signal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxMacroDepth is how deeply macros may call other macros before giving up,
+// so that a macro which calls itself doesn't run forever.
+const maxMacroDepth = 16
+
+var paramRE = regexp.MustCompile(`\$(\$|\d+)`)
+
+// SetMacros replaces the macros the dispatcher knows about with the given map
+// of macro name to commands.
+func (e *Dispatcher) SetMacros(macros map[string][]string) {
+	e.macrosMu.Lock()
+	defer e.macrosMu.Unlock()
+	e.macros = map[string][]string{}
+	for name, commands := range macros {
+		e.macros[name] = commands
+	}
+}
+
+// macro returns the commands for the macro with the given name.
+func (e *Dispatcher) macro(name string) ([]string, bool) {
+	e.macrosMu.RLock()
+	defer e.macrosMu.RUnlock()
+	commands, ok := e.macros[name]
+	return commands, ok
+}
+
+// RunMacro runs the macro with the given name, substituting the params for $0,
+// $1, and so on. Commands starting with a slash are dispatched, and anything
+// else is sent to the given world (or the current world, if blank).
+func (e *Dispatcher) RunMacro(name, world string, params []string) error {
+	return e.runMacro(name, world, params, 0)
+}
+
+func (e *Dispatcher) runMacro(name, world string, params []string, depth int) error {
+	log.Tracef("running macro %s for %q with %q", name, world, params)
+	if depth >= maxMacroDepth {
+		return fmt.Errorf("macro %s nested too deeply", name)
+	}
+	commands, ok := e.macro(name)
+	if !ok {
+		return fmt.Errorf("unknown macro %s", name)
+	}
+	for _, command := range commands {
+		command = expandParams(command, params)
+		if strings.HasPrefix(command, "/") {
+			s := strings.SplitN(command[1:], " ", 2)
+			if len(s) == 1 {
+				s = append(s, "")
+			}
+			result := e.handle(s[0], s[1], world, depth+1)
+			e.Deliver(result)
+			if result.Err != nil {
+				return fmt.Errorf("macro %s failed running %s. %v", name, command, result.Err)
+			}
+			continue
+		}
+		e.Deliver(Signal{
+			Name:    "_client:send",
+			Payload: []string{world, command},
+		})
+	}
+	return nil
+}
+
+// macroParams turns the arguments a macro was called with into its params: $0
+// is all of the arguments, and $1 onwards are each word.
+func macroParams(args string) []string {
+	params := []string{args}
+	if args != "" {
+		params = append(params, wsRE.Split(args, -1)...)
+	}
+	return params
+}
+
+// expandParams replaces $0, $1, and so on in the command with the matching
+// param, or nothing if there aren't that many. $$ is a literal $.
+func expandParams(command string, params []string) string {
+	return paramRE.ReplaceAllStringFunc(command, func(param string) string {
+		if param == "$$" {
+			return "$"
+		}
+		i, err := strconv.Atoi(param[1:])
+		if err != nil || i >= len(params) {
+			return ""
+		}
+		return params[i]
+	})
+}
@@ -0,0 +1,213 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+// Package styled provides a model of a line of text along with the styles
+// applied to spans of it. Lines from the server are parsed once, modified by
+// triggers, and rendered to ANSI, plain text, or HTML as needed by each output.
+package styled
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sgrRe matches SGR (color and attribute) ANSI escape codes.
+var sgrRe = regexp.MustCompile("\x1b\\[([0-9;]*)m")
+
+// Span is a style applied to the text between two byte offsets in a line.
+type Span struct {
+	Start int
+	End   int
+	Style Style
+}
+
+// Line is a line of text with styles applied to spans of it. Spans may overlap,
+// in which case a span contained within another takes precedence over it, so
+// that hiliting a name within a hilited line works no matter the order the
+// two are applied. Otherwise, spans added later take precedence.
+type Line struct {
+	Text  string
+	Spans []Span
+}
+
+// Parse returns a line parsed from a string containing ANSI escape codes.
+func Parse(s string) *Line {
+	l := &Line{}
+	var text strings.Builder
+	var style Style
+	runStart := 0
+	flush := func() {
+		if text.Len() > runStart && !style.IsZero() {
+			l.Spans = append(l.Spans, Span{Start: runStart, End: text.Len(), Style: style})
+		}
+		runStart = text.Len()
+	}
+	last := 0
+	for _, match := range sgrRe.FindAllStringSubmatchIndex(s, -1) {
+		text.WriteString(s[last:match[0]])
+		last = match[1]
+		next := style.applySGR(s[match[2]:match[3]])
+		if next != style {
+			flush()
+			style = next
+		}
+	}
+	text.WriteString(s[last:])
+	flush()
+	l.Text = text.String()
+	return l
+}
+
+// Clone returns a copy of the line.
+func (l *Line) Clone() *Line {
+	return &Line{
+		Text:  l.Text,
+		Spans: append([]Span(nil), l.Spans...),
+	}
+}
+
+// Apply applies a style to the text between two byte offsets.
+func (l *Line) Apply(start, end int, style Style) {
+	if start >= end || style.IsZero() {
+		return
+	}
+	l.Spans = append(l.Spans, Span{Start: start, End: end, Style: style})
+}
+
+// Replace replaces the text between two byte offsets. The replacement takes
+// on the style of the first character it replaces, and the styles of the text
+// after it are kept.
+func (l *Line) Replace(start, end int, replacement string) {
+	newEnd := start + len(replacement)
+	delta := newEnd - end
+	var spans []Span
+	for _, span := range l.Spans {
+		switch {
+		case span.End <= start:
+			// Before the replacement.
+		case span.Start >= end && span.Start > start:
+			// After the replacement.
+			span.Start += delta
+			span.End += delta
+		case span.Start <= start && span.End >= end:
+			// Covering the replacement.
+			span.End += delta
+		case span.Start <= start:
+			// Starting before the replacement and ending within it.
+			span.End = newEnd
+		case span.End > end:
+			// Starting within the replacement and ending after it.
+			span.Start = newEnd
+			span.End += delta
+		default:
+			// Entirely within the replacement.
+			continue
+		}
+		if span.Start < span.End {
+			spans = append(spans, span)
+		}
+	}
+	l.Text = l.Text[:start] + replacement + l.Text[end:]
+	l.Spans = spans
+}
+
+// ReplaceAll replaces each match of the regexp within the text with the
+// template, in which capture groups may be used as $1 or ${name}. It returns
+// whether or not there were any matches.
+func (l *Line) ReplaceAll(re *regexp.Regexp, template string) bool {
+	original := l.Text
+	matches := re.FindAllStringSubmatchIndex(original, -1)
+	// Work through matches backwards so that earlier offsets remain valid.
+	for i := len(matches) - 1; i >= 0; i-- {
+		match := matches[i]
+		l.Replace(match[0], match[1], string(re.ExpandString(nil, template, original, match)))
+	}
+	return len(matches) != 0
+}
+
+// Plain returns the text of the line without any styles.
+func (l *Line) Plain() string {
+	return l.Text
+}
+
+// String returns the line rendered with ANSI escape codes.
+func (l *Line) String() string {
+	return l.ANSI()
+}
+
+// ANSI returns the line rendered with ANSI escape codes.
+func (l *Line) ANSI() string {
+	var b strings.Builder
+	var prev Style
+	l.segments(func(text string, style Style) {
+		b.WriteString(transition(prev, style))
+		b.WriteString(text)
+		prev = style
+	})
+	b.WriteString(transition(prev, Style{}))
+	return b.String()
+}
+
+// HTML returns the line rendered as HTML, with each styled segment wrapped in
+// a span element.
+func (l *Line) HTML() string {
+	var b strings.Builder
+	l.segments(func(text string, style Style) {
+		text = html.EscapeString(text)
+		if css := style.css(); css != "" {
+			b.WriteString(`<span style="` + css + `">` + text + `</span>`)
+		} else {
+			b.WriteString(text)
+		}
+	})
+	return b.String()
+}
+
+// segments calls the function with each run of text sharing the same style,
+// in order.
+func (l *Line) segments(fn func(text string, style Style)) {
+	// Resolve styles from the widest span to the narrowest, keeping the order
+	// in which they were added otherwise.
+	spans := make([]Span, 0, len(l.Spans))
+	bounds := []int{0, len(l.Text)}
+	for _, span := range l.Spans {
+		if span.Start < 0 || span.End > len(l.Text) || span.Start >= span.End {
+			continue
+		}
+		spans = append(spans, span)
+		bounds = append(bounds, span.Start, span.End)
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].End-spans[i].Start > spans[j].End-spans[j].Start
+	})
+	sort.Ints(bounds)
+
+	var text strings.Builder
+	var current Style
+	for i := 0; i < len(bounds)-1; i++ {
+		start, end := bounds[i], bounds[i+1]
+		if start == end {
+			continue
+		}
+		var style Style
+		for _, span := range spans {
+			if span.Start <= start && span.End >= end {
+				style = style.Merge(span.Style)
+			}
+		}
+		if style != current && text.Len() != 0 {
+			fn(text.String(), current)
+			text.Reset()
+		}
+		current = style
+		text.WriteString(l.Text[start:end])
+	}
+	if text.Len() != 0 {
+		fn(text.String(), current)
+	}
+}
@@ -0,0 +1,284 @@
+// Stimmtausch - a MU* client - https://stimmtausch.com
+//
+// https://github.com/makyo/stimmtausch
+// Copyright © 2019 the Stimmtausch authors
+// Released under the MIT license.
+
+package styled
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Style holds the attributes and colors applied to a span of text. Colors are
+// held as SGR parameters, such as "36", "38;5;208", or "38;2;255;0;0", and are
+// empty if unset.
+type Style struct {
+	Foreground    string
+	Background    string
+	Bold          bool
+	Faint         bool
+	Italic        bool
+	Underline     bool
+	Blink         bool
+	Reverse       bool
+	Strikethrough bool
+}
+
+// IsZero returns whether or not the style sets anything.
+func (s Style) IsZero() bool {
+	return s == Style{}
+}
+
+// Merge returns the style with anything set in the other style applied on top
+// of it.
+func (s Style) Merge(other Style) Style {
+	if other.Foreground != "" {
+		s.Foreground = other.Foreground
+	}
+	if other.Background != "" {
+		s.Background = other.Background
+	}
+	s.Bold = s.Bold || other.Bold
+	s.Faint = s.Faint || other.Faint
+	s.Italic = s.Italic || other.Italic
+	s.Underline = s.Underline || other.Underline
+	s.Blink = s.Blink || other.Blink
+	s.Reverse = s.Reverse || other.Reverse
+	s.Strikethrough = s.Strikethrough || other.Strikethrough
+	return s
+}
+
+// applySGR returns the style with the parameters of an SGR escape code (the
+// part between `\x1b[` and `m`) applied to it. Unknown parameters are ignored.
+func (s Style) applySGR(params string) Style {
+	if params == "" {
+		return Style{}
+	}
+	parts := strings.Split(params, ";")
+	for i := 0; i < len(parts); i++ {
+		code, err := strconv.Atoi(parts[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case code == 0:
+			s = Style{}
+		case code == 1:
+			s.Bold = true
+		case code == 2:
+			s.Faint = true
+		case code == 3:
+			s.Italic = true
+		case code == 4:
+			s.Underline = true
+		case code == 5 || code == 6:
+			s.Blink = true
+		case code == 7:
+			s.Reverse = true
+		case code == 9:
+			s.Strikethrough = true
+		case code == 22:
+			s.Bold, s.Faint = false, false
+		case code == 23:
+			s.Italic = false
+		case code == 24:
+			s.Underline = false
+		case code == 25:
+			s.Blink = false
+		case code == 27:
+			s.Reverse = false
+		case code == 29:
+			s.Strikethrough = false
+		case code >= 30 && code <= 37, code >= 90 && code <= 97:
+			s.Foreground = parts[i]
+		case code == 39:
+			s.Foreground = ""
+		case code >= 40 && code <= 47, code >= 100 && code <= 107:
+			s.Background = parts[i]
+		case code == 49:
+			s.Background = ""
+		case code == 38 || code == 48:
+			// Extended colors take either 5;n or 2;r;g;b as further parameters.
+			n := 0
+			if i+1 < len(parts) {
+				switch parts[i+1] {
+				case "5":
+					n = 2
+				case "2":
+					n = 4
+				}
+			}
+			if n == 0 || i+n >= len(parts) {
+				i = len(parts)
+				continue
+			}
+			color := strings.Join(parts[i:i+n+1], ";")
+			if code == 38 {
+				s.Foreground = color
+			} else {
+				s.Background = color
+			}
+			i += n
+		}
+	}
+	return s
+}
+
+// ParseStyle returns the style in effect at the end of a string containing
+// ANSI escape codes, such as those produced by ansigo.
+func ParseStyle(s string) Style {
+	var style Style
+	for _, match := range sgrRe.FindAllStringSubmatch(s, -1) {
+		style = style.applySGR(match[1])
+	}
+	return style
+}
+
+// transition returns the ANSI escape codes needed to go from one style to
+// another. Attributes which are turned off are closed first, followed by any
+// which are turned on.
+func transition(from, to Style) string {
+	var codes []string
+	reopenBold, reopenFaint := false, false
+	if (from.Bold && !to.Bold) || (from.Faint && !to.Faint) {
+		// 22 turns off both bold and faint, so turn back on whichever remains.
+		codes = append(codes, "22")
+		reopenBold, reopenFaint = to.Bold, to.Faint
+	}
+	for _, attr := range []struct {
+		from, to bool
+		off      string
+	}{
+		{from.Italic, to.Italic, "23"},
+		{from.Underline, to.Underline, "24"},
+		{from.Blink, to.Blink, "25"},
+		{from.Reverse, to.Reverse, "27"},
+		{from.Strikethrough, to.Strikethrough, "29"},
+	} {
+		if attr.from && !attr.to {
+			codes = append(codes, attr.off)
+		}
+	}
+	if from.Foreground != "" && to.Foreground == "" {
+		codes = append(codes, "39")
+	}
+	if from.Background != "" && to.Background == "" {
+		codes = append(codes, "49")
+	}
+	if to.Foreground != "" && to.Foreground != from.Foreground {
+		codes = append(codes, to.Foreground)
+	}
+	if to.Background != "" && to.Background != from.Background {
+		codes = append(codes, to.Background)
+	}
+	for _, attr := range []struct {
+		from, to, reopen bool
+		on               string
+	}{
+		{from.Bold, to.Bold, reopenBold, "1"},
+		{from.Faint, to.Faint, reopenFaint, "2"},
+		{from.Italic, to.Italic, false, "3"},
+		{from.Underline, to.Underline, false, "4"},
+		{from.Blink, to.Blink, false, "5"},
+		{from.Reverse, to.Reverse, false, "7"},
+		{from.Strikethrough, to.Strikethrough, false, "9"},
+	} {
+		if attr.to && (!attr.from || attr.reopen) {
+			codes = append(codes, attr.on)
+		}
+	}
+	var b strings.Builder
+	for _, code := range codes {
+		fmt.Fprintf(&b, "\x1b[%sm", code)
+	}
+	return b.String()
+}
+
+// css returns the style as the contents of an HTML style attribute.
+func (s Style) css() string {
+	var decls []string
+	fg, bg := cssColor(s.Foreground), cssColor(s.Background)
+	if s.Reverse {
+		fg, bg = bg, fg
+	}
+	if fg != "" {
+		decls = append(decls, "color: "+fg)
+	}
+	if bg != "" {
+		decls = append(decls, "background-color: "+bg)
+	}
+	if s.Bold {
+		decls = append(decls, "font-weight: bold")
+	}
+	if s.Faint {
+		decls = append(decls, "opacity: 0.5")
+	}
+	if s.Italic {
+		decls = append(decls, "font-style: italic")
+	}
+	var decorations []string
+	if s.Underline {
+		decorations = append(decorations, "underline")
+	}
+	if s.Strikethrough {
+		decorations = append(decorations, "line-through")
+	}
+	if s.Blink {
+		decorations = append(decorations, "blink")
+	}
+	if len(decorations) != 0 {
+		decls = append(decls, "text-decoration: "+strings.Join(decorations, " "))
+	}
+	return strings.Join(decls, "; ")
+}
+
+// basicColors holds the CSS colors for the sixteen basic ANSI colors.
+var basicColors = []string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// cubeLevels holds the intensities of each step in the 256-color cube.
+var cubeLevels = []int{0, 95, 135, 175, 215, 255}
+
+// cssColor returns the CSS color for the SGR parameters of a color, or an
+// empty string if there isn't one.
+func cssColor(params string) string {
+	parts := strings.Split(params, ";")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return ""
+		}
+		nums[i] = n
+	}
+	switch {
+	case len(nums) == 1 && nums[0] >= 30 && nums[0] <= 37:
+		return basicColors[nums[0]-30]
+	case len(nums) == 1 && nums[0] >= 40 && nums[0] <= 47:
+		return basicColors[nums[0]-40]
+	case len(nums) == 1 && nums[0] >= 90 && nums[0] <= 97:
+		return basicColors[nums[0]-90+8]
+	case len(nums) == 1 && nums[0] >= 100 && nums[0] <= 107:
+		return basicColors[nums[0]-100+8]
+	case len(nums) == 3 && nums[1] == 5:
+		n := nums[2]
+		switch {
+		case n < 16:
+			return basicColors[n]
+		case n < 232:
+			n -= 16
+			return fmt.Sprintf("#%02x%02x%02x", cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6])
+		case n < 256:
+			level := 8 + (n-232)*10
+			return fmt.Sprintf("#%02x%02x%02x", level, level, level)
+		}
+	case len(nums) == 5 && nums[1] == 2:
+		return fmt.Sprintf("#%02x%02x%02x", nums[2]&0xff, nums[3]&0xff, nums[4]&0xff)
+	}
+	return ""
+}
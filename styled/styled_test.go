@@ -0,0 +1,106 @@
+package styled_test
+
+import (
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/makyo/stimmtausch/styled"
+)
+
+func TestStyled(t *testing.T) {
+	Convey("When parsing lines", t, func() {
+
+		Convey("Plain text has no spans", func() {
+			l := styled.Parse("Hello, Rose.")
+			So(l.Text, ShouldEqual, "Hello, Rose.")
+			So(l.Spans, ShouldBeEmpty)
+		})
+
+		Convey("ANSI escape codes become spans", func() {
+			l := styled.Parse("Hello, \x1b[1;36mRose\x1b[22m Tyler\x1b[0m.")
+			So(l.Text, ShouldEqual, "Hello, Rose Tyler.")
+			So(l.Spans, ShouldResemble, []styled.Span{
+				styled.Span{Start: 7, End: 11, Style: styled.Style{Foreground: "36", Bold: true}},
+				styled.Span{Start: 11, End: 17, Style: styled.Style{Foreground: "36"}},
+			})
+		})
+
+		Convey("Extended colors are understood", func() {
+			l := styled.Parse("\x1b[38;5;208mDonna\x1b[48;2;0;0;128m Noble\x1b[m")
+			So(l.Spans, ShouldResemble, []styled.Span{
+				styled.Span{Start: 0, End: 5, Style: styled.Style{Foreground: "38;5;208"}},
+				styled.Span{Start: 5, End: 11, Style: styled.Style{Foreground: "38;5;208", Background: "48;2;0;0;128"}},
+			})
+		})
+
+		Convey("Styles may be parsed on their own", func() {
+			So(styled.ParseStyle("\x1b[32m\x1b[1m"), ShouldResemble, styled.Style{Foreground: "32", Bold: true})
+		})
+	})
+
+	Convey("When styling lines", t, func() {
+
+		Convey("Styles may be applied to spans", func() {
+			l := styled.Parse("Hello, Rose.")
+			l.Apply(7, 11, styled.Style{Foreground: "36"})
+			So(l.ANSI(), ShouldEqual, "Hello, \x1b[36mRose\x1b[39m.")
+		})
+
+		Convey("Narrower spans take precedence no matter the order", func() {
+			l := styled.Parse("Hello, Rose.")
+			l.Apply(7, 11, styled.Style{Foreground: "35"})
+			l.Apply(0, 12, styled.Style{Foreground: "36", Underline: true})
+			So(l.ANSI(), ShouldEqual, "\x1b[36m\x1b[4mHello, \x1b[35mRose\x1b[36m.\x1b[24m\x1b[39m")
+		})
+
+		Convey("Later spans take precedence otherwise", func() {
+			l := styled.Parse("Hello, Rose.")
+			l.Apply(0, 5, styled.Style{Foreground: "35"})
+			l.Apply(0, 5, styled.Style{Foreground: "36"})
+			So(l.ANSI(), ShouldEqual, "\x1b[36mHello\x1b[39m, Rose.")
+		})
+
+		Convey("Bold and faint may be turned off separately", func() {
+			l := styled.Parse("\x1b[1;2mRose\x1b[22;1m Tyler\x1b[0m")
+			So(l.ANSI(), ShouldEqual, "\x1b[1m\x1b[2mRose\x1b[22m\x1b[1m Tyler\x1b[22m")
+		})
+	})
+
+	Convey("When replacing text", t, func() {
+		l := styled.Parse("\x1b[35m[Public]\x1b[0m Donna says, \x1b[36m\"Oi!\"\x1b[0m")
+
+		Convey("The replacement takes on the style of what it replaced", func() {
+			So(l.ReplaceAll(regexp.MustCompile(`^\[(\w+)\]`), "$1>"), ShouldBeTrue)
+			So(l.Text, ShouldEqual, "Public> Donna says, \"Oi!\"")
+			So(l.ANSI(), ShouldEqual, "\x1b[35mPublic>\x1b[39m Donna says, \x1b[36m\"Oi!\"\x1b[39m")
+		})
+
+		Convey("Styles after the replacement are kept", func() {
+			So(l.ReplaceAll(regexp.MustCompile(`Donna says`), "Donna Noble says"), ShouldBeTrue)
+			So(l.ANSI(), ShouldEqual, "\x1b[35m[Public]\x1b[39m Donna Noble says, \x1b[36m\"Oi!\"\x1b[39m")
+		})
+
+		Convey("Styles entirely within the replacement are dropped", func() {
+			So(l.ReplaceAll(regexp.MustCompile(`says, ".*"`), "shouts."), ShouldBeTrue)
+			So(l.ANSI(), ShouldEqual, "\x1b[35m[Public]\x1b[39m Donna shouts.")
+		})
+
+		Convey("It reports when nothing matched", func() {
+			So(l.ReplaceAll(regexp.MustCompile(`Rose`), "Bad Wolf"), ShouldBeFalse)
+		})
+	})
+
+	Convey("When rendering lines", t, func() {
+		l := styled.Parse("Hello, \x1b[1;36mRose\x1b[0m & \x1b[38;5;196mDonna\x1b[0m")
+
+		Convey("They may be rendered as plain text", func() {
+			So(l.Plain(), ShouldEqual, "Hello, Rose & Donna")
+		})
+
+		Convey("They may be rendered as HTML", func() {
+			So(l.HTML(), ShouldEqual, `Hello, <span style="color: #00cdcd; font-weight: bold">Rose</span> &amp; <span style="color: #ff0000">Donna</span>`)
+		})
+	})
+}
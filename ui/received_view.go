@@ -16,6 +16,9 @@ type receivedView struct {
 	// The display name to show in the UI
 	displayName string
 
+	// The route the view shows lines for, if it isn't the world's own view.
+	route string
+
 	// The name of the gotui View
 	viewName string
 
@@ -40,6 +43,10 @@ type receivedView struct {
 	// How many more lines we have
 	more int
 
+	// Whether or not a notify trigger has matched since the world was last
+	// current.
+	urgent bool
+
 	// Our current origin Y
 	scrollPos int
 
@@ -47,6 +54,15 @@ type receivedView struct {
 	index int
 }
 
+// name returns the name the view is switched to by, which is the name of the
+// connection, followed by the route for routes' views.
+func (v *receivedView) name() string {
+	if v.route == "" {
+		return v.connName
+	}
+	return v.connName + "/" + v.route
+}
+
 // updateRecvOrigin updates the origin of every output gotui.View according to
 // how many lines are in the buffer.
 func (v *receivedView) updateRecvOrigin(index int, g *gotui.Gui, t *tui) error {
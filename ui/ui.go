@@ -19,6 +19,7 @@ import (
 	"github.com/makyo/gotui"
 
 	"github.com/makyo/stimmtausch/client"
+	"github.com/makyo/stimmtausch/connection"
 	"github.com/makyo/stimmtausch/help"
 	"github.com/makyo/stimmtausch/signal"
 	"github.com/makyo/stimmtausch/util"
@@ -64,9 +65,16 @@ func (t *tui) connect(name string, g *gotui.Gui) error {
 	connName := conn.GetConnectionName()
 
 	for _, v := range t.views {
-		if connName == v.connName {
+		if connName == v.connName && v.route == "" {
 			v.conn = conn
 			conn.AddOutput(v.viewName, v.buffer, true)
+			// Routes' views keep showing their lines from the new connection.
+			for _, rv := range t.views {
+				if rv.connName == connName && rv.route != "" {
+					rv.conn = conn
+					conn.AddRouteOutput(rv.route, rv.viewName, rv.buffer, true)
+				}
+			}
 			log.Tracef("opening connection for %s", name)
 			err := conn.Open()
 			if err != nil {
@@ -75,7 +83,7 @@ func (t *tui) connect(name string, g *gotui.Gui) error {
 			}
 			t.currView = v
 			t.currViewIndex = v.index
-			t.currView.connected = conn.Connected
+			t.currView.connected = conn.IsConnected()
 			t.updateSendTitle()
 			return nil
 		}
@@ -137,12 +145,65 @@ func (t *tui) connect(name string, g *gotui.Gui) error {
 			log.Errorf("unable to open connection for %s: %v", name, err)
 			return errgo.Mask(err)
 		}
-		t.currView.connected = conn.Connected
+		t.currView.connected = conn.IsConnected()
+		for _, route := range conn.Routes() {
+			if err := t.addRouteView(conn, route, g); err != nil {
+				return errgo.Mask(err)
+			}
+		}
 		t.updateSendTitle()
 	}
 	return nil
 }
 
+// addRouteView constructs a receivedView to hold the lines which route
+// triggers send to the given route for a connection.
+func (t *tui) addRouteView(conn *connection.Connection, route string, g *gotui.Gui) error {
+	connName := conn.GetConnectionName()
+	for _, v := range t.views {
+		if v.connName == connName && v.route == route {
+			return nil
+		}
+	}
+	viewName := fmt.Sprintf("recv%d", len(t.views))
+	log.Tracef("building received view %s for route %s on %s", viewName, route, connName)
+	v, err := g.SetView(viewName, -3, -3, -1, -1)
+	if err != gotui.ErrUnknownView {
+		if err == nil {
+			err = errgo.Newf("view %s already exists", viewName)
+		}
+		log.Warningf("unable to create view %+v", err)
+		return errgo.Mask(err)
+	}
+	fmt.Fprintln(v, ansi.MaybeApply("bold+243", "\nLines for "+route+" from "+conn.GetDisplayName()+"\n"))
+	v.Wrap = true
+	v.WordWrap = true
+	v.IndentFirst = t.client.Config.Client.UI.IndentFirst
+	v.IndentSubsequent = t.client.Config.Client.UI.IndentSubsequent
+	v.Frame = false
+	rv := &receivedView{
+		connName:    connName,
+		displayName: conn.GetDisplayName() + "/" + route,
+		route:       route,
+		conn:        conn,
+		viewName:    viewName,
+		buffer:      NewHistory(t.client.Config.Client.UI.Scrollback, false),
+		maxBuffer:   conn.GetMaxBuffer(),
+		connected:   conn.IsConnected(),
+		index:       len(t.views),
+	}
+	t.views = append(t.views, rv)
+	rv.buffer.AddPostWriteHook(func(line *HistoryLine) error {
+		fmt.Fprint(v, line.Text)
+		g.Update(func(gg *gotui.Gui) error {
+			return errgo.Mask(rv.updateRecvOrigin(t.currViewIndex, gg, t))
+		})
+		return nil
+	})
+	conn.AddRouteOutput(route, viewName, rv.buffer, true)
+	return errgo.Mask(rv.updateRecvOrigin(t.currViewIndex, g, t))
+}
+
 // postCreate finishes setting up stuff after the ui has been built for the
 // first time.
 func (t *tui) postCreate(g *gotui.Gui) error {
@@ -166,8 +227,10 @@ func (t *tui) postCreate(g *gotui.Gui) error {
 			// The only case in which the UI dispatches is if there's no client
 			// to do so. We want the client to do it usually this UI may not be
 			// the only one.
-			if line.Text[0] == '/' {
-				s := strings.SplitN(line.Text[1:], " ", 2)
+			// Aliases still apply, though only those for all worlds.
+			text := t.client.Config.ApplyAliases("", line.Text)
+			if len(text) != 0 && text[0] == '/' {
+				s := strings.SplitN(text[1:], " ", 2)
 				if len(s) == 1 {
 					s = append(s, "")
 				}
@@ -275,9 +338,11 @@ func (t *tui) updateSendTitle() {
 			if !ok {
 				continue
 			}
-			connected := c.Connected
+			connected := c.IsConnected()
 			v.connected = connected
-			if v.current {
+			if v.urgent && !v.current {
+				conns[i] = ansi.MaybeApplyWithReset(t.client.Config.Client.UI.Colors.SendTitle.Urgent, title)
+			} else if v.current {
 				if connected {
 					if v.hasMore {
 						conns[i] = ansi.MaybeApplyWithReset(t.client.Config.Client.UI.Colors.SendTitle.ActiveMore, title)
@@ -309,6 +374,10 @@ func (t *tui) updateSendTitle() {
 		}
 		t.title = strings.Join(conns, sep)
 	}
+	if t.client.DoNotDisturb() {
+		t.title += " (do not disturb)"
+		t.titleLen += len(" (do not disturb)")
+	}
 	log.Tracef("setting title to %s", t.title)
 	if v, err := t.g.SetView("title", 1, maxY-6, t.titleLen+3, maxY-4); err == nil {
 		t.g.Update(func(_ *gotui.Gui) error {
@@ -335,8 +404,8 @@ func (t *tui) switchConn(action, conn string) error {
 					i = -1
 					continue
 				}
-				if t.views[i].hasMore {
-					return errgo.Mask(t.switchConn("switch", t.views[i].connName))
+				if t.views[i].hasMore || t.views[i].urgent {
+					return errgo.Mask(t.switchConn("switch", t.views[i].name()))
 				}
 			}
 		} else if conn == "-1" {
@@ -345,8 +414,8 @@ func (t *tui) switchConn(action, conn string) error {
 					i = len(t.views)
 					continue
 				}
-				if t.views[i].hasMore {
-					return errgo.Mask(t.switchConn("switch", t.views[i].connName))
+				if t.views[i].hasMore || t.views[i].urgent {
+					return errgo.Mask(t.switchConn("switch", t.views[i].name()))
 				}
 			}
 		}
@@ -372,7 +441,7 @@ func (t *tui) switchConn(action, conn string) error {
 		found := false
 		var newIndex int
 		for i, v := range t.views {
-			if v.connName == conn {
+			if v.name() == conn {
 				log.Tracef("switching to %s", conn)
 				v.current = true
 				newIndex = i
@@ -390,6 +459,7 @@ func (t *tui) switchConn(action, conn string) error {
 		return errgo.Newf("received unexpected action %s", action)
 	}
 	t.currView = t.views[t.currViewIndex]
+	t.currView.urgent = false
 	for _, v := range t.views {
 		if err := v.updateRecvOrigin(t.currViewIndex, t.g, t); err != nil {
 			return errgo.Mask(err)
@@ -399,23 +469,42 @@ func (t *tui) switchConn(action, conn string) error {
 	return nil
 }
 
-// removeWorld removes a world from the UI.
+// removeWorld removes a world from the UI, along with the views for its
+// routes.
 func (t *tui) removeWorld(world string) error {
-	var r *receivedView
-	for i, v := range t.views {
+	var removed []*receivedView
+	var views []*receivedView
+	for _, v := range t.views {
 		if v.connName == world {
-			r = v
-			t.views = append(t.views[:i], t.views[i+1:]...)
-			i++
-			if len(t.views) > 0 {
-				t.switchConn("rotate", "1")
-			} else {
-				t.currView = nil
+			removed = append(removed, v)
+			continue
+		}
+		v.index = len(views)
+		views = append(views, v)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	t.views = views
+	if len(t.views) > 0 {
+		if t.currViewIndex >= len(t.views) {
+			t.currViewIndex = 0
+		}
+		for _, v := range t.views {
+			v.current = false
+		}
+		t.currView = t.views[t.currViewIndex]
+		t.currView.current = true
+		for _, v := range t.views {
+			if err := v.updateRecvOrigin(t.currViewIndex, t.g, t); err != nil {
+				return errgo.Mask(err)
 			}
-			break
 		}
+	} else {
+		t.currView = nil
 	}
-	if r != nil {
+	for _, r := range removed {
+		r := r
 		log.Infof("attempting to removew view %s", r.viewName)
 		go t.g.Update(func(g *gotui.Gui) error {
 			if err := g.DeleteView(r.viewName); err != nil {
@@ -452,6 +541,45 @@ func (t *tui) listen() {
 			res.Payload = append(res.Payload, t.currView.connName)
 			log.Tracef("disconnecting current world %+v", res)
 			go t.client.Env.DirectDispatch(res)
+		case "stats":
+			// If it's a stats request without a payload, redispatch with the
+			// current connection's name.
+			if len(res.Payload) != 0 || t.currView == nil {
+				continue
+			}
+			res.Payload = []string{t.currView.connName}
+			go t.client.Env.DirectDispatch(res)
+		case "watch", "unwatch":
+			// If it's for no world in particular, redispatch with the
+			// current connection's name.
+			if res.Err != nil || len(res.Payload) != 2 || res.Payload[0] != "" || t.currView == nil {
+				continue
+			}
+			res.Payload = []string{t.currView.connName, res.Payload[1]}
+			go t.client.Env.DirectDispatch(res)
+		case "trigger":
+			// If it's a trigger test without a world, redispatch with the
+			// current connection's world.
+			if res.Err != nil || len(res.Payload) != 3 || res.Payload[0] != "test" || res.Payload[1] != "" || t.currView == nil {
+				continue
+			}
+			conn, ok := t.client.Conn(t.currView.connName)
+			if !ok {
+				continue
+			}
+			res.Payload = []string{"test", conn.GetWorldName(), res.Payload[2]}
+			go t.client.Env.DirectDispatch(res)
+		case "_client:send", "_client:echo":
+			// If it's a send or echo without a world, use the current
+			// connection.
+			if len(res.Payload) < 2 || res.Payload[0] != "" || t.currView == nil {
+				continue
+			}
+			if res.Name == "_client:send" {
+				t.client.Send(t.currView.connName, res.Payload[1:]...)
+			} else {
+				t.client.Echo(t.currView.connName, res.Payload[1:]...)
+			}
 		case "help":
 			// get the command text and tell the system to display it in a modal
 			var cmd string
@@ -473,6 +601,20 @@ func (t *tui) listen() {
 			if err != nil {
 				log.Errorf("error setting up connection in ui: %v", err)
 			}
+		case "_client:route":
+			// Build a view for a route which has gained a trigger since the
+			// world was connected.
+			if len(res.Payload) != 2 {
+				continue
+			}
+			conn, ok := t.client.Conn(res.Payload[0])
+			if !ok {
+				continue
+			}
+			if err := t.addRouteView(conn, res.Payload[1], t.g); err != nil {
+				log.Errorf("error setting up route %s in ui: %v", res.Payload[1], err)
+			}
+			t.updateSendTitle()
 		case "_client:disconnect":
 			// Grey out tab in send title, grey out text in receivedView.
 			t.updateSendTitle()
@@ -491,6 +633,20 @@ func (t *tui) listen() {
 			go t.client.Env.DirectDispatch(res)
 		case "_tui:showModal":
 			t.createModal(res.Payload[0], res.Payload[1])
+		case "_tui:notify":
+			// Mark the world's tab in the send title, unless it's the one
+			// being looked at.
+			for _, v := range t.views {
+				if v.connName == res.Payload[0] && v.route == "" && !v.current {
+					v.urgent = true
+				}
+			}
+			if t.client.Config.Client.Notify.Bell {
+				fmt.Fprint(os.Stdout, "\a")
+			}
+			t.updateSendTitle()
+		case "_client:doNotDisturb":
+			t.updateSendTitle()
 		case "_client:removeWorld", "remove", "r":
 			if len(res.Payload) != 1 {
 				log.Warningf("tried to remove a world without an argument")
@@ -600,6 +756,7 @@ func (t *tui) Run(done, ready chan bool) {
 		fmt.Println("Your connections will all close gracefully and any logs properly closed out.")
 	}
 	t.client.CloseAll()
+	t.client.StopPlugins()
 	done <- true
 }
 